@@ -0,0 +1,58 @@
+// Package buildinfo exposes the build metadata of the running binary.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at link time, for example:
+//
+//	go build -ldflags "-X github.com/shinshin86/vpeakserver/buildinfo.Version=v1.0.0"
+var (
+	Version = "dev"
+	Commit  = "dev"
+)
+
+// vpeakModule is the module path of the synthesis library.
+const vpeakModule = "github.com/shinshin86/vpeak"
+
+// Info is the build metadata reported by /version.
+type Info struct {
+	Version      string `json:"version"`
+	Commit       string `json:"commit"`
+	GoVersion    string `json:"go_version"`
+	VpeakVersion string `json:"vpeak_version"`
+}
+
+// Get returns the build metadata. When Commit was not set at link time the
+// VCS revision recorded by the Go toolchain is used, if any.
+func Get() Info {
+	info := Info{
+		Version:      Version,
+		Commit:       Commit,
+		GoVersion:    runtime.Version(),
+		VpeakVersion: "unknown",
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path == vpeakModule {
+			info.VpeakVersion = dep.Version
+			if dep.Replace != nil {
+				info.VpeakVersion = dep.Replace.Version
+			}
+		}
+	}
+	if info.Commit == "dev" {
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" {
+				info.Commit = setting.Value
+			}
+		}
+	}
+	return info
+}
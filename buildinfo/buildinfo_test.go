@@ -0,0 +1,22 @@
+package buildinfo
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	defer func(version, commit string) { Version, Commit = version, commit }(Version, Commit)
+	Version, Commit = "v1.2.3", "abc1234"
+
+	info := Get()
+	if info.Version != "v1.2.3" || info.Commit != "abc1234" {
+		t.Errorf("Get() = %+v, want the link-time version and commit", info)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("GoVersion = %q, want %q", info.GoVersion, runtime.Version())
+	}
+	if info.VpeakVersion == "" {
+		t.Error("VpeakVersion is empty")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/shinshin86/vpeakserver/server"
+)
+
+// newLogger builds the logger used by the whole process.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(server.ContextHandler{Handler: slog.NewTextHandler(w, opts)}), nil
+	case "json":
+		return slog.New(server.ContextHandler{Handler: slog.NewJSONHandler(w, opts)}), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q: must be text or json", format)
+}
+
+// resolveLogLevel returns the log level selected by -log-level, -quiet and
+// -verbose. -quiet keeps only errors and -verbose adds debug output, so
+// neither can be combined with the other or with an explicit -log-level.
+func resolveLogLevel(level string, levelSet, quiet, verbose bool) (string, error) {
+	switch {
+	case quiet && verbose:
+		return "", errors.New("-quiet and -verbose cannot be used together")
+	case (quiet || verbose) && levelSet:
+		return "", errors.New("-quiet and -verbose cannot be used together with -log-level")
+	case quiet:
+		return "error", nil
+	case verbose:
+		return "debug", nil
+	}
+	return level, nil
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
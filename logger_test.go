@@ -0,0 +1,82 @@
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "text", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("left out")
+	logger.Warn("kept")
+	out := buf.String()
+	if strings.Contains(out, "left out") {
+		t.Errorf("info entry logged at level warn: %s", out)
+	}
+	if !strings.Contains(out, "level=WARN") || !strings.Contains(out, "kept") {
+		t.Errorf("warn entry missing: %s", out)
+	}
+}
+
+func TestNewLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Debug("hello", "speaker", "f1")
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("entry is not JSON: %v; output: %s", err, buf.String())
+	}
+	if entry["level"] != "DEBUG" || entry["msg"] != "hello" || entry["speaker"] != "f1" {
+		t.Errorf("entry = %v", entry)
+	}
+}
+
+func TestNewLoggerRejectsInvalidSettings(t *testing.T) {
+	for _, tt := range [][2]string{{"xml", "info"}, {"text", "loud"}} {
+		if _, err := newLogger(&bytes.Buffer{}, tt[0], tt[1]); err == nil {
+			t.Errorf("newLogger(%q, %q) succeeded, want an error", tt[0], tt[1])
+		}
+	}
+}
+
+func TestResolveLogLevel(t *testing.T) {
+	tests := []struct {
+		level                    string
+		levelSet, quiet, verbose bool
+		want                     string
+		wantErr                  bool
+	}{
+		{level: "info", want: "info"},
+		{level: "warn", levelSet: true, want: "warn"},
+		{level: "info", quiet: true, want: "error"},
+		{level: "info", verbose: true, want: "debug"},
+		{level: "info", quiet: true, verbose: true, wantErr: true},
+		{level: "warn", levelSet: true, quiet: true, wantErr: true},
+		{level: "warn", levelSet: true, verbose: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveLogLevel(tt.level, tt.levelSet, tt.quiet, tt.verbose)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%+v: resolveLogLevel = %q, want an error", tt, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%+v: resolveLogLevel = %q, %v, want %q", tt, got, err, tt.want)
+		}
+	}
+}
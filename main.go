@@ -1,561 +1,423 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"html/template"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/shinshin86/vpeak"
+	"github.com/shinshin86/vpeakserver/buildinfo"
+	"github.com/shinshin86/vpeakserver/server"
 )
 
-const (
-	speedMin = 50
-	speedMax = 200
-	pitchMin = -300
-	pitchMax = 300
-)
-
-var allowedOrigin string
-var corsPolicyMode string
-var version = "dev"
-
-type AudioQuery struct {
-	Text    string `json:"text"`
-	Speaker string `json:"speaker"`
-	Emotion string `json:"emotion"`
-	Speed   *int   `json:"speed,omitempty"`
-	Pitch   *int   `json:"pitch,omitempty"`
-}
-
-type SettingsData struct {
-	CorsPolicyMode string
-	AllowOrigin    string
-	Lang           string
-}
-
-var validEmotions = map[string]bool{
-	"happy": true,
-	"fun":   true,
-	"angry": true,
-	"sad":   true,
-}
-
-func parseOptionalIntParam(raw string, min, max int) (*int, error) {
-	if raw == "" {
-		return nil, nil
+// parseIntFlag parses an optional integer flag value into dst.
+func parseIntFlag(dst **int) func(string) error {
+	return func(v string) error {
+		value, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*dst = &value
+		return nil
 	}
+}
 
-	value, err := strconv.Atoi(raw)
+// changeEngineWorkdir makes dir the working directory of the process, so
+// VOICEPEAK runs in it. vpeak starts the engine without setting a directory of
+// its own, so the engine inherits the server's.
+func changeEngineWorkdir(dir string) error {
+	info, err := os.Stat(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert to integer: %w", err)
+		return fmt.Errorf("cannot use engine working directory: %w", err)
 	}
-
-	if value < min || value > max {
-		return nil, fmt.Errorf("value must be between %d and %d", min, max)
+	if !info.IsDir() {
+		return fmt.Errorf("cannot use engine working directory: %s is not a directory", dir)
 	}
-
-	return &value, nil
+	return os.Chdir(dir)
 }
 
-func validateOptionalRange(value *int, min, max int) error {
-	if value == nil {
+// parseAutoNarrators parses a comma-separated list of lang=speaker pairs
+// into dst.
+func parseAutoNarrators(dst *map[string]string) func(string) error {
+	return func(v string) error {
+		narrators := map[string]string{}
+		for _, pair := range strings.Split(v, ",") {
+			lang, name, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || lang == "" || name == "" {
+				return fmt.Errorf("invalid pair %q: expected lang=speaker", pair)
+			}
+			narrators[lang] = name
+		}
+		*dst = narrators
 		return nil
 	}
-
-	val := *value
-	if val < min || val > max {
-		return fmt.Errorf("value must be between %d and %d", min, max)
-	}
-	return nil
 }
 
-// Middleware to handle CORS
-func enableCORS(handler http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-
-		if corsPolicyMode == "all" {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-		} else if corsPolicyMode == "localapps" {
-			if strings.HasPrefix(origin, "app://") || strings.HasPrefix(origin, "http://localhost") || origin == allowedOrigin || containsOrigin(allowedOrigin, origin) {
-				w.Header().Set("Access-Control-Allow-Origin", origin)
-			}
-		}
-
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// httpTimeouts holds the connection timeouts of the HTTP server.
+type httpTimeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Idle  time.Duration
+}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// newHTTPServer returns the HTTP server for handler. The read timeout also
+// bounds reading the request headers, so slow clients cannot hold
+// connections open indefinitely.
+func newHTTPServer(addr string, handler http.Handler, tlsConfig *tls.Config, timeouts httpTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: timeouts.Read,
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+	}
+}
 
-		handler(w, r)
+// workdir returns the working directory for logConfig.
+func workdir() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "unknown"
 	}
+	return dir
 }
 
-func containsOrigin(allowedOrigins string, origin string) bool {
-	origins := strings.Split(allowedOrigins, " ")
-	for _, o := range origins {
-		if o == origin {
-			return true
+// logConfig logs the effective configuration as a single entry. API keys
+// are only counted so they never end up in logs.
+func logConfig(cfg server.Config, addr, scheme string, timeouts httpTimeouts) {
+	optionalInt := func(v *int) any {
+		if v == nil {
+			return "default"
 		}
+		return *v
 	}
-	return false
+
+	slog.Info("Effective configuration",
+		"version", buildinfo.Version,
+		"addr", addr,
+		"scheme", scheme,
+		"read_timeout", timeouts.Read,
+		"write_timeout", timeouts.Write,
+		"idle_timeout", timeouts.Idle,
+		"cors_policy_mode", cfg.CorsPolicyMode,
+		"allowed_origin", cfg.AllowedOrigin,
+		"allowed_origins_file", cfg.AllowedOriginsFile,
+		"dictionary", cfg.DictionaryFile,
+		"presets", len(cfg.Presets),
+		"cors_max_age", cfg.CorsMaxAge,
+		"cors_allow_credentials", cfg.CorsAllowCredentials,
+		"cors_strict", cfg.CorsStrict,
+		"cors_allow_headers", cfg.CorsAllowHeaders,
+		"max_concurrent", cfg.MaxConcurrent,
+		"queue_timeout", cfg.QueueTimeout,
+		"synthesis_timeout", cfg.SynthesisTimeout,
+		"synthesis_retries", cfg.SynthesisRetries,
+		"rate_limit", cfg.RateLimit,
+		"rate_burst", cfg.RateBurst,
+		"trust_proxy", cfg.TrustProxy,
+		"api_keys", len(cfg.APIKeys),
+		"auto_narrators", cfg.AutoNarrators,
+		"default_speed", optionalInt(cfg.DefaultSpeed),
+		"default_pitch", optionalInt(cfg.DefaultPitch),
+		"max_text_length", cfg.MaxTextLength,
+		"sanitize_text", cfg.SanitizeText,
+		"normalize_unicode", cfg.NormalizeUnicode,
+		"max_text_bytes", cfg.MaxTextBytes,
+		"max_request_bytes", cfg.MaxRequestBytes,
+		"max_batch", cfg.MaxBatch,
+		"job_queue_size", cfg.JobQueueSize,
+		"job_ttl", cfg.JobTTL,
+		"sample_text", cfg.SampleText,
+		"speaker_default_emotions", cfg.SpeakerDefaultEmotions,
+		"split_on", cfg.SplitOn,
+		"segment_gap", cfg.SegmentGap,
+		"cache_size", cfg.CacheSize,
+		"cache_max_bytes", cfg.CacheMaxBytes,
+		"cache_dir", cfg.CacheDir,
+		"cache_ttl", cfg.CacheTTL,
+		"tmp_dir", cfg.TmpDir,
+		"keep_audio", cfg.KeepAudio,
+		"config", cfg.ConfigPath,
+		"engine_path", vpeak.VoicepeakPath,
+		"engine_workdir", workdir(),
+		"trim_silence", cfg.TrimSilence,
+		"normalize", cfg.Normalize,
+		"kana_command", cfg.KanaCommand,
+		"ffmpeg_path", cfg.FFmpegPath,
+		"require_engine", cfg.RequireEngine,
+		"warmup", cfg.Warmup,
+		"metrics", cfg.EnableMetrics,
+		"gzip", cfg.EnableGzip,
+		"pprof", cfg.EnablePprof,
+		"admin", cfg.EnableAdmin,
+		"recent_requests", cfg.RecentRequests,
+		"voicevox_compat", cfg.VoicevoxCompat,
+	)
 }
 
 func main() {
+	var cfg server.Config
 	var showVersion bool
-	flag.StringVar(&allowedOrigin, "allowed-origin", "", "Set the allowed CORS origin")
-	flag.StringVar(&corsPolicyMode, "cors-policy-mode", "localapps", "Set the CORS policy mode (localapps or all)")
-	flag.BoolVar(&showVersion, "version", false, "Show version")
+	var host string
+	var port int
+	var speakersFile string
+	var presetsFile string
+	var engineWorkdir string
+	var enablePprof bool
+	var pprofAddr string
+	var shutdownTimeout time.Duration
+	var timeouts httpTimeouts
+	var tlsCert, tlsKey, tlsMinVersion string
+	var apiKey, apiKeysFile string
+	var segmentGapMs int
+	var logFormat, logLevel string
+	var quiet, verbose bool
+	flag.StringVar(&cfg.AllowedOrigin, "allowed-origin", "", "Set the allowed CORS origin")
+	flag.BoolVar(&cfg.CorsStrict, "cors-strict", false, "Reject requests from disallowed origins with 403 in localapps mode")
+	flag.StringVar(&cfg.AllowedOriginsFile, "allowed-origins-file", "", "Read additional allowed CORS origins from a file, one per line")
+	flag.StringVar(&cfg.CorsPolicyMode, "cors-policy-mode", "localapps", "Set the CORS policy mode (localapps or all)")
+	flag.IntVar(&cfg.CorsMaxAge, "cors-max-age", 600, "Set how many seconds browsers may cache preflight responses (0 disables the header)")
+	flag.BoolVar(&cfg.CorsAllowCredentials, "cors-allow-credentials", false, "Allow credentialed CORS requests in localapps mode")
+	flag.Func("cors-allow-headers", "Allow these comma-separated request headers in CORS preflight responses, in addition to the ones the server reads", func(v string) error {
+		cfg.CorsAllowHeaders = strings.Split(v, ",")
+		return nil
+	})
+	flag.Float64Var(&cfg.RateLimit, "rate-limit", 0, "Set the allowed /synthesis requests per second for each client IP (0 disables rate limiting)")
+	flag.IntVar(&cfg.RateBurst, "rate-burst", 5, "Set how many /synthesis requests a client IP may make in a burst")
+	flag.BoolVar(&cfg.TrustProxy, "trust-proxy", false, "Use X-Forwarded-For to identify clients when running behind a reverse proxy")
+	flag.StringVar(&apiKey, "api-key", "", "Require this API key on /audio_query and /synthesis")
+	flag.StringVar(&apiKeysFile, "api-keys-file", "", "Require one of the API keys listed in this file (one per line)")
+	flag.Func("auto-narrators", "Pick the speaker of queries without one from the language of the text, as lang=speaker pairs (e.g. ja=f1,en=m1)", parseAutoNarrators(&cfg.AutoNarrators))
+	flag.Func("default-speed", fmt.Sprintf("Set the speed used when a request omits it (%d-%d)", server.SpeedMin, server.SpeedMax), parseIntFlag(&cfg.DefaultSpeed))
+	flag.Func("default-pitch", fmt.Sprintf("Set the pitch used when a request omits it (%d-%d)", server.PitchMin, server.PitchMax), parseIntFlag(&cfg.DefaultPitch))
+	flag.Func("split-on", `Split /synthesis text at this separator and synthesize each segment separately (Go escapes such as \n\n are allowed)`, func(v string) error {
+		sep, err := strconv.Unquote(`"` + v + `"`)
+		cfg.SplitOn = sep
+		return err
+	})
+	flag.IntVar(&segmentGapMs, "segment-gap-ms", 500, "Set the milliseconds of silence inserted between segments split with -split-on")
+	flag.IntVar(&cfg.CacheSize, "cache-size", 0, "Set how many synthesized audio files to keep in memory (0 disables the cache)")
+	flag.Int64Var(&cfg.CacheMaxBytes, "cache-max-bytes", 0, "Set the maximum total size in bytes of the audio kept in memory by -cache-size (0 means no limit)")
+	flag.StringVar(&cfg.CacheDir, "cache-dir", "", "Cache synthesized audio on disk in this directory")
+	flag.DurationVar(&cfg.CacheTTL, "cache-ttl", 24*time.Hour, "Set how long disk cache entries stay valid (0 keeps them forever)")
+	flag.IntVar(&cfg.MaxTextLength, "max-text-length", 5000, "Set the maximum number of characters in a query text (0 means no limit)")
+	flag.BoolVar(&cfg.SanitizeText, "sanitize-text", true, "Strip control and zero-width characters from query texts before synthesis")
+	flag.BoolVar(&cfg.NormalizeUnicode, "normalize-unicode", false, "Also NFKC-normalize query texts when -sanitize-text is on, folding full-width ASCII and half-width katakana")
+	flag.Int64Var(&cfg.MaxTextBytes, "max-text-bytes", 1<<20, "Set the maximum size in bytes of a text file uploaded to /synthesis_file")
+	flag.Int64Var(&cfg.MaxRequestBytes, "max-request-bytes", 1<<20, "Set the maximum size in bytes of a JSON request body")
+	flag.IntVar(&cfg.JobQueueSize, "job-queue-size", 100, "Set how many /jobs submissions may wait to run")
+	flag.DurationVar(&cfg.JobTTL, "job-ttl", time.Hour, "Set how long finished /jobs results are kept")
+	flag.BoolVar(&cfg.SpeakerDefaultEmotions, "speaker-default-emotions", false, "Use the default_emotion of a speaker from -speakers-file when a request has no supported emotion")
+	flag.StringVar(&cfg.SampleText, "sample-text", server.DefaultSampleText, "Set the phrase read by /speakers/{name}/sample")
+	flag.IntVar(&cfg.MaxBatch, "max-batch", 10, "Set the maximum number of queries in a /synthesis_batch request")
+	flag.StringVar(&host, "host", "", "Set the host to listen on (empty means all interfaces)")
+	flag.IntVar(&port, "port", 20202, "Set the port to listen on")
+	flag.IntVar(&cfg.MaxConcurrent, "max-concurrent", 4, "Set the maximum number of concurrent synthesis requests (0 means unlimited)")
+	flag.DurationVar(&cfg.QueueTimeout, "queue-timeout", 0, "Set how long a synthesis request waits for a free slot before returning 503 (0 means fail immediately)")
+	flag.IntVar(&cfg.SynthesisRetries, "synthesis-retries", 0, "Set how often a failed synthesis is retried, with exponential backoff")
+	flag.DurationVar(&cfg.SynthesisTimeout, "synthesis-timeout", 30*time.Second, "Set how long a single synthesis may run before returning 504 (0 means no limit)")
+	flag.StringVar(&tlsCert, "tls-cert", "", "Set the TLS certificate file to serve HTTPS")
+	flag.StringVar(&tlsKey, "tls-key", "", "Set the TLS private key file to serve HTTPS")
+	flag.StringVar(&tlsMinVersion, "tls-min-version", "1.2", "Set the minimum TLS version (1.0, 1.1, 1.2 or 1.3)")
+	flag.DurationVar(&timeouts.Read, "read-timeout", 30*time.Second, "Set how long reading a request may take (0 means no limit)")
+	flag.DurationVar(&timeouts.Write, "write-timeout", 10*time.Minute, "Set how long handling a request and writing the response may take, not counting /synthesis_stream and /ws/synthesis (0 means no limit)")
+	flag.DurationVar(&timeouts.Idle, "idle-timeout", 2*time.Minute, "Set how long an idle keep-alive connection is kept open (0 uses the read timeout)")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Set how long to wait for in-flight requests on shutdown")
+	flag.BoolVar(&cfg.RequireEngine, "require-engine", false, "Refuse to start when VOICEPEAK cannot be found, instead of starting with synthesis unavailable")
+	flag.BoolVar(&cfg.Warmup, "warmup", false, "Run a throwaway synthesis at startup so the first request does not wait for the engine to load (/ready answers 503 until it finishes)")
+	flag.BoolVar(&cfg.EnableMetrics, "enable-metrics", false, "Expose Prometheus metrics at /metrics")
+	flag.BoolVar(&cfg.EnableAdmin, "enable-admin", false, "Enable POST /admin/shutdown for stopping the server (requires an API key)")
+	flag.IntVar(&cfg.RecentRequests, "recent-requests", 100, "Number of recent requests listed by GET /admin/recent when -enable-admin is set (0 disables the list)")
+	flag.BoolVar(&cfg.VoicevoxCompat, "voicevox-compat", false, "Accept VOICEVOX engine API requests on /audio_query and /synthesis and list VOICEVOX styles in /speakers")
+	flag.BoolVar(&cfg.EnableGzip, "enable-gzip", false, "Compress JSON and HTML responses with gzip when clients accept it")
+	flag.BoolVar(&enablePprof, "enable-pprof", false, "Serve net/http/pprof profiles under /debug/pprof/ (unauthenticated)")
+	flag.StringVar(&pprofAddr, "pprof-addr", "", "Serve the -enable-pprof profiles on this address, such as localhost:6060, instead of the main port")
+	flag.StringVar(&logFormat, "log-format", "text", "Set the log format (text or json)")
+	flag.StringVar(&logLevel, "log-level", "info", "Set the minimum log level (debug, info, warn or error)")
+	flag.BoolVar(&quiet, "quiet", false, "Only log errors, leaving out the startup messages (same as -log-level=error)")
+	flag.BoolVar(&verbose, "verbose", false, "Also log debug output (same as -log-level=debug)")
+	flag.StringVar(&vpeak.VoicepeakPath, "engine-path", vpeak.VoicepeakPath, "Set the path of the VOICEPEAK executable")
+	flag.StringVar(&engineWorkdir, "engine-workdir", "", "Run VOICEPEAK in this directory; relative paths in other flags are then resolved against it")
+	flag.BoolVar(&cfg.TrimSilence, "trim-silence", false, "Strip leading and trailing silence from generated audio")
+	flag.BoolVar(&cfg.Normalize, "normalize", false, "Peak-normalize generated audio")
+	flag.StringVar(&cfg.KanaCommand, "kana-command", "", "Command that prints the kana reading of text on stdin, shown by /audio_query (e.g. \"mecab -Oyomi\")")
+	flag.StringVar(&cfg.FFmpegPath, "ffmpeg-path", "ffmpeg", "Set the ffmpeg binary used for mp3/ogg output")
+	flag.StringVar(&cfg.TmpDir, "tmp-dir", "", "Set the directory for temporary audio files (defaults to the working directory)")
+	flag.StringVar(&cfg.TmpDir, "output-dir", "", "Alias for -tmp-dir")
+	flag.BoolVar(&cfg.KeepAudio, "keep-audio", false, "Keep generated audio files instead of deleting them after they are served")
+	flag.StringVar(&cfg.ConfigPath, "config", server.DefaultConfigPath(), "Set the path of the settings file (empty disables persistence)")
+	flag.StringVar(&cfg.DictionaryFile, "dictionary", "", "Apply the replacements in this file to query texts before synthesis, one tab-separated source and replacement per line")
+	flag.StringVar(&speakersFile, "speakers-file", "", "Load the list of available speakers from a JSON file")
+	flag.StringVar(&presetsFile, "presets-file", "", "Load named voice presets, which queries can select with preset, from a JSON file")
+	flag.BoolVar(&showVersion, "version", false, "Show version and exit")
 	flag.Parse()
+	// Checked before anything else so it works without VOICEPEAK or a
+	// usable config
 	if showVersion {
-		fmt.Println(version)
+		fmt.Println(buildinfo.Version)
 		return
 	}
 
-	// Add root handler
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
-			return
-		}
+	levelSet := false
+	flag.Visit(func(f *flag.Flag) { levelSet = levelSet || f.Name == "log-level" })
+	logLevel, err := resolveLogLevel(logLevel, levelSet, quiet, verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-		indexHTML := `<!DOCTYPE html>
-<html lang="ja">
-<head>
-	<meta charset="UTF-8">
-	<title>vpeakserver</title>
-	<style>
-		body {
-			font-family: sans-serif;
-			margin: 20px;
-			line-height: 1.6;
-		}
-		h1 {
-			font-size: 1.5rem;
-			margin-bottom: 1rem;
-		}
-		.container {
-			max-width: 800px;
-			margin: 0 auto;
-		}
-		label {
-			display: block;
-			font-weight: bold;
-			margin: 1rem 0 0.5rem;
-		}
-		select, input[type="text"] {
-			width: 300px;
-			padding: 0.5rem;
-			font-size: 1rem;
-			margin-bottom: 0.5rem;
-		}
-		.lang-switch {
-			position: absolute;
-			top: 20px;
-			right: 20px;
-			display: flex;
-			gap: 10px;
-		}
-		.lang-switch label {
-			margin: initial;
-		}
-		[data-lang="en"] .ja,
-		[data-lang="ja"] .en {
-			display: none;
-		}
-		ul {
-			padding-left: 20px;
-		}
-		li {
-			margin: 10px 0;
-		}
-		a {
-			color: #0066cc;
-			text-decoration: none;
-		}
-		a:hover {
-			text-decoration: underline;
-		}
-	</style>
-</head>
-<body data-lang="{{.Lang}}">
-	<div class="lang-switch">
-		<label for="langSelect">Language</label>
-		<select id="langSelect" onchange="changeLang(this.value)">
-			<option value="ja" {{if eq .Lang "ja"}}selected{{end}}>日本語</option>
-			<option value="en" {{if eq .Lang "en"}}selected{{end}}>English</option>
-		</select>
-	</div>
-
-	<div class="container">
-		<h1>
-			<span class="ja">vpeakserver</span>
-			<span class="en">vpeakserver</span>
-		</h1>
-		<p>
-			<span class="ja">vpeakserverへようこそ！</span>
-			<span class="en">Welcome to vpeakserver!</span>
-		</p>
-		<ul>
-			<li>
-				<a href="/setting">
-					<span class="ja">設定</span>
-					<span class="en">Settings</span>
-				</a>
-			</li>
-		</ul>
-	</div>
-
-	<script>
-		function changeLang(lang) {
-			document.body.setAttribute('data-lang', lang);
-			localStorage.setItem('vpeakserver.selectedLang', lang);
-		}
+	logger, err := newLogger(os.Stderr, logFormat, logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
 
-		// initialize language setting
-		const savedLang = localStorage.getItem('vpeakserver.selectedLang');
-		if (savedLang) {
-			document.body.setAttribute('data-lang', savedLang);
-			document.getElementById('langSelect').value = savedLang;
+	// Changed first, so every relative path is resolved against the same
+	// directory
+	if engineWorkdir != "" {
+		if err := changeEngineWorkdir(engineWorkdir); err != nil {
+			fatal(err.Error(), "path", engineWorkdir)
 		}
-	</script>
-</body>
-</html>`
+	}
 
-		tmpl, err := template.New("index").Parse(indexHTML)
+	if cfg.ConfigPath != "" {
+		saved, err := server.LoadSettings(cfg.ConfigPath)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to parse template: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// Get language preference from localStorage or default to Japanese
-		lang := "ja"
-		if langCookie, err := r.Cookie("lang"); err == nil {
-			lang = langCookie.Value
-		}
-
-		data := SettingsData{
-			Lang: lang,
-		}
-
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		if err := tmpl.Execute(w, data); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to render template: %v", err), http.StatusInternalServerError)
-			return
+			fatal(err.Error())
+		}
+		if saved != nil {
+			// Command-line flags take precedence over the saved settings
+			setFlags := map[string]bool{}
+			flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+			if !setFlags["cors-policy-mode"] && saved.CorsPolicyMode != "" {
+				cfg.CorsPolicyMode = saved.CorsPolicyMode
+			}
+			if !setFlags["allowed-origin"] {
+				cfg.AllowedOrigin = saved.AllowOrigin
+			}
 		}
-	})
+	}
 
-	http.HandleFunc("/audio_query", enableCORS(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
-			return
-		}
+	cfg.SegmentGap = time.Duration(segmentGapMs) * time.Millisecond
 
-		text := r.URL.Query().Get("text")
-		speaker := r.URL.Query().Get("speaker")
-		emotion := r.URL.Query().Get("emotion")
+	if port < 1 || port > 65535 {
+		fatal("Invalid port: must be between 1 and 65535", "port", port)
+	}
 
-		if text == "" || speaker == "" {
-			http.Error(w, "Missing required parameters: text and speaker", http.StatusBadRequest)
-			return
-		}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 
-		speed, err := parseOptionalIntParam(r.URL.Query().Get("speed"), speedMin, speedMax)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid speed parameter: %v", err), http.StatusBadRequest)
-			return
-		}
+	tlsConfig, err := buildTLSConfig(tlsCert, tlsKey, tlsMinVersion)
+	if err != nil {
+		fatal(err.Error())
+	}
 
-		pitch, err := parseOptionalIntParam(r.URL.Query().Get("pitch"), pitchMin, pitchMax)
+	if apiKey != "" {
+		cfg.APIKeys = append(cfg.APIKeys, apiKey)
+	}
+	if apiKeysFile != "" {
+		keys, err := server.LoadAPIKeys(apiKeysFile)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid pitch parameter: %v", err), http.StatusBadRequest)
-			return
+			fatal(err.Error())
 		}
-
-		if !validEmotions[emotion] {
-			emotion = ""
-		}
-
-		audioQuery := AudioQuery{
-			Text:    text,
-			Speaker: speaker,
-			Emotion: emotion,
-			Speed:   speed,
-			Pitch:   pitch,
+		if len(keys) == 0 {
+			fatal("No API keys found", "path", apiKeysFile)
 		}
+		cfg.APIKeys = append(cfg.APIKeys, keys...)
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(audioQuery); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to encode audio query: %v", err), http.StatusInternalServerError)
-			return
+	if speakersFile != "" {
+		list, err := server.LoadSpeakers(speakersFile)
+		if err != nil {
+			fatal(err.Error())
 		}
-	}))
+		cfg.Speakers = list
+	}
 
-	http.HandleFunc("/synthesis", enableCORS(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
-			return
+	if presetsFile != "" {
+		presets, err := server.LoadPresets(presetsFile)
+		if err != nil {
+			fatal(err.Error())
 		}
+		cfg.Presets = presets
+	}
 
-		var query AudioQuery
-		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
-			return
-		}
+	cfg.EnablePprof = enablePprof && pprofAddr == ""
 
-		if !validEmotions[query.Emotion] {
-			query.Emotion = ""
-		}
+	s, err := server.New(cfg)
+	if err != nil {
+		fatal(err.Error())
+	}
 
-		if err := validateOptionalRange(query.Speed, speedMin, speedMax); err != nil {
-			http.Error(w, fmt.Sprintf("Invalid speed: %v", err), http.StatusBadRequest)
-			return
-		}
+	displayHost := host
+	if displayHost == "" {
+		displayHost = "localhost"
+	}
 
-		if err := validateOptionalRange(query.Pitch, pitchMin, pitchMax); err != nil {
-			http.Error(w, fmt.Sprintf("Invalid pitch: %v", err), http.StatusBadRequest)
-			return
-		}
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
 
-		outputFileName := fmt.Sprintf("audio-%s.wav", uuid.New().String())
+	slog.Info("Server started", "url", fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(displayHost, strconv.Itoa(port))))
+	logConfig(cfg, addr, scheme, timeouts)
 
-		opts := vpeak.Options{
-			Narrator: query.Speaker,
-			Emotion:  query.Emotion,
-			Output:   outputFileName,
-			Silent:   true,
-			Speed:    query.Speed,
-			Pitch:    query.Pitch,
-		}
+	srv := newHTTPServer(addr, s.Routes(), tlsConfig, timeouts)
 
-		if err := vpeak.GenerateSpeech(query.Text, opts); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to generate speech: %v", err), http.StatusInternalServerError)
+	serverErr := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			serverErr <- srv.ListenAndServeTLS(tlsCert, tlsKey)
 			return
 		}
+		serverErr <- srv.ListenAndServe()
+	}()
 
-		defer os.Remove(outputFileName)
-
-		w.Header().Set("Content-Type", "audio/wav")
-		http.ServeFile(w, r, outputFileName)
-	}))
-
-	// Add the settings page handler
-	http.HandleFunc("/setting", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			settingsHTML := `<!DOCTYPE html>
-<html lang="ja">
-<head>
-  <meta charset="UTF-8">
-  <title>vpeakserver Settings</title>
-  <style>
-    body {
-      font-family: sans-serif;
-      margin: 20px;
-    }
-    h1 {
-      font-size: 1.5rem;
-      margin-bottom: 1rem;
-    }
-    .alert {
-      background-color: #fff7d5;
-      padding: 1rem;
-      margin-bottom: 1.5rem;
-      border: 1px solid #f0e9c6;
-    }
-    label {
-      display: block;
-      font-weight: bold;
-      margin: 1rem 0 0.5rem;
-    }
-    select, input[type="text"] {
-      width: 300px;
-      padding: 0.5rem;
-      font-size: 1rem;
-      margin-bottom: 0.5rem;
-    }
-    .description {
-      font-size: 0.9rem;
-      color: #555;
-      margin-bottom: 1rem;
-    }
-    .success-message {
-      background-color: #d4edda;
-      color: #155724;
-      padding: 1rem;
-      margin-bottom: 1.5rem;
-      border: 1px solid #c3e6cb;
-      display: none;
-    }
-    .lang-switch {
-      position: absolute;
-      top: 20px;
-      right: 20px;
-    }
-    [data-lang="en"] .ja,
-    [data-lang="ja"] .en {
-      display: none;
-    }
-  </style>
-</head>
-<body data-lang="{{.Lang}}">
-  <div class="lang-switch" style="display: flex; gap: 10px;">
-    <label for="langSelect" style="margin: initial;">Language</label>
-    <select id="langSelect" onchange="changeLang(this.value)">
-      <option value="ja" {{if eq .Lang "ja"}}selected{{end}}>日本語</option>
-      <option value="en" {{if eq .Lang "en"}}selected{{end}}>English</option>
-    </select>
-  </div>
-
-  <h1>
-    <span class="ja">vpeakserver 設定</span>
-    <span class="en">vpeakserver Settings</span>
-  </h1>
-
-  <div class="alert">
-    <span class="ja">変更は即座に反映されます。</span>
-    <span class="en">Changes are applied immediately.</span>
-  </div>
-
-  <div id="successMessage" class="success-message">
-    <span class="ja">設定が保存されました。</span>
-    <span class="en">Settings saved.</span>
-  </div>
-
-  <form id="settingsForm">
-    <label for="corsPolicyMode">CORS Policy Mode</label>
-    <select id="corsPolicyMode" name="corsPolicyMode">
-      <option value="localapps" {{if eq .CorsPolicyMode "localapps"}}selected{{end}}>localapps</option>
-      <option value="all" {{if eq .CorsPolicyMode "all"}}selected{{end}}>all</option>
-    </select>
-    <div class="description">
-      <span class="ja">
-        <strong>localapps</strong> はオリジン間リソース共有ポリシーを、
-        <code>app://</code> と <code>localhost</code> 関連に限定します。<br>
-        その他のオリジンは <strong>Allow Origin</strong> オプションで追加できます。<br>
-        <strong>all</strong> はすべてを許可します。危険性を理解した上でご利用ください。
-      </span>
-      <span class="en">
-        <strong>localapps</strong> restricts CORS policy to <code>app://</code> and <code>localhost</code> related origins.<br>
-        Additional origins can be added using the <strong>Allow Origin</strong> option.<br>
-        <strong>all</strong> allows all origins. Please use with caution.
-      </span>
-    </div>
-
-    <label for="allowOrigin">Allow Origin</label>
-    <input id="allowOrigin" name="allowOrigin" type="text" 
-           value="{{.AllowOrigin}}">
-    <div class="description">
-      <span class="ja">許可するオリジンを指定します。スペースで区切ることで複数指定できます。</span>
-      <span class="en">Specify allowed origins. Multiple origins can be specified by separating with spaces.</span>
-    </div>
-  </form>
-
-  <script>
-    document.getElementById('corsPolicyMode').addEventListener('change', saveSettings);
-    document.getElementById('allowOrigin').addEventListener('blur', saveSettings);
-
-    function changeLang(lang) {
-      document.body.setAttribute('data-lang', lang);
-      localStorage.setItem('vpeakserver.selectedLang', lang);
-    }
-
-    // initialize language setting
-    const savedLang = localStorage.getItem('vpeakserver.selectedLang');
-    if (savedLang) {
-      document.body.setAttribute('data-lang', savedLang);
-      document.getElementById('langSelect').value = savedLang;
-    }
-
-    function saveSettings() {
-      const corsPolicyMode = document.getElementById('corsPolicyMode').value;
-      const allowOrigin = document.getElementById('allowOrigin').value;
-      
-      fetch('/update-settings', {
-        method: 'POST',
-        headers: {
-          'Content-Type': 'application/json',
-        },
-        body: JSON.stringify({
-          corsPolicyMode: corsPolicyMode,
-          allowOrigin: allowOrigin
-        })
-      })
-      .then(response => {
-        if (response.ok) {
-          const successMessage = document.getElementById('successMessage');
-          successMessage.style.display = 'block';
-          setTimeout(() => {
-            successMessage.style.display = 'none';
-          }, 3000);
-        }
-      })
-      .catch(error => {
-        const lang = document.body.getAttribute('data-lang');
-        console.error(lang === 'ja' ? '設定の保存中にエラーが発生しました:' : 'Error saving settings:', error);
-      });
-    }
-  </script>
-</body>
-</html>`
-
-			tmpl, err := template.New("settings").Parse(settingsHTML)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Failed to parse template: %v", err), http.StatusInternalServerError)
-				return
-			}
-
-			// Get language preference from cookie or default to Japanese
-			lang := "ja"
-			if langCookie, err := r.Cookie("lang"); err == nil {
-				lang = langCookie.Value
-			}
-
-			data := SettingsData{
-				CorsPolicyMode: corsPolicyMode,
-				AllowOrigin:    allowedOrigin,
-				Lang:           lang,
+	var pprofSrv *http.Server
+	if enablePprof && pprofAddr != "" {
+		pprofSrv = newHTTPServer(pprofAddr, server.PprofHandler(), nil, timeouts)
+		slog.Info("Serving pprof", "addr", pprofAddr)
+		go func() {
+			if err := pprofSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- err
 			}
+		}()
+	}
 
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			if err := tmpl.Execute(w, data); err != nil {
-				http.Error(w, fmt.Sprintf("Failed to render template: %v", err), http.StatusInternalServerError)
-				return
-			}
-		}
-	})
-
-	// Update settings endpoint
-	http.HandleFunc("/update-settings", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
-			return
-		}
-
-		var settings SettingsData
-		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
-			return
-		}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
-		corsPolicyMode = settings.CorsPolicyMode
-		allowedOrigin = settings.AllowOrigin
+	select {
+	case err := <-serverErr:
+		fatal(err.Error())
+	case sig := <-stop:
+		slog.Info("Shutting down", "signal", sig.String(), "timeout", shutdownTimeout)
+	case <-s.ShutdownRequested():
+		slog.Info("Shutting down", "reason", "admin request", "timeout", shutdownTimeout)
+	}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status": "success"}`))
-	})
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("Graceful shutdown did not complete", "error", err)
+	}
+	if pprofSrv != nil {
+		pprofSrv.Close()
+	}
 
-	fmt.Println("Server started at http://localhost:20202")
-	fmt.Printf("Starting server with allowed origin: %s\n", allowedOrigin)
-	fmt.Printf("CORS policy mode: %s\n", corsPolicyMode)
-	log.Fatal(http.ListenAndServe(":20202", nil))
+	s.Close()
+	slog.Info("Server stopped")
 }
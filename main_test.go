@@ -0,0 +1,146 @@
+//go:build darwin || windows
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shinshin86/vpeakserver/buildinfo"
+)
+
+// runMainEnv makes the test binary run main instead of the tests, see
+// runMain.
+const runMainEnv = "VPEAKSERVER_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the server binary with args and returns its combined output.
+func runMain(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestVersionFlag(t *testing.T) {
+	// The invalid log level would stop the server if -version did not exit
+	// first
+	out, err := runMain(t, "-version", "-log-level=loud", "-config", "")
+	if err != nil {
+		t.Fatalf("-version failed: %v; output: %s", err, out)
+	}
+	if got := strings.TrimSpace(out); got != buildinfo.Version {
+		t.Errorf("-version printed %q, want %q", got, buildinfo.Version)
+	}
+}
+
+func TestInvalidLogLevelExits(t *testing.T) {
+	out, err := runMain(t, "-log-level=loud", "-config", "")
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 2 {
+		t.Fatalf("error = %v, want exit status 2; output: %s", err, out)
+	}
+	if !strings.Contains(out, "invalid log level") {
+		t.Errorf("output = %q, want the log level error", out)
+	}
+}
+
+func TestNewHTTPServerTimeouts(t *testing.T) {
+	timeouts := httpTimeouts{Read: 5 * time.Second, Write: time.Minute, Idle: 2 * time.Minute}
+	srv := newHTTPServer(":0", http.NotFoundHandler(), nil, timeouts)
+
+	if srv.Addr != ":0" || srv.Handler == nil {
+		t.Errorf("server = %+v, want the address and handler", srv)
+	}
+	if srv.ReadHeaderTimeout != timeouts.Read || srv.ReadTimeout != timeouts.Read {
+		t.Errorf("read timeouts = %v, %v, want %v", srv.ReadHeaderTimeout, srv.ReadTimeout, timeouts.Read)
+	}
+	if srv.WriteTimeout != timeouts.Write {
+		t.Errorf("WriteTimeout = %v, want %v", srv.WriteTimeout, timeouts.Write)
+	}
+	if srv.IdleTimeout != timeouts.Idle {
+		t.Errorf("IdleTimeout = %v, want %v", srv.IdleTimeout, timeouts.Idle)
+	}
+}
+
+func TestChangeEngineWorkdir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(dir, "missing"), file} {
+		if err := changeEngineWorkdir(path); err == nil {
+			t.Errorf("changeEngineWorkdir(%s) succeeded, want an error", path)
+		}
+	}
+
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+	if err := changeEngineWorkdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := filepath.EvalSymlinks(dir); got != want && got != dir {
+		t.Errorf("working directory = %s, want %s", got, dir)
+	}
+}
+
+func TestMissingEngineWorkdirExits(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	out, err := runMain(t, "-engine-workdir", missing, "-config", "")
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Fatalf("error = %v, want exit status 1; output: %s", err, out)
+	}
+	if !strings.Contains(out, "cannot use engine working directory") {
+		t.Errorf("output = %q, want the working directory error", out)
+	}
+}
+
+func TestQuietAndVerboseExit(t *testing.T) {
+	out, err := runMain(t, "-quiet", "-verbose", "-config", "")
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 2 {
+		t.Fatalf("error = %v, want exit status 2; output: %s", err, out)
+	}
+	if !strings.Contains(out, "-quiet and -verbose cannot be used together") {
+		t.Errorf("output = %q, want the flag conflict error", out)
+	}
+}
+
+func TestParseAutoNarrators(t *testing.T) {
+	var narrators map[string]string
+	if err := parseAutoNarrators(&narrators)("ja=f1, en=m2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(narrators) != 2 || narrators["ja"] != "f1" || narrators["en"] != "m2" {
+		t.Errorf("narrators = %v, want ja=f1 and en=m2", narrators)
+	}
+
+	for _, v := range []string{"ja", "ja=", "=f1", "ja=f1,"} {
+		if err := parseAutoNarrators(&narrators)(v); err == nil {
+			t.Errorf("parseAutoNarrators accepted %q", v)
+		}
+	}
+}
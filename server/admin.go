@@ -0,0 +1,28 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// ShutdownRequested is closed when a client asks the server to stop through
+// POST /admin/shutdown. The program running the HTTP server should then shut
+// it down gracefully.
+func (s *Server) ShutdownRequested() <-chan struct{} {
+	return s.shutdown
+}
+
+// handleAdminShutdown answers POST /admin/shutdown and then signals
+// ShutdownRequested. The response is sent first; a graceful shutdown waits
+// for it to finish.
+func (s *Server) handleAdminShutdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Shutdown requested", "remote_addr", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status": "shutting down"}`))
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+}
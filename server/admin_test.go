@@ -0,0 +1,69 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// shutdownRequested reports whether s has been asked to shut down.
+func shutdownRequested(s *Server) bool {
+	select {
+	case <-s.ShutdownRequested():
+		return true
+	default:
+		return false
+	}
+}
+
+// adminRequest returns a POST to /admin/shutdown with the API key.
+func adminRequest(key string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/admin/shutdown", nil)
+	if key != "" {
+		r.Header.Set("X-API-Key", key)
+	}
+	return r
+}
+
+func TestAdminShutdownDisabled(t *testing.T) {
+	s, _ := newTestServer(t, Config{APIKeys: []string{"secret"}})
+
+	if rec := serve(s, adminRequest("secret")); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if shutdownRequested(s) {
+		t.Error("shutdown requested while the admin endpoints are disabled")
+	}
+}
+
+func TestAdminShutdown(t *testing.T) {
+	s, _ := newTestServer(t, Config{EnableAdmin: true, APIKeys: []string{"secret"}})
+
+	for _, key := range []string{"", "wrong"} {
+		wantError(t, serve(s, adminRequest(key)), http.StatusUnauthorized, errCodeUnauthorized)
+	}
+	if shutdownRequested(s) {
+		t.Fatal("shutdown requested without the API key")
+	}
+
+	rec := serve(s, adminRequest("secret"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if !shutdownRequested(s) {
+		t.Error("shutdown not requested")
+	}
+	// Asking again does not close the channel twice
+	if rec := serve(s, adminRequest("secret")); rec.Code != http.StatusOK {
+		t.Errorf("second request: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestNewRejectsAdminWithoutAPIKeys(t *testing.T) {
+	if s, err := New(Config{Engine: &fakeEngine{}, TmpDir: t.TempDir(), EnableAdmin: true}); err == nil {
+		s.Close()
+		t.Error("New accepted EnableAdmin without API keys")
+	}
+}
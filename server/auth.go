@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// LoadAPIKeys reads one key per line from path, skipping blank lines and
+// lines starting with #.
+func LoadAPIKeys(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open API keys file: %w", err)
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read API keys file: %w", err)
+	}
+	return keys, nil
+}
+
+// requestAPIKey extracts the key from the Authorization or X-API-Key header.
+func requestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// validAPIKey compares key against every configured key in constant time.
+func (s *Server) validAPIKey(key string) bool {
+	valid := false
+	for _, k := range s.cfg.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			valid = true
+		}
+	}
+	return valid && key != ""
+}
+
+// Middleware to require an API key when one is configured
+func (s *Server) requireAPIKey(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.cfg.APIKeys) > 0 && !s.validAPIKey(requestAPIKey(r)) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeLocalizedError(w, http.StatusUnauthorized, errCodeUnauthorized, msgUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
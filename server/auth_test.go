@@ -0,0 +1,79 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	s, _ := newTestServer(t, Config{APIKeys: []string{"first-key", "second-key"}})
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   int
+	}{
+		{"bearer", "Authorization", "Bearer first-key", http.StatusOK},
+		{"x-api-key", "X-API-Key", "second-key", http.StatusOK},
+		{"missing", "", "", http.StatusUnauthorized},
+		{"wrong key", "Authorization", "Bearer third-key", http.StatusUnauthorized},
+		{"prefix of a key", "X-API-Key", "first", http.StatusUnauthorized},
+		{"not bearer", "Authorization", "Basic first-key", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, r := range []*http.Request{
+				jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"}),
+				httptest.NewRequest(http.MethodGet, "/audio_query?text=a&speaker=f1", nil),
+			} {
+				if tt.header != "" {
+					r.Header.Set(tt.header, tt.value)
+				}
+				rec := serve(s, r)
+				if rec.Code != tt.want {
+					t.Fatalf("%s: status = %d, want %d", r.URL.Path, rec.Code, tt.want)
+				}
+				if tt.want == http.StatusUnauthorized {
+					if got := rec.Header().Get("WWW-Authenticate"); got != "Bearer" {
+						t.Errorf("WWW-Authenticate = %q, want Bearer", got)
+					}
+					if code := errorCode(t, rec.Body); code != errCodeUnauthorized {
+						t.Errorf("error code = %q, want %q", code, errCodeUnauthorized)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPublicRoutesNeedNoAPIKey(t *testing.T) {
+	s, _ := newTestServer(t, Config{APIKeys: []string{"key"}})
+
+	for _, path := range []string{"/", "/setting", "/health"} {
+		if rec := serve(s, httptest.NewRequest(http.MethodGet, path, nil)); rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("# comment\nfirst\n\n  second  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"first", "second"}; !slices.Equal(keys, want) {
+		t.Errorf("LoadAPIKeys = %q, want %q", keys, want)
+	}
+}
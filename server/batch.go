@@ -0,0 +1,128 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// batchEntryName returns the file name used for a batch entry inside the ZIP.
+func batchEntryName(index int, query AudioQuery) (string, error) {
+	if query.ID == "" {
+		return strconv.Itoa(index) + ".wav", nil
+	}
+	if strings.ContainsAny(query.ID, `/\`) || query.ID == "." || query.ID == ".." {
+		return "", fmt.Errorf("invalid id: %s", query.ID)
+	}
+	return query.ID + ".wav", nil
+}
+
+// batchItemError prefixes err with the index of the failing batch entry,
+// keeping its status and code.
+func batchItemError(index int, err error) error {
+	return indexedError("Query", index, err)
+}
+
+// indexedError prefixes err with a label such as "Query 2", keeping its
+// status and code.
+func indexedError(label string, index int, err error) error {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return newAPIError(apiErr.Status, apiErr.Code, fmt.Sprintf("%s %d: %s", label, index, apiErr.Message))
+	}
+	return fmt.Errorf("%s %d: %w", label, index, err)
+}
+
+func (s *Server) handleSynthesisBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var queries []AudioQuery
+	if err := s.decodeJSONBody(w, r, &queries); err != nil {
+		writeAPIError(w, bodyError(err))
+		return
+	}
+
+	if len(queries) == 0 {
+		writeJSONError(w, http.StatusBadRequest, errCodeInvalidBatch, "Batch must contain at least one query")
+		return
+	}
+	if len(queries) > s.cfg.MaxBatch {
+		writeJSONError(w, http.StatusBadRequest, errCodeInvalidBatch, fmt.Sprintf("Batch contains %d queries, the maximum is %d", len(queries), s.cfg.MaxBatch))
+		return
+	}
+
+	// Validate every entry first so an invalid item fails the whole batch
+	// before any audio is generated
+	names := make([]string, len(queries))
+	seen := map[string]bool{}
+	for i := range queries {
+		noteQuery(r.Context(), queries[i])
+		if err := s.validateSynthesisQuery(&queries[i]); err != nil {
+			writeAPIError(w, batchItemError(i, err))
+			return
+		}
+		name, err := batchEntryName(i, queries[i])
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, errCodeInvalidBatch, fmt.Sprintf("Query %d: %v", i, err))
+			return
+		}
+		if seen[name] {
+			writeJSONError(w, http.StatusBadRequest, errCodeInvalidBatch, fmt.Sprintf("Query %d: duplicate id: %s", i, queries[i].ID))
+			return
+		}
+		seen[name] = true
+		names[i] = name
+	}
+
+	// Everything is synthesized before the response starts so a failure
+	// can still be reported with a proper status
+	audio := make([][]byte, len(queries))
+	for i, query := range queries {
+		data, _, err := s.synthesizeQuery(r.Context(), query)
+		if err != nil {
+			writeAPIError(w, batchItemError(i, err))
+			return
+		}
+		audio[i] = data
+	}
+
+	// The audio is already in memory, so the archive is built there too to
+	// send an accurate Content-Length
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i, data := range audio {
+		if err := addToZip(zw, names[i], data); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to write batch entry %s: %v", names[i], err))
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to finish batch archive: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="synthesis.zip"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if _, err := buf.WriteTo(w); err != nil {
+		slog.WarnContext(r.Context(), "Failed to write batch archive", "error", err)
+	}
+}
+
+func addToZip(zw *zip.Writer, name string, data []byte) error {
+	// WAV data barely compresses, so entries are stored as-is
+	entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = entry.Write(data)
+	return err
+}
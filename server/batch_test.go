@@ -0,0 +1,97 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestSynthesisBatch(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	queries := []AudioQuery{
+		{Text: "いち", Speaker: "f1"},
+		{ID: "second", Text: "にいさん", Speaker: "m1"},
+		{Text: "さん", Speaker: "c"},
+	}
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis_batch", queries))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/zip" {
+		t.Errorf("Content-Type = %q, want application/zip", got)
+	}
+	if got, want := rec.Header().Get("Content-Length"), strconv.Itoa(rec.Body.Len()); got != want {
+		t.Errorf("Content-Length = %s, want %s", got, want)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"0.wav", "second.wav", "2.wav"}
+	if len(zr.File) != len(names) {
+		t.Fatalf("archive has %d entries, want %d", len(zr.File), len(names))
+	}
+	for i, f := range zr.File {
+		if f.Name != names[i] {
+			t.Errorf("entry %d is named %q, want %q", i, f.Name, names[i])
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, fakeWAV(queries[i].Text)) {
+			t.Errorf("entry %s is not the audio of %q", f.Name, queries[i].Text)
+		}
+	}
+	if calls := engine.Calls(); len(calls) != 3 {
+		t.Errorf("engine called %d times, want 3", len(calls))
+	}
+}
+
+func TestSynthesisBatchInvalidItem(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	queries := []AudioQuery{
+		{Text: "いち", Speaker: "f1"},
+		{Text: "に", Speaker: "f1", Speed: intPtr(1000)},
+		{Text: "さん", Speaker: "f1"},
+	}
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis_batch", queries))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidSpeed)
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times, want 0 for an invalid batch", len(calls))
+	}
+}
+
+func TestSynthesisBatchLimits(t *testing.T) {
+	s, _ := newTestServer(t, Config{MaxBatch: 2})
+
+	tests := map[string][]AudioQuery{
+		"empty":        {},
+		"too many":     {{Text: "あ"}, {Text: "い"}, {Text: "う"}},
+		"duplicate id": {{ID: "a", Text: "あ"}, {ID: "a", Text: "い"}},
+		"path in id":   {{ID: "../a", Text: "あ"}},
+	}
+	for name, queries := range tests {
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis_batch", queries))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", name, rec.Code, http.StatusBadRequest)
+			continue
+		}
+		if code := errorCode(t, rec.Body); code != errCodeInvalidBatch {
+			t.Errorf("%s: error code = %q, want %q", name, code, errCodeInvalidBatch)
+		}
+	}
+}
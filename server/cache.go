@@ -0,0 +1,178 @@
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheKey hashes the fields of a query that affect the generated audio,
+// together with the post-processing settings so that entries in the disk
+// cache are not reused after they change.
+func (s *Server) cacheKey(query AudioQuery) string {
+	key, _ := json.Marshal(struct {
+		Text        string `json:"text"`
+		Speaker     string `json:"speaker"`
+		Emotion     string `json:"emotion"`
+		Speed       *int   `json:"speed"`
+		Pitch       *int   `json:"pitch"`
+		TrimSilence bool   `json:"trim_silence,omitempty"`
+		Normalize   bool   `json:"normalize,omitempty"`
+	}{query.Text, query.Speaker, query.Emotion, query.Speed, query.Pitch, s.cfg.TrimSilence, s.cfg.Normalize})
+
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// audioCache is an LRU cache of generated audio. It holds at most capacity
+// entries and, when maxBytes is positive, at most maxBytes of audio in total,
+// so a few large WAV files and many small MP3 files are weighed alike.
+type audioCache struct {
+	mu       sync.Mutex
+	capacity int
+	maxBytes int64
+	size     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type audioCacheEntry struct {
+	key  string
+	data []byte
+}
+
+func newAudioCache(capacity int, maxBytes int64) *audioCache {
+	return &audioCache{
+		capacity: capacity,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (c *audioCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*audioCacheEntry).data, true
+}
+
+// Add stores data under key and evicts the least recently used entries
+// until both limits are met again. Data larger than maxBytes on its own is
+// not cached, as it would only push out everything else.
+func (c *audioCache) Add(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	if c.maxBytes > 0 && int64(len(data)) > c.maxBytes {
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&audioCacheEntry{key: key, data: data})
+	c.size += int64(len(data))
+	for c.order.Len() > c.capacity || (c.maxBytes > 0 && c.size > c.maxBytes) {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove drops elem from the cache. c.mu must be held.
+func (c *audioCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*audioCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.data))
+}
+
+// diskAudioCache stores generated WAV files in a Storage, by default files
+// in CacheDir. When the storage is a TimedStorage, entries older than ttl are
+// treated as missing and removed by the sweeper.
+type diskAudioCache struct {
+	storage Storage
+	ttl     time.Duration
+}
+
+func newDiskAudioCache(storage Storage, ttl time.Duration) *diskAudioCache {
+	return &diskAudioCache{storage: storage, ttl: ttl}
+}
+
+// storageKey keeps the .wav file names used before the cache was moved onto
+// Storage, so existing cache directories stay valid.
+func (c *diskAudioCache) storageKey(key string) string {
+	return key + ".wav"
+}
+
+func (c *diskAudioCache) expired(modTime time.Time) bool {
+	return c.ttl > 0 && time.Since(modTime) > c.ttl
+}
+
+func (c *diskAudioCache) Get(key string) ([]byte, bool) {
+	if timed, ok := c.storage.(TimedStorage); ok && c.ttl > 0 {
+		modTime, err := timed.ModTime(c.storageKey(key))
+		if err != nil || c.expired(modTime) {
+			return nil, false
+		}
+	}
+
+	data, err := readStorage(c.storage, c.storageKey(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *diskAudioCache) Add(key string, data []byte) error {
+	return c.storage.Put(c.storageKey(key), data)
+}
+
+// sweep periodically removes expired entries from a TimedStorage until done
+// is closed.
+func (c *diskAudioCache) sweep(storage TimedStorage, done <-chan struct{}) {
+	interval := c.ttl / 2
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		keys, err := storage.Keys()
+		if err != nil {
+			slog.Warn("Failed to list cache entries", "error", err)
+			continue
+		}
+		for _, key := range keys {
+			if !strings.HasSuffix(key, ".wav") {
+				continue
+			}
+			modTime, err := storage.ModTime(key)
+			if err != nil || !c.expired(modTime) {
+				continue
+			}
+			if err := storage.Delete(key); err != nil {
+				slog.Warn("Failed to remove expired cache entry", "key", key, "error", err)
+			}
+		}
+	}
+}
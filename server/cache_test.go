@@ -0,0 +1,171 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// synthesizeCached posts a query for text and returns its X-Cache header.
+func synthesizeCached(t *testing.T, s *Server, text string) string {
+	t.Helper()
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: text, Speaker: "f1"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), fakeWAV(text)) {
+		t.Fatalf("body is not the audio of %q", text)
+	}
+	return rec.Header().Get("X-Cache")
+}
+
+func TestMemoryCacheHit(t *testing.T) {
+	s, engine := newTestServer(t, Config{CacheSize: 10})
+
+	if got := synthesizeCached(t, s, "こんにちは"); got != "MISS" {
+		t.Errorf("first request: X-Cache = %q, want MISS", got)
+	}
+	if got := synthesizeCached(t, s, "こんにちは"); got != "HIT" {
+		t.Errorf("second request: X-Cache = %q, want HIT", got)
+	}
+	if calls := engine.Calls(); len(calls) != 1 {
+		t.Errorf("engine called %d times, want 1", len(calls))
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	s, engine := newTestServer(t, Config{CacheSize: 2})
+
+	for _, text := range []string{"いち", "に", "さん"} {
+		synthesizeCached(t, s, text)
+	}
+	if got := synthesizeCached(t, s, "さん"); got != "HIT" {
+		t.Errorf("newest entry: X-Cache = %q, want HIT", got)
+	}
+	if got := synthesizeCached(t, s, "いち"); got != "MISS" {
+		t.Errorf("oldest entry: X-Cache = %q, want MISS after eviction", got)
+	}
+	if calls := engine.Calls(); len(calls) != 4 {
+		t.Errorf("engine called %d times, want 4", len(calls))
+	}
+}
+
+func TestAudioCacheLRU(t *testing.T) {
+	c := newAudioCache(2, 0)
+	c.Add("a", []byte("a"))
+	c.Add("b", []byte("b"))
+	// Reading a makes b the least recently used entry
+	c.Get("a")
+	c.Add("c", []byte("c"))
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b is still cached, want it evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if data, ok := c.Get(key); !ok || string(data) != key {
+			t.Errorf("Get(%q) = %q, %v, want it cached", key, data, ok)
+		}
+	}
+}
+
+func TestAudioCacheMaxBytes(t *testing.T) {
+	c := newAudioCache(10, 10)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		c.Add(key, []byte("12"))
+	}
+	// Eight bytes are cached, so eight more push out the three oldest entries
+	c.Add("large", []byte("12345678"))
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, ok := c.Get(key); ok {
+			t.Errorf("%s is still cached, want it evicted", key)
+		}
+	}
+	for _, key := range []string{"d", "large"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s was evicted, want it cached", key)
+		}
+	}
+
+	c.Add("huge", []byte("12345678901"))
+	if _, ok := c.Get("huge"); ok {
+		t.Error("entry larger than the limit is cached")
+	}
+	if _, ok := c.Get("large"); !ok {
+		t.Error("entry larger than the limit evicted the others")
+	}
+}
+
+func TestCacheKeyDependsOnVoice(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	base := AudioQuery{Text: "あ", Speaker: "f1", Speed: intPtr(100)}
+	for name, query := range map[string]AudioQuery{
+		"text":    {Text: "い", Speaker: "f1", Speed: intPtr(100)},
+		"speaker": {Text: "あ", Speaker: "f2", Speed: intPtr(100)},
+		"emotion": {Text: "あ", Speaker: "f1", Speed: intPtr(100), Emotion: "happy"},
+		"speed":   {Text: "あ", Speaker: "f1", Speed: intPtr(101)},
+		"pitch":   {Text: "あ", Speaker: "f1", Speed: intPtr(100), Pitch: intPtr(0)},
+	} {
+		if s.cacheKey(query) == s.cacheKey(base) {
+			t.Errorf("changing the %s does not change the cache key", name)
+		}
+	}
+	if s.cacheKey(AudioQuery{Text: "あ", Speaker: "f1", Speed: intPtr(100)}) != s.cacheKey(base) {
+		t.Error("equal queries have different cache keys")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	s, engine := newTestServer(t, Config{CacheDir: dir, CacheTTL: time.Hour})
+
+	if got := synthesizeCached(t, s, "こんにちは"); got != "MISS" {
+		t.Errorf("first request: X-Cache = %q, want MISS", got)
+	}
+	if got := synthesizeCached(t, s, "こんにちは"); got != "HIT" {
+		t.Errorf("second request: X-Cache = %q, want HIT", got)
+	}
+	if calls := engine.Calls(); len(calls) != 1 {
+		t.Errorf("engine called %d times, want 1", len(calls))
+	}
+
+	// A new server finds the entries of the previous one
+	restarted, engine := newTestServer(t, Config{CacheDir: dir, CacheTTL: time.Hour})
+	if got := synthesizeCached(t, restarted, "こんにちは"); got != "HIT" {
+		t.Errorf("after a restart: X-Cache = %q, want HIT", got)
+	}
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times after a restart, want 0", len(calls))
+	}
+}
+
+func TestDiskCacheExpiry(t *testing.T) {
+	dir := t.TempDir()
+	s, engine := newTestServer(t, Config{CacheDir: dir, CacheTTL: time.Hour})
+
+	synthesizeCached(t, s, "こんにちは")
+	files, err := filepath.Glob(filepath.Join(dir, "*.wav"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("cache directory holds %v (%v), want one entry", files, err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(files[0], old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := synthesizeCached(t, s, "こんにちは"); got != "MISS" {
+		t.Errorf("expired entry: X-Cache = %q, want MISS", got)
+	}
+	if calls := engine.Calls(); len(calls) != 2 {
+		t.Errorf("engine called %d times, want 2", len(calls))
+	}
+	if got := synthesizeCached(t, s, "こんにちは"); got != "HIT" {
+		t.Errorf("regenerated entry: X-Cache = %q, want HIT", got)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PersistedSettings is the on-disk representation of the settings that can be
+// changed from the settings page.
+type PersistedSettings struct {
+	CorsPolicyMode string `json:"corsPolicyMode"`
+	AllowOrigin    string `json:"allowOrigin"`
+}
+
+// DefaultConfigPath returns ~/.vpeakserver/config.json, or an empty string
+// when the home directory cannot be determined.
+func DefaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".vpeakserver", "config.json")
+}
+
+// LoadSettings reads the settings file. A missing file is not an error and
+// returns nil settings.
+func LoadSettings(path string) (*PersistedSettings, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var settings PersistedSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return &settings, nil
+}
+
+// saveSettings writes the settings to path atomically by writing a temporary
+// file in the same directory and renaming it over the destination.
+func saveSettings(path string, settings PersistedSettings) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode settings: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".config-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temp config file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp config file: %w", err)
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to replace config file: %w", err)
+	}
+	return nil
+}
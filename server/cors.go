@@ -0,0 +1,149 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Middleware to handle CORS. methods are the methods handler accepts, which
+// preflight responses advertise along with OPTIONS.
+func (s *Server) enableCORS(handler http.HandlerFunc, methods ...string) http.HandlerFunc {
+	allowMethods := allowedMethods(methods)
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		settings := s.settings.Get()
+
+		if settings.CorsPolicyMode == "all" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if settings.CorsPolicyMode == "localapps" {
+			// The allow-origin header depends on the request origin, so shared
+			// caches must not reuse this response for other origins
+			w.Header().Add("Vary", "Origin")
+			if s.localAppOrigin(settings, origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				// Browsers reject credentials with a wildcard origin, so they
+				// are only allowed when a specific origin is reflected
+				if s.cfg.CorsAllowCredentials && origin != "" {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			} else if s.cfg.CorsStrict && origin != "" {
+				// Without this only the browser would block the response,
+				// after the request has already been served
+				writeJSONError(w, http.StatusForbidden, errCodeOriginNotAllowed, "Origin is not allowed")
+				return
+			}
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+		w.Header().Set("Access-Control-Allow-Headers", s.corsAllowHeaders)
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+
+		if r.Method == http.MethodOptions {
+			if s.cfg.CorsMaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(s.cfg.CorsMaxAge))
+			}
+			writeOptions(w, allowMethods)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// Middleware to answer OPTIONS requests to routes that are not CORS-wrapped
+// with the methods they accept, without running handler. No CORS headers are
+// sent, so browsers still refuse cross-origin requests to these routes.
+func answerOptions(handler http.HandlerFunc, methods ...string) http.HandlerFunc {
+	allow := allowedMethods(methods)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			writeOptions(w, allow)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// allowedMethods lists methods and OPTIONS for the Allow headers.
+func allowedMethods(methods []string) string {
+	return strings.Join(append(methods[:len(methods):len(methods)], http.MethodOptions), ", ")
+}
+
+// writeOptions answers an OPTIONS request with the methods in allow.
+func writeOptions(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	w.WriteHeader(http.StatusOK)
+}
+
+// corsAllowHeaders lists the request headers the server reads, adding the
+// API key headers when keys are required, followed by CorsAllowHeaders.
+// Duplicates are left out, ignoring case.
+func corsAllowHeaders(cfg Config) string {
+	headers := []string{"Content-Type", "X-Dry-Run", "X-Request-ID"}
+	if len(cfg.APIKeys) > 0 {
+		headers = append(headers, "Authorization", "X-API-Key")
+	}
+
+	seen := map[string]bool{}
+	var list []string
+	for _, h := range append(headers, cfg.CorsAllowHeaders...) {
+		h = strings.TrimSpace(h)
+		key := http.CanonicalHeaderKey(h)
+		if h == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		list = append(list, h)
+	}
+	return strings.Join(list, ", ")
+}
+
+// localAppOrigin reports whether origin is allowed in localapps mode.
+func (s *Server) localAppOrigin(settings PersistedSettings, origin string) bool {
+	return strings.HasPrefix(origin, "app://") || strings.HasPrefix(origin, "http://localhost") || origin == settings.AllowOrigin || containsOrigin(settings.AllowOrigin, origin) || s.origins.Contains(origin)
+}
+
+func containsOrigin(allowedOrigins string, origin string) bool {
+	origins := strings.Split(allowedOrigins, " ")
+	for _, o := range origins {
+		if matchOrigin(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin reports whether origin matches pattern. Besides exact matches,
+// a pattern such as https://*.example.com matches any subdomain of
+// example.com, at any depth, with the same scheme and port. It does not
+// match example.com itself.
+func matchOrigin(pattern, origin string) bool {
+	if pattern == origin {
+		return true
+	}
+
+	scheme, host, ok := strings.Cut(pattern, "://")
+	if !ok || !strings.HasPrefix(host, "*.") {
+		return false
+	}
+	suffix := host[1:]
+	if strings.ContainsAny(suffix, "*/") || len(suffix) < 2 {
+		return false
+	}
+
+	originScheme, originHost, ok := strings.Cut(origin, "://")
+	if !ok || originScheme != scheme || !strings.HasSuffix(originHost, suffix) {
+		return false
+	}
+
+	// The part matched by * must be one or more DNS labels, so it cannot
+	// swallow a port, path or user info
+	sub := strings.TrimSuffix(originHost, suffix)
+	for _, label := range strings.Split(sub, ".") {
+		if label == "" || strings.ContainsAny(label, ":/@") {
+			return false
+		}
+	}
+	return true
+}
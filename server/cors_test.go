@@ -0,0 +1,289 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// preflight returns an OPTIONS request to target from origin.
+func preflight(target, origin string) *http.Request {
+	r := httptest.NewRequest(http.MethodOptions, target, nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+	return r
+}
+
+func TestCORSCredentialsNeverWithWildcard(t *testing.T) {
+	tests := []struct {
+		mode            string
+		origin          string
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{"all", "http://localhost:3000", "*", ""},
+		{"all", "https://example.com", "*", ""},
+		{"localapps", "http://localhost:3000", "http://localhost:3000", "true"},
+		{"localapps", "https://example.com", "", ""},
+		{"localapps", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.origin, func(t *testing.T) {
+			s, _ := newTestServer(t, Config{CorsPolicyMode: tt.mode, CorsAllowCredentials: true, CorsMaxAge: 600})
+
+			rec := serve(s, preflight("/synthesis", tt.origin))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			h := rec.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+			if h.Get("Access-Control-Allow-Origin") == "*" && h.Get("Access-Control-Allow-Credentials") != "" {
+				t.Error("credentials allowed together with a wildcard origin")
+			}
+			if got := h.Get("Access-Control-Max-Age"); got != "600" {
+				t.Errorf("Access-Control-Max-Age = %q, want 600", got)
+			}
+		})
+	}
+}
+
+func TestCORSMaxAgeOnlyOnPreflight(t *testing.T) {
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "all", CorsMaxAge: 600})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/speakers", nil))
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "" {
+		t.Errorf("Access-Control-Max-Age = %q on a GET, want none", got)
+	}
+}
+
+func TestCORSVaryOrigin(t *testing.T) {
+	for mode, want := range map[string]bool{"localapps": true, "all": false} {
+		t.Run(mode, func(t *testing.T) {
+			s, _ := newTestServer(t, Config{CorsPolicyMode: mode})
+
+			r := httptest.NewRequest(http.MethodGet, "/speakers", nil)
+			r.Header.Set("Origin", "http://localhost:3000")
+			rec := serve(s, r)
+
+			got := false
+			for _, v := range rec.Header().Values("Vary") {
+				if v == "Origin" {
+					got = true
+				}
+			}
+			if got != want {
+				t.Errorf("Vary: Origin present = %v, want %v (Vary = %q)", got, want, rec.Header().Values("Vary"))
+			}
+		})
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern, origin string
+		want            bool
+	}{
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "https://www.example.com", false},
+		{"https://*.example.com", "https://www.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://wwwexample.com", false},
+		{"https://*.example.com", "https://www.example.com.evil.com", false},
+		{"https://*.example.com", "http://www.example.com", false},
+		{"https://*.example.com", "https://www.example.com:8443", false},
+		{"https://*.example.com:8443", "https://www.example.com:8443", true},
+		{"https://*.example.com:8443", "https://www.example.com", false},
+		{"https://*.example.com", "https://user@www.example.com", false},
+		{"https://*.example.com", "https://evil.com/.example.com", false},
+		{"*", "https://example.com", false},
+		{"https://*", "https://example.com", false},
+		{"https://*.", "https://example.", false},
+		{"*.example.com", "https://www.example.com", false},
+		{"https://*.*.example.com", "https://a.b.example.com", false},
+		{"", "https://example.com", false},
+	}
+	for _, tt := range tests {
+		if got := matchOrigin(tt.pattern, tt.origin); got != tt.want {
+			t.Errorf("matchOrigin(%q, %q) = %v, want %v", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestAllowOriginWildcard(t *testing.T) {
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "localapps", AllowedOrigin: "https://exact.test https://*.example.com"})
+
+	for origin, want := range map[string]string{
+		"https://exact.test":      "https://exact.test",
+		"https://www.example.com": "https://www.example.com",
+		"http://www.example.com":  "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/speakers", nil)
+		r.Header.Set("Origin", origin)
+		if got := serve(s, r).Header().Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", origin, got, want)
+		}
+	}
+}
+
+func TestCORSStrict(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		origin string
+		want   int
+	}{
+		{"allowed", true, "http://localhost:3000", http.StatusOK},
+		{"disallowed", true, "https://evil.example", http.StatusForbidden},
+		{"no origin", true, "", http.StatusOK},
+		{"disallowed, not strict", false, "https://evil.example", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, engine := newTestServer(t, Config{CorsPolicyMode: "localapps", CorsStrict: tt.strict})
+
+			r := jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"})
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			rec := serve(s, r)
+			if tt.want == http.StatusForbidden {
+				wantError(t, rec, http.StatusForbidden, errCodeOriginNotAllowed)
+				if calls := engine.Calls(); len(calls) != 0 {
+					t.Errorf("engine called %d times for a rejected origin", len(calls))
+				}
+				return
+			}
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d; body: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestCORSAllowHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"defaults", Config{}, "Content-Type, X-Dry-Run, X-Request-ID"},
+		{"api keys", Config{APIKeys: []string{"secret"}}, "Content-Type, X-Dry-Run, X-Request-ID, Authorization, X-API-Key"},
+		{"configured", Config{CorsAllowHeaders: []string{"X-Client", " x-request-id", "", "X-Trace "}}, "Content-Type, X-Dry-Run, X-Request-ID, X-Client, X-Trace"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.CorsPolicyMode = "all"
+			s, _ := newTestServer(t, tt.cfg)
+
+			rec := serve(s, preflight("/synthesis", "https://example.com"))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Headers"); got != tt.want {
+				t.Errorf("Access-Control-Allow-Headers = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCORSAllowMethodsPerRoute(t *testing.T) {
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "all"})
+
+	for target, want := range map[string]string{
+		"/audio_query":        "GET, POST, OPTIONS",
+		"/synthesis":          "POST, OPTIONS",
+		"/synthesis_file":     "POST, OPTIONS",
+		"/synthesis_stream":   "GET, OPTIONS",
+		"/synthesis_batch":    "POST, OPTIONS",
+		"/synthesis_dialogue": "POST, OPTIONS",
+		"/jobs":               "POST, OPTIONS",
+		"/jobs/job":           "GET, OPTIONS",
+		"/speakers":           "GET, OPTIONS",
+		"/speakers/f1/sample": "GET, OPTIONS",
+		"/emotions":           "GET, OPTIONS",
+		"/presets":            "GET, OPTIONS",
+		"/version":            "GET, OPTIONS",
+		"/openapi.json":       "GET, OPTIONS",
+	} {
+		rec := serve(s, preflight(target, "https://example.com"))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", target, rec.Code, http.StatusOK)
+			continue
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Methods"); got != want {
+			t.Errorf("%s: Access-Control-Allow-Methods = %q, want %q", target, got, want)
+		}
+		if got := rec.Header().Get("Allow"); got != want {
+			t.Errorf("%s: Allow = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestOptionsOnEveryRoute(t *testing.T) {
+	s, engine := newTestServer(t, Config{CorsPolicyMode: "all", EnableAdmin: true, APIKeys: []string{"secret"}, RecentRequests: 10})
+	settings := s.settings.Get()
+
+	for target, want := range map[string]string{
+		"/":                 "GET, OPTIONS",
+		"/ws/synthesis":     "GET, OPTIONS",
+		"/docs":             "GET, OPTIONS",
+		"/static/style.css": "GET, HEAD, OPTIONS",
+		"/favicon.ico":      "GET, HEAD, OPTIONS",
+		"/health":           "GET, OPTIONS",
+		"/ready":            "GET, OPTIONS",
+		"/setting":          "GET, OPTIONS",
+		"/playground":       "GET, OPTIONS",
+		"/settings":         "GET, OPTIONS",
+		"/update-settings":  "POST, OPTIONS",
+		"/set-lang":         "POST, OPTIONS",
+		"/admin/shutdown":   "POST, OPTIONS",
+		"/admin/recent":     "GET, OPTIONS",
+	} {
+		rec := serve(s, preflight(target, "https://example.com"))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d; body: %s", target, rec.Code, http.StatusOK, rec.Body)
+			continue
+		}
+		if got := rec.Header().Get("Allow"); got != want {
+			t.Errorf("%s: Allow = %q, want %q", target, got, want)
+		}
+		// These routes are not CORS-wrapped, so browsers still refuse
+		// cross-origin requests to them
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want none", target, got)
+		}
+		if rec.Body.Len() != 0 {
+			t.Errorf("%s: body = %q, want none", target, rec.Body)
+		}
+	}
+
+	// Preflights skip the API key, CSRF and business handlers entirely
+	for _, target := range []string{"/synthesis", "/jobs", "/speakers/f1/sample"} {
+		if rec := serve(s, preflight(target, "https://example.com")); rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", target, rec.Code, http.StatusOK)
+		}
+	}
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times by preflights", len(calls))
+	}
+	if shutdownRequested(s) {
+		t.Error("shutdown requested by a preflight")
+	}
+	if got := s.settings.Get(); got != settings {
+		t.Errorf("settings changed by a preflight: %+v", got)
+	}
+
+	if rec := serve(s, preflight("/no-such-page", "https://example.com")); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown path: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,73 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestCSRFTokenFromSettingsPage(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/setting", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == csrfCookieName {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("settings page did not start a CSRF session")
+	}
+	m := regexp.MustCompile(`<meta name="csrf-token" content="([0-9a-f]+)">`).FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatal("settings page has no CSRF token")
+	}
+
+	r := jsonRequest(t, http.MethodPost, "/update-settings", map[string]any{"corsPolicyMode": "localapps"})
+	r.AddCookie(session)
+	r.Header.Set(csrfHeader, m[1])
+	if rec := serve(s, r); rec.Code != http.StatusOK {
+		t.Errorf("update with the page token: status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
+
+func TestCSRFRejectsInvalidToken(t *testing.T) {
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "localapps"})
+	const session = "test-session"
+
+	tests := map[string]struct {
+		cookie, token string
+	}{
+		"no cookie":           {"", s.csrfToken(session)},
+		"no token":            {session, ""},
+		"wrong token":         {session, "0123abcd"},
+		"other session token": {session, s.csrfToken("other-session")},
+	}
+	for name, tt := range tests {
+		r := jsonRequest(t, http.MethodPost, "/update-settings", map[string]any{"corsPolicyMode": "all", "confirm": true})
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+		}
+		if tt.token != "" {
+			r.Header.Set(csrfHeader, tt.token)
+		}
+		rec := serve(s, r)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want %d", name, rec.Code, http.StatusForbidden)
+			continue
+		}
+		if got := errorCode(t, rec.Body); got != errCodeInvalidCSRFToken {
+			t.Errorf("%s: error code = %q, want %q", name, got, errCodeInvalidCSRFToken)
+		}
+	}
+	if got := s.settings.Get().CorsPolicyMode; got != "localapps" {
+		t.Errorf("CORS policy = %q after rejected updates, want localapps", got)
+	}
+}
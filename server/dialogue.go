@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// dialogueLine is one line of a /synthesis_dialogue request: a query plus
+// the silence that follows it.
+type dialogueLine struct {
+	AudioQuery
+	PauseAfterMs int `json:"pause_after_ms,omitempty"`
+}
+
+// validateDialogueLine checks a single line and its pause. Like a single
+// query, a line may leave out its text when it has a kana override.
+func (s *Server) validateDialogueLine(line *dialogueLine) error {
+	if err := s.validateSynthesisQuery(&line.AudioQuery); err != nil {
+		return err
+	}
+	if line.PauseAfterMs < 0 || time.Duration(line.PauseAfterMs)*time.Millisecond > maxBreak {
+		return newAPIError(http.StatusBadRequest, errCodeInvalidDialogue, fmt.Sprintf("pause_after_ms must be between 0 and %d", maxBreak.Milliseconds()))
+	}
+	return nil
+}
+
+// sameOptional reports whether two optional values are equal.
+func sameOptional(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// handleSynthesisDialogue synthesizes each line with its own voice settings
+// and returns them joined into a single audio file, with the requested pause
+// after each line.
+func (s *Server) handleSynthesisDialogue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var lines []dialogueLine
+	if err := s.decodeJSONBody(w, r, &lines); err != nil {
+		writeAPIError(w, bodyError(err))
+		return
+	}
+
+	if len(lines) == 0 {
+		writeJSONError(w, http.StatusBadRequest, errCodeInvalidDialogue, "Dialogue must contain at least one line")
+		return
+	}
+	if len(lines) > s.cfg.MaxBatch {
+		writeJSONError(w, http.StatusBadRequest, errCodeInvalidDialogue, fmt.Sprintf("Dialogue contains %d lines, the maximum is %d", len(lines), s.cfg.MaxBatch))
+		return
+	}
+
+	// Validate every line first so an invalid one fails the whole dialogue
+	// before any audio is generated
+	for i := range lines {
+		if err := s.validateDialogueLine(&lines[i]); err != nil {
+			writeAPIError(w, indexedError("Line", i, err))
+			return
+		}
+		// The lines are joined into one WAV, so they must share a format
+		if !sameOptional(lines[i].SampleRate, lines[0].SampleRate) {
+			writeJSONError(w, http.StatusBadRequest, errCodeInvalidSampleRate, fmt.Sprintf("Line %d: every line must use the same sample_rate", i))
+			return
+		}
+		if !sameOptional(lines[i].Channels, lines[0].Channels) {
+			writeJSONError(w, http.StatusBadRequest, errCodeInvalidChannels, fmt.Sprintf("Line %d: every line must use the same channels", i))
+			return
+		}
+		if !sameOptional(lines[i].BitDepth, lines[0].BitDepth) {
+			writeJSONError(w, http.StatusBadRequest, errCodeInvalidBitDepth, fmt.Sprintf("Line %d: every line must use the same bit_depth", i))
+			return
+		}
+	}
+
+	format, err := requestedAudioFormat(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if err := s.checkTranscoder(format); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	parts := make([]audioPart, 0, len(lines)+1)
+	allCached := true
+	var pause time.Duration
+	for i, line := range lines {
+		data, cached, err := s.synthesizeQuery(r.Context(), line.AudioQuery)
+		if err != nil {
+			writeAPIError(w, indexedError("Line", i, err))
+			return
+		}
+		parts = append(parts, audioPart{Silence: pause, WAV: data})
+		pause = time.Duration(line.PauseAfterMs) * time.Millisecond
+		allCached = allCached && cached
+	}
+	if pause > 0 {
+		// Keep the pause after the last line
+		parts = append(parts, audioPart{Silence: pause})
+	}
+
+	wav, err := joinWAV(parts)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to join dialogue lines: %v", err))
+		return
+	}
+
+	s.writeAudio(w, r, wav, allCached, format)
+}
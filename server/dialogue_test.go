@@ -0,0 +1,85 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/binary"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// pcmRuns describes 16-bit mono PCM as the durations of its alternating
+// runs of sound and silence, starting with sound.
+func pcmRuns(format wavFormat, pcm []byte) []time.Duration {
+	var runs []time.Duration
+	frame := format.blockAlign()
+	silent, start := false, 0
+	for i := 0; i <= len(pcm); i += frame {
+		if i < len(pcm) && (binary.LittleEndian.Uint16(pcm[i:]) == 0) == silent {
+			continue
+		}
+		runs = append(runs, format.duration(i-start))
+		silent, start = !silent, i
+	}
+	return runs
+}
+
+func TestSynthesisDialogue(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	lines := []dialogueLine{
+		{AudioQuery: AudioQuery{Text: "いち", Speaker: "f1"}, PauseAfterMs: 200},
+		{AudioQuery: AudioQuery{Text: "にいさん", Speaker: "m1"}},
+		{AudioQuery: AudioQuery{Kana: "さん", Speaker: "c"}, PauseAfterMs: 100},
+	}
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis_dialogue", lines))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	calls := engine.Calls()
+	if len(calls) != len(lines) {
+		t.Fatalf("engine called %d times, want %d", len(calls), len(lines))
+	}
+	for i, want := range [][2]string{{"いち", "f1"}, {"にいさん", "m1"}, {"さん", "c"}} {
+		if calls[i].Text != want[0] || calls[i].Opts.Narrator != want[1] {
+			t.Errorf("call %d = %q by %s, want %q by %s", i, calls[i].Text, calls[i].Opts.Narrator, want[0], want[1])
+		}
+	}
+
+	// The lines without a pause between them run together
+	format, pcm, err := decodeWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{2 * fakeRuneDuration, 200 * time.Millisecond, 6 * fakeRuneDuration, 100 * time.Millisecond}
+	got := pcmRuns(format, pcm)
+	if len(got) != len(want) {
+		t.Fatalf("audio runs = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("audio runs = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestSynthesisDialogueInvalidLine(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	for _, lines := range [][]dialogueLine{
+		{{AudioQuery: AudioQuery{Text: "いち", Speaker: "f1"}}, {AudioQuery: AudioQuery{Text: "に", Speaker: "nobody"}}},
+		{{AudioQuery: AudioQuery{Text: "いち", Speaker: "f1"}, PauseAfterMs: -1}},
+		{},
+	} {
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis_dialogue", lines))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("lines %+v: status = %d, want %d", lines, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times for invalid dialogues, want 0", len(calls))
+	}
+}
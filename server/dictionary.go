@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// dictionaryPollInterval is how often the dictionary file is checked for
+// changes.
+const dictionaryPollInterval = 2 * time.Second
+
+// dictionaryEntry replaces source with the segments of its replacement.
+type dictionaryEntry struct {
+	source      string
+	replacement []markupSegment
+}
+
+// dictionary is the list of replacements read from DictionaryFile, applied
+// to query texts before synthesis. It is reloaded when the file changes.
+type dictionary struct {
+	path string
+
+	mu sync.RWMutex
+	// entries are sorted by descending source length, so the longest match
+	// is tried first
+	entries []dictionaryEntry
+	modTime time.Time
+	size    int64
+}
+
+// newDictionary loads the dictionary in path.
+func newDictionary(path string) (*dictionary, error) {
+	d := &dictionary{path: path}
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// loadDictionary reads one entry per line from path, the source and its
+// replacement separated by a tab. The replacement may use the markup of
+// parseMarkup, so <break time="300ms"/> inserts a pause. Blank lines and
+// lines starting with # are ignored.
+func loadDictionary(path string) ([]dictionaryEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dictionary file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []dictionaryEntry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		source, replacement, ok := strings.Cut(line, "\t")
+		if !ok || source == "" {
+			return nil, fmt.Errorf("invalid dictionary entry on line %d: expected a source and a replacement separated by a tab", n)
+		}
+		segments, err := parseMarkup(replacement)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dictionary entry on line %d: %v", n, err)
+		}
+		entries = append(entries, dictionaryEntry{source: source, replacement: segments})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dictionary file: %w", err)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return len(entries[i].source) > len(entries[j].source)
+	})
+	return entries, nil
+}
+
+// reload reads the file again and replaces the loaded entries.
+func (d *dictionary) reload() error {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat dictionary file: %w", err)
+	}
+	entries, err := loadDictionary(d.path)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.entries = entries
+	d.modTime = info.ModTime()
+	d.size = info.Size()
+	d.mu.Unlock()
+	return nil
+}
+
+// changed reports whether the file differs from the loaded version.
+func (d *dictionary) changed() bool {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return false
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return !info.ModTime().Equal(d.modTime) || info.Size() != d.size
+}
+
+// apply replaces the dictionary sources in the text of segments, scanning
+// from the start and taking the longest source at each position. Pauses in
+// replacements split the segments. When nothing would be left to
+// synthesize, segments are returned unchanged. A nil dictionary changes
+// nothing.
+func (d *dictionary) apply(segments []markupSegment) []markupSegment {
+	if d == nil {
+		return segments
+	}
+	d.mu.RLock()
+	entries := d.entries
+	d.mu.RUnlock()
+	if len(entries) == 0 {
+		return segments
+	}
+
+	var out []markupSegment
+	var sb strings.Builder
+	current := markupSegment{}
+	flush := func() {
+		current.Text = strings.TrimSpace(sb.String())
+		if current.Text != "" || current.Pause > 0 {
+			out = append(out, current)
+		}
+		current = markupSegment{}
+		sb.Reset()
+	}
+
+	for _, seg := range segments {
+		current.Pause += seg.Pause
+		text := seg.Text
+		for i := 0; i < len(text); {
+			entry, ok := matchEntry(entries, text[i:])
+			if !ok {
+				_, size := utf8.DecodeRuneInString(text[i:])
+				sb.WriteString(text[i : i+size])
+				i += size
+				continue
+			}
+			for _, rep := range entry.replacement {
+				if rep.Pause > 0 {
+					if strings.TrimSpace(sb.String()) != "" {
+						flush()
+					}
+					current.Pause += rep.Pause
+				}
+				sb.WriteString(rep.Text)
+			}
+			i += len(entry.source)
+		}
+		flush()
+	}
+
+	for _, seg := range out {
+		if seg.Text != "" {
+			return out
+		}
+	}
+	return segments
+}
+
+// replaceText applies the dictionary to text without its pauses, for
+// endpoints that synthesize text piece by piece.
+func (d *dictionary) replaceText(text string) string {
+	if d == nil {
+		return text
+	}
+	var sb strings.Builder
+	for _, seg := range d.apply([]markupSegment{{Text: text}}) {
+		sb.WriteString(seg.Text)
+	}
+	return sb.String()
+}
+
+// matchEntry returns the first entry whose source text starts with.
+func matchEntry(entries []dictionaryEntry, text string) (dictionaryEntry, bool) {
+	for _, entry := range entries {
+		if strings.HasPrefix(text, entry.source) {
+			return entry, true
+		}
+	}
+	return dictionaryEntry{}, false
+}
+
+// watch polls the file until done is closed and reloads it when it changes.
+// When the file cannot be read the previous entries stay in effect.
+func (d *dictionary) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(dictionaryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if !d.changed() {
+			continue
+		}
+		if err := d.reload(); err != nil {
+			slog.Warn("Failed to reload dictionary", "path", d.path, "error", err)
+			continue
+		}
+		slog.Info("Reloaded dictionary", "path", d.path)
+	}
+}
@@ -0,0 +1,106 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeDictionary writes content to a dictionary file in a temporary
+// directory and returns its path.
+func writeDictionary(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dictionary.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// dictionaryTexts returns the texts the engine is asked to read for text
+// with the dictionary in content.
+func dictionaryTexts(t *testing.T, content, text string) []string {
+	t.Helper()
+	s, engine := newTestServer(t, Config{DictionaryFile: writeDictionary(t, content)})
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: text, Speaker: "f1"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var texts []string
+	for _, call := range engine.Calls() {
+		texts = append(texts, call.Text)
+	}
+	return texts
+}
+
+func TestDictionaryReplacement(t *testing.T) {
+	got := dictionaryTexts(t, "# readings\nAPI\tエーピーアイ\n\nVOICEPEAK\tボイスピーク\n", "VOICEPEAKのAPIです")
+	if want := []string{"ボイスピークのエーピーアイです"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("engine texts = %q, want %q", got, want)
+	}
+}
+
+func TestDictionaryLongestMatch(t *testing.T) {
+	// The shorter source comes first in the file but the longer one wins
+	got := dictionaryTexts(t, "東京\tとうきょう\n東京都\tとうきょうと\n", "東京都と東京")
+	if want := []string{"とうきょうとととうきょう"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("engine texts = %q, want %q", got, want)
+	}
+}
+
+func TestDictionaryPause(t *testing.T) {
+	got := dictionaryTexts(t, "、\t<break time=\"300ms\"/>\n", "はじめに、つぎに")
+	if want := []string{"はじめに", "つぎに"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("engine texts = %q, want %q", got, want)
+	}
+}
+
+func TestDictionaryReload(t *testing.T) {
+	path := writeDictionary(t, "犬\tいぬ\n")
+	d, err := newDictionary(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.replaceText("犬と猫"); got != "いぬと猫" {
+		t.Errorf("replaceText = %q, want いぬと猫", got)
+	}
+
+	if d.changed() {
+		t.Error("unchanged file reported as changed")
+	}
+	if err := os.WriteFile(path, []byte("犬\tいぬ\n猫\tねこ\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if !d.changed() {
+		t.Fatal("edited file not reported as changed")
+	}
+	if err := d.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.replaceText("犬と猫"); got != "いぬとねこ" {
+		t.Errorf("replaceText after reload = %q, want いぬとねこ", got)
+	}
+}
+
+func TestNewRejectsInvalidDictionary(t *testing.T) {
+	for name, content := range map[string]string{
+		"no tab":         "犬 いぬ\n",
+		"empty source":   "\tいぬ\n",
+		"invalid markup": "犬\t<break time=\"forever\"/>\n",
+	} {
+		cfg := Config{Engine: &fakeEngine{}, TmpDir: t.TempDir(), DictionaryFile: writeDictionary(t, content)}
+		if s, err := New(cfg); err == nil {
+			s.Close()
+			t.Errorf("%s: New accepted the dictionary", name)
+		}
+	}
+}
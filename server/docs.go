@@ -0,0 +1,36 @@
+package server
+
+import (
+	"embed"
+	"net/http"
+)
+
+// apiFS holds the OpenAPI document and the page rendering it.
+//
+//go:embed api/openapi.json api/docs.html
+var apiFS embed.FS
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	serveAPIFile(w, r, "api/openapi.json", "application/json")
+}
+
+func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
+	serveAPIFile(w, r, "api/docs.html", "text/html; charset=utf-8")
+}
+
+// serveAPIFile writes an embedded file for GET requests.
+func serveAPIFile(w http.ResponseWriter, r *http.Request, name, contentType string) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	data, err := apiFS.ReadFile(name)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
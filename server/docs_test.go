@@ -0,0 +1,49 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	s, _ := newTestServer(t, Config{EnableAdmin: true, APIKeys: []string{"key"}, RecentRequests: 10})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("document is not JSON: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want a 3.x version", doc.OpenAPI)
+	}
+	for _, path := range []string{"/audio_query", "/synthesis", "/speakers", "/version"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("document does not describe %s", path)
+		}
+	}
+
+	// Every documented operation has to be served by the route it names
+	for path, ops := range doc.Paths {
+		target := strings.NewReplacer("{id}", "job", "{name}", "f1").Replace(path)
+		allow := serve(s, httptest.NewRequest(http.MethodOptions, target, nil)).Header().Get("Allow")
+		for method := range ops {
+			if !strings.Contains(allow, strings.ToUpper(method)) {
+				t.Errorf("%s %s is documented, but the route allows %q", strings.ToUpper(method), path, allow)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"slices"
+
+	"github.com/shinshin86/vpeak"
+)
+
+// Engine generates speech. The server only talks to the speech engine
+// through this interface, so tests and alternative backends can replace
+// vpeak.
+type Engine interface {
+	// Synthesize writes the speech for text as a WAV file to opts.Output.
+	Synthesize(ctx context.Context, text string, opts vpeak.Options) error
+	// Check reports whether the engine can be used, for /ready.
+	Check() error
+}
+
+// Errors an Engine can wrap so that the failure is reported to the client
+// with the right status, see engineError.
+var (
+	// errEngineUnavailable means the engine cannot run at all.
+	errEngineUnavailable = errors.New("engine unavailable")
+	// errUnknownNarrator means the engine does not know the speaker.
+	errUnknownNarrator = errors.New("unknown narrator")
+	// errUnsupportedEmotion means the engine cannot apply the emotion.
+	errUnsupportedEmotion = errors.New("unsupported emotion")
+)
+
+// engineError converts an error returned by an Engine into the error
+// reported to the client:
+//
+//   - errUnknownNarrator: 400 unknown_speaker
+//   - errUnsupportedEmotion: 400 unsupported_emotion
+//   - errEngineUnavailable: 503 engine_unavailable
+//   - anything else: 500 synthesis_failed
+//
+// vpeak only reports a failing VOICEPEAK run as its exit status, so other
+// failures cannot be told apart and count as server faults.
+func engineError(err error) *apiError {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, errUnknownNarrator):
+		return newAPIError(http.StatusBadRequest, errCodeUnknownSpeaker, err.Error())
+	case errors.Is(err, errUnsupportedEmotion):
+		return newAPIError(http.StatusBadRequest, errCodeUnsupportedEmotion, err.Error())
+	case errors.Is(err, errEngineUnavailable):
+		return newAPIError(http.StatusServiceUnavailable, errCodeEngineUnavailable, err.Error())
+	}
+	return newAPIError(http.StatusInternalServerError, errCodeSynthesisFailed, fmt.Sprintf("Failed to generate speech: %v", err))
+}
+
+// vpeakEngine runs VOICEPEAK through vpeak.
+type vpeakEngine struct{}
+
+// Synthesize calls vpeak.GenerateSpeech. vpeak cannot cancel a running
+// engine, so ctx is not used.
+func (e vpeakEngine) Synthesize(ctx context.Context, text string, opts vpeak.Options) error {
+	// vpeak exits the process when the binary is missing or the narrator or
+	// emotion is unknown, so those are checked first. A speakers file may
+	// list narrators vpeak does not know.
+	if err := e.Check(); err != nil {
+		return err
+	}
+	if opts.Narrator != "" && !slices.ContainsFunc(defaultSpeakers, func(sp Speaker) bool { return sp.Name == opts.Narrator }) {
+		return fmt.Errorf("%w: vpeak does not support narrator %s", errUnknownNarrator, opts.Narrator)
+	}
+	if opts.Emotion != "" && !slices.Contains(engineEmotions, opts.Emotion) {
+		return fmt.Errorf("%w: vpeak does not support emotion %s", errUnsupportedEmotion, opts.Emotion)
+	}
+	return vpeak.GenerateSpeech(text, opts)
+}
+
+// Check reports whether the VOICEPEAK binary used by vpeak can be executed.
+func (vpeakEngine) Check() error {
+	if _, err := exec.LookPath(vpeak.VoicepeakPath); err != nil {
+		return fmt.Errorf("voicepeak not available: %w: %w", errEngineUnavailable, err)
+	}
+	return nil
+}
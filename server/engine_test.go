@@ -0,0 +1,127 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shinshin86/vpeak"
+)
+
+func TestFakeEngineDrivesSynthesis(t *testing.T) {
+	dir := t.TempDir()
+	s, engine := newTestServer(t, Config{TmpDir: dir})
+	ts := httptest.NewServer(s.Routes())
+	defer ts.Close()
+
+	body := `{"text": "エンジン", "speaker": "m2", "emotion": "angry", "speed": 150, "pitch": -100}`
+	resp, err := http.Post(ts.URL+"/synthesis", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", resp.StatusCode, http.StatusOK, data)
+	}
+	if !bytes.Equal(data, fakeWAV("エンジン")) {
+		t.Error("body is not the WAV written by the engine")
+	}
+
+	calls := engine.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("engine called %d times, want 1", len(calls))
+	}
+	call := calls[0]
+	opts := call.Opts
+	if call.Text != "エンジン" || opts.Narrator != "m2" || opts.Emotion != "angry" || !opts.Silent ||
+		opts.Speed == nil || *opts.Speed != 150 || opts.Pitch == nil || *opts.Pitch != -100 {
+		t.Errorf("engine call = %+v, want the query options", call)
+	}
+	if filepath.Dir(opts.Output) != dir {
+		t.Errorf("engine output %s is not in the audio directory %s", opts.Output, dir)
+	}
+}
+
+func TestEngineErrorStatuses(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"unknown narrator", fmt.Errorf("voicepeak: %w", errUnknownNarrator), http.StatusBadRequest, errCodeUnknownSpeaker},
+		{"unsupported emotion", fmt.Errorf("voicepeak: %w", errUnsupportedEmotion), http.StatusBadRequest, errCodeUnsupportedEmotion},
+		{"engine unavailable", fmt.Errorf("voicepeak: %w", errEngineUnavailable), http.StatusServiceUnavailable, errCodeEngineUnavailable},
+		{"api error", newAPIError(http.StatusBadRequest, errCodeTextTooLong, "too long"), http.StatusBadRequest, errCodeTextTooLong},
+		{"other failure", errors.New("exit status 1"), http.StatusInternalServerError, errCodeSynthesisFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := &fakeEngine{synthesize: func(ctx context.Context, text string, opts vpeak.Options) error {
+				return tt.err
+			}}
+			s, _ := newTestServer(t, Config{Engine: engine})
+
+			rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+			wantError(t, rec, tt.status, tt.code)
+		})
+	}
+}
+
+// useMissingVoicepeak points vpeak at a VOICEPEAK binary that does not exist
+// until the test ends.
+func useMissingVoicepeak(t *testing.T) {
+	t.Helper()
+	path := vpeak.VoicepeakPath
+	vpeak.VoicepeakPath = filepath.Join(t.TempDir(), "voicepeak")
+	t.Cleanup(func() { vpeak.VoicepeakPath = path })
+}
+
+func TestNewRequireEngine(t *testing.T) {
+	useMissingVoicepeak(t)
+
+	s, err := New(Config{Engine: vpeakEngine{}, TmpDir: t.TempDir(), RequireEngine: true})
+	if err == nil {
+		s.Close()
+		t.Fatal("New accepted a missing engine with RequireEngine")
+	}
+	if !errors.Is(err, errEngineUnavailable) {
+		t.Errorf("error = %v, want one wrapping errEngineUnavailable", err)
+	}
+}
+
+func TestMissingEngineDegraded(t *testing.T) {
+	useMissingVoicepeak(t)
+	s, _ := newTestServer(t, Config{Engine: vpeakEngine{}})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/ready: status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	query := AudioQuery{Text: "こんにちは", Speaker: "f1"}
+	wantError(t, serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)), http.StatusServiceUnavailable, errCodeEngineUnavailable)
+
+	// Pages and information that do not run the engine keep working
+	for _, target := range []string{"/health", "/speakers", "/emotions", "/version", "/setting", "/settings"} {
+		if rec := serve(s, httptest.NewRequest(http.MethodGet, target, nil)); rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", target, rec.Code, http.StatusOK)
+		}
+	}
+	if rec := serve(s, jsonRequest(t, http.MethodPost, "/audio_query", query)); rec.Code != http.StatusOK {
+		t.Errorf("/audio_query: status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Stable error codes returned in the "code" field of JSON error responses.
+const (
+	errCodeMethodNotAllowed     = "method_not_allowed"
+	errCodeInvalidBody          = "invalid_request_body"
+	errCodeMissingParameters    = "missing_parameters"
+	errCodeInvalidSpeed         = "invalid_speed"
+	errCodeInvalidPitch         = "invalid_pitch"
+	errCodeInvalidEmotionLevel  = "invalid_emotion_level"
+	errCodeInvalidSampleRate    = "invalid_sample_rate"
+	errCodeInvalidChannels      = "invalid_channels"
+	errCodeInvalidBitDepth      = "invalid_bit_depth"
+	errCodeInvalidVolume        = "invalid_volume"
+	errCodeInvalidDuration      = "invalid_target_duration"
+	errCodeInvalidSilence       = "invalid_silence"
+	errCodeInvalidKana          = "invalid_kana"
+	errCodeUnknownSpeaker       = "unknown_speaker"
+	errCodeUnknownPreset        = "unknown_preset"
+	errCodeLanguageUndetected   = "language_undetected"
+	errCodeUnsupportedEmotion   = "unsupported_emotion"
+	errCodeInvalidMarkup        = "invalid_markup"
+	errCodeInvalidRomaji        = "invalid_romaji"
+	errCodeRequestTooLarge      = "request_too_large"
+	errCodeTextTooLarge         = "text_too_large"
+	errCodeTextTooLong          = "text_too_long"
+	errCodeUnsupportedFormat    = "unsupported_format"
+	errCodeInvalidBatch         = "invalid_batch"
+	errCodeInvalidDialogue      = "invalid_dialogue"
+	errCodeJobNotFound          = "job_not_found"
+	errCodeJobNotReady          = "job_not_ready"
+	errCodeUnauthorized         = "unauthorized"
+	errCodeOriginNotAllowed     = "origin_not_allowed"
+	errCodeInvalidCSRFToken     = "invalid_csrf_token"
+	errCodeConfirmationRequired = "confirmation_required"
+	errCodeInvalidCorsPolicy    = "invalid_cors_policy"
+	errCodeRateLimited          = "rate_limited"
+	errCodeServerBusy           = "server_busy"
+	errCodeEngineUnavailable    = "engine_unavailable"
+	errCodeSynthesisFailed      = "synthesis_failed"
+	errCodeSynthesisTimeout     = "synthesis_timeout"
+	errCodeTranscodeFailed      = "transcode_failed"
+	errCodeInternal             = "internal_error"
+)
+
+// apiError is an error that knows how it should be reported to the client.
+// Errors made with newLocalizedError also carry the catalog key of their
+// message, so it can be sent in the client's language.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+
+	key  string
+	args []any
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{Status: status, Code: code, Message: message}
+}
+
+// newLocalizedError returns an error whose message is the catalog message
+// for key. Message holds the English text.
+func newLocalizedError(status int, code, key string, args ...any) *apiError {
+	return &apiError{Status: status, Code: code, Message: localize("en", key, args...), key: key, args: args}
+}
+
+// messageIn returns the message of e in lang.
+func (e *apiError) messageIn(lang string) string {
+	if e.key == "" {
+		return e.Message
+	}
+	return localize(lang, e.key, e.args...)
+}
+
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeJSONError writes {"error":{"code":...,"message":...}} with the given status.
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: errorBody{Code: code, Message: message}})
+}
+
+// errorBodyOf returns the code and message in lang reported for err, using
+// internal_error unless it is an *apiError.
+func errorBodyOf(err error, lang string) errorBody {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return errorBody{Code: apiErr.Code, Message: apiErr.messageIn(lang)}
+	}
+	return errorBody{Code: errCodeInternal, Message: err.Error()}
+}
+
+// writeAPIError reports err to the client, using its status and code when it
+// is an *apiError and a generic 500 otherwise.
+func writeAPIError(w http.ResponseWriter, err error) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeJSONError(w, apiErr.Status, apiErr.Code, apiErr.messageIn(responseLang(w)))
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+}
+
+// writeLocalizedError writes the catalog message for key in the language of
+// the request.
+func writeLocalizedError(w http.ResponseWriter, status int, code, key string, args ...any) {
+	writeAPIError(w, newLocalizedError(status, code, key, args...))
+}
+
+// writeMethodNotAllowed reports that only the given methods (one or two) are
+// allowed.
+func writeMethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	if len(methods) == 2 {
+		writeLocalizedError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, msgMethodsNotAllowed, methods[0], methods[1])
+		return
+	}
+	writeLocalizedError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, msgMethodNotAllowed, strings.Join(methods, ", "))
+}
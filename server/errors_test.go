@@ -0,0 +1,60 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONErrors(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	tests := []struct {
+		name   string
+		r      *http.Request
+		status int
+		code   string
+	}{
+		{"wrong method", httptest.NewRequest(http.MethodGet, "/synthesis", nil), http.StatusMethodNotAllowed, errCodeMethodNotAllowed},
+		{"malformed body", httptest.NewRequest(http.MethodPost, "/synthesis", strings.NewReader("{")), http.StatusBadRequest, errCodeInvalidBody},
+		{"missing text", jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Speaker: "f1"}), http.StatusBadRequest, errCodeMissingParameters},
+		{"unknown speaker", jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "あ", Speaker: "nobody"}), http.StatusBadRequest, errCodeUnknownSpeaker},
+		{"speed out of range", httptest.NewRequest(http.MethodGet, "/audio_query?text=a&speaker=f1&speed=20", nil), http.StatusBadRequest, errCodeInvalidSpeed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(s, tt.r)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+			}
+
+			var body errorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("body is not a JSON error: %v", err)
+			}
+			if body.Error.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Error.Code, tt.code)
+			}
+			if body.Error.Message == "" {
+				t.Error("message is empty")
+			}
+		})
+	}
+}
+
+func TestWriteAPIErrorWithPlainError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeAPIError(rec, errEngineUnavailable)
+
+	wantError(t, rec, http.StatusInternalServerError, errCodeInternal)
+}
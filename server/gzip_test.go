@@ -0,0 +1,84 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"deflate, gzip;q=0.5": true,
+		"GZIP":                true,
+		"gzip;q=0":            false,
+		"br, deflate":         false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", header)
+		if got := acceptsGzip(r); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestGzipCompressesJSON(t *testing.T) {
+	s, _ := newTestServer(t, Config{EnableGzip: true})
+
+	r := httptest.NewRequest(http.MethodGet, "/speakers", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := serve(s, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("body is not gzip data: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var speakers []Speaker
+	if err := json.Unmarshal(data, &speakers); err != nil || len(speakers) == 0 {
+		t.Errorf("decompressed body is not the speaker list: %v", err)
+	}
+}
+
+func TestGzipLeavesAudioAlone(t *testing.T) {
+	s, _ := newTestServer(t, Config{EnableGzip: true})
+
+	r := jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"})
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := serve(s, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q for audio, want none", got)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), fakeWAV("こんにちは")) {
+		t.Error("body is not the WAV written by the engine")
+	}
+}
+
+func TestGzipDisabled(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	r := httptest.NewRequest(http.MethodGet, "/speakers", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	if got := serve(s, r).Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q without EnableGzip, want none", got)
+	}
+}
@@ -0,0 +1,923 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shinshin86/vpeakserver/buildinfo"
+)
+
+// Accepted ranges of the speed and pitch parameters.
+const (
+	SpeedMin = 50
+	SpeedMax = 200
+	PitchMin = -300
+	PitchMax = 300
+
+	EmotionLevelMin = 0
+	EmotionLevelMax = 100
+
+	VolumeMin = 0
+	VolumeMax = 200
+
+	// TargetDurationMin and TargetDurationMax bound target_duration_ms.
+	TargetDurationMin = 1
+	TargetDurationMax = 10 * 60 * 1000
+
+	// SilenceMin and SilenceMax bound lead_silence_ms and trail_silence_ms.
+	SilenceMin = 0
+	SilenceMax = 10 * 1000
+)
+
+type AudioQuery struct {
+	ID      string `json:"id,omitempty"`
+	Text    string `json:"text"`
+	Speaker string `json:"speaker"`
+	Emotion string `json:"emotion"`
+	Speed   *int   `json:"speed,omitempty"`
+	Pitch   *int   `json:"pitch,omitempty"`
+	// EmotionLevel is the emotion intensity from 0 to 100. vpeak always
+	// applies emotions at full strength, so 0 disables the emotion and any
+	// other value keeps it.
+	EmotionLevel *int `json:"emotion_level,omitempty"`
+	// SampleRate, when set, resamples the audio to one of
+	// supportedSampleRates.
+	SampleRate *int `json:"sample_rate,omitempty"`
+	// Channels and BitDepth, when set, convert the audio to one of
+	// supportedChannels and supportedBitDepths, see convertPCM.
+	Channels *int `json:"channels,omitempty"`
+	BitDepth *int `json:"bit_depth,omitempty"`
+	// Volume is the loudness in percent of the engine output. vpeak has no
+	// volume option, so a gain is applied to the audio, see applyVolume.
+	Volume *int `json:"volume,omitempty"`
+	// TargetDurationMs, when set, trims the audio or pads it with trailing
+	// silence to last exactly this many milliseconds, see fitDuration.
+	TargetDurationMs *int `json:"target_duration_ms,omitempty"`
+	// LeadSilenceMs and TrailSilenceMs pad the audio with this many
+	// milliseconds of silence at the start and end, see padSilence.
+	LeadSilenceMs  *int `json:"lead_silence_ms,omitempty"`
+	TrailSilenceMs *int `json:"trail_silence_ms,omitempty"`
+	// Kana, when set, is synthesized instead of Text to override the reading,
+	// see spokenText.
+	Kana string `json:"kana,omitempty"`
+	// Markup makes Text be parsed as an SSML subset, see parseMarkup.
+	Markup bool `json:"markup,omitempty"`
+	// Romaji makes Text be converted from romaji to hiragana before
+	// synthesis, see romajiToKana.
+	Romaji bool `json:"romaji,omitempty"`
+	// Preset names a Preset whose settings fill in the fields left out, see
+	// applyPreset.
+	Preset string `json:"preset,omitempty"`
+}
+
+type SettingsData struct {
+	CorsPolicyMode string
+	AllowOrigin    string
+	Lang           string
+	CSRFToken      string `json:"-"`
+}
+
+// normalizeEmotion blanks emotions with a level of 0 and replaces missing
+// emotions and ones the speaker does not support with its default emotion,
+// which is usually blank. The level is dropped when no emotion is left.
+func (s *Server) normalizeEmotion(query *AudioQuery) {
+	if query.EmotionLevel != nil && *query.EmotionLevel == 0 {
+		query.Emotion = ""
+	} else if !s.isValidEmotion(query.Speaker, query.Emotion) {
+		query.Emotion = s.defaultEmotion(query.Speaker)
+	}
+	if query.Emotion == "" {
+		query.EmotionLevel = nil
+	}
+}
+
+func parseOptionalIntParam(raw string, min, max int) (*int, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert to integer: %w", err)
+	}
+
+	if value < min || value > max {
+		return nil, fmt.Errorf("value must be between %d and %d", min, max)
+	}
+
+	return &value, nil
+}
+
+func validateOptionalRange(value *int, min, max int) error {
+	if value == nil {
+		return nil
+	}
+
+	val := *value
+	if val < min || val > max {
+		return fmt.Errorf("value must be between %d and %d", min, max)
+	}
+	return nil
+}
+
+// decodeJSONBody decodes the JSON request body into v. At most
+// MaxRequestBytes are read, so an oversized body cannot exhaust memory.
+func (s *Server) decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxRequestBytes)
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// bodyError reports an error from decodeJSONBody: 413 when the body was too
+// large and 400 otherwise.
+func bodyError(err error) *apiError {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return newLocalizedError(http.StatusRequestEntityTooLarge, errCodeRequestTooLarge, msgRequestTooLarge, maxErr.Limit)
+	}
+	return newLocalizedError(http.StatusBadRequest, errCodeInvalidBody, msgInvalidBody, err)
+}
+
+// mergeAudioQuery overwrites the fields of dst with the ones set in src.
+func mergeAudioQuery(dst *AudioQuery, src AudioQuery) {
+	if src.Text != "" {
+		dst.Text = src.Text
+	}
+	if src.Speaker != "" {
+		dst.Speaker = src.Speaker
+	}
+	if src.Emotion != "" {
+		dst.Emotion = src.Emotion
+	}
+	if src.Speed != nil {
+		dst.Speed = src.Speed
+	}
+	if src.Pitch != nil {
+		dst.Pitch = src.Pitch
+	}
+	if src.EmotionLevel != nil {
+		dst.EmotionLevel = src.EmotionLevel
+	}
+	if src.SampleRate != nil {
+		dst.SampleRate = src.SampleRate
+	}
+	if src.Channels != nil {
+		dst.Channels = src.Channels
+	}
+	if src.BitDepth != nil {
+		dst.BitDepth = src.BitDepth
+	}
+	if src.Volume != nil {
+		dst.Volume = src.Volume
+	}
+	if src.TargetDurationMs != nil {
+		dst.TargetDurationMs = src.TargetDurationMs
+	}
+	if src.LeadSilenceMs != nil {
+		dst.LeadSilenceMs = src.LeadSilenceMs
+	}
+	if src.TrailSilenceMs != nil {
+		dst.TrailSilenceMs = src.TrailSilenceMs
+	}
+	if src.Kana != "" {
+		dst.Kana = src.Kana
+	}
+	if src.Preset != "" {
+		dst.Preset = src.Preset
+	}
+	if src.Romaji {
+		dst.Romaji = true
+	}
+}
+
+// normalizeLang returns lang if it is a supported page language and "ja"
+// otherwise, so arbitrary cookie values never reach the templates.
+func normalizeLang(lang string) string {
+	switch lang {
+	case "ja", "en":
+		return lang
+	}
+	return "ja"
+}
+
+// requestLang returns the page language from the lang cookie, defaulting to
+// Japanese.
+func requestLang(r *http.Request) string {
+	var lang string
+	if langCookie, err := r.Cookie("lang"); err == nil {
+		lang = langCookie.Value
+	}
+	return normalizeLang(lang)
+}
+
+// queryFromURL builds an AudioQuery from the URL query parameters.
+func queryFromURL(r *http.Request) (AudioQuery, error) {
+	return queryFromValues(r.URL.Query())
+}
+
+// queryFromValues builds an AudioQuery from URL or form values.
+func queryFromValues(values url.Values) (AudioQuery, error) {
+	speed, err := parseOptionalIntParam(values.Get("speed"), SpeedMin, SpeedMax)
+	if err != nil {
+		return AudioQuery{}, newAPIError(http.StatusBadRequest, errCodeInvalidSpeed, fmt.Sprintf("Invalid speed parameter: %v", err))
+	}
+
+	pitch, err := parseOptionalIntParam(values.Get("pitch"), PitchMin, PitchMax)
+	if err != nil {
+		return AudioQuery{}, newAPIError(http.StatusBadRequest, errCodeInvalidPitch, fmt.Sprintf("Invalid pitch parameter: %v", err))
+	}
+
+	level, err := parseOptionalIntParam(values.Get("emotion_level"), EmotionLevelMin, EmotionLevelMax)
+	if err != nil {
+		return AudioQuery{}, newAPIError(http.StatusBadRequest, errCodeInvalidEmotionLevel, fmt.Sprintf("Invalid emotion_level parameter: %v", err))
+	}
+
+	rate, err := parseSampleRateParam(values.Get("sample_rate"))
+	if err != nil {
+		return AudioQuery{}, err
+	}
+
+	channels, bitDepth, err := parsePCMFormatParams(values.Get("channels"), values.Get("bit_depth"))
+	if err != nil {
+		return AudioQuery{}, err
+	}
+
+	volume, err := parseOptionalIntParam(values.Get("volume"), VolumeMin, VolumeMax)
+	if err != nil {
+		return AudioQuery{}, rangeError(errCodeInvalidVolume, "volume", Range{VolumeMin, VolumeMax}, "")
+	}
+
+	target, err := parseOptionalIntParam(values.Get("target_duration_ms"), TargetDurationMin, TargetDurationMax)
+	if err != nil {
+		return AudioQuery{}, newAPIError(http.StatusBadRequest, errCodeInvalidDuration, fmt.Sprintf("Invalid target_duration_ms parameter: %v", err))
+	}
+
+	lead, err := parseOptionalIntParam(values.Get("lead_silence_ms"), SilenceMin, SilenceMax)
+	if err != nil {
+		return AudioQuery{}, newAPIError(http.StatusBadRequest, errCodeInvalidSilence, fmt.Sprintf("Invalid lead_silence_ms parameter: %v", err))
+	}
+
+	trail, err := parseOptionalIntParam(values.Get("trail_silence_ms"), SilenceMin, SilenceMax)
+	if err != nil {
+		return AudioQuery{}, newAPIError(http.StatusBadRequest, errCodeInvalidSilence, fmt.Sprintf("Invalid trail_silence_ms parameter: %v", err))
+	}
+
+	return AudioQuery{
+		Text:             values.Get("text"),
+		Speaker:          values.Get("speaker"),
+		Emotion:          values.Get("emotion"),
+		Kana:             values.Get("kana"),
+		Preset:           values.Get("preset"),
+		Romaji:           values.Get("romaji") == "true",
+		Speed:            speed,
+		Pitch:            pitch,
+		EmotionLevel:     level,
+		SampleRate:       rate,
+		Channels:         channels,
+		BitDepth:         bitDepth,
+		Volume:           volume,
+		TargetDurationMs: target,
+		LeadSilenceMs:    lead,
+		TrailSilenceMs:   trail,
+	}, nil
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	// Answered here rather than by answerOptions, which would also answer
+	// for the unknown paths this pattern catches
+	if r.Method == http.MethodOptions {
+		writeOptions(w, allowedMethods([]string{http.MethodGet}))
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if prefersJSON(r) {
+		s.writeIndexJSON(w)
+		return
+	}
+
+	lang := requestLang(r)
+
+	data := SettingsData{
+		Lang: lang,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) handleAudioQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	if id, ok := s.voicevoxStyleID(r); ok {
+		s.handleVoicevoxAudioQuery(w, r, id)
+		return
+	}
+
+	audioQuery, err := queryFromURL(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	// Fields sent in a JSON body take precedence over the query string,
+	// which allows long texts that do not fit in a URL
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var body AudioQuery
+		if err := s.decodeJSONBody(w, r, &body); err != nil && err != io.EOF {
+			writeAPIError(w, bodyError(err))
+			return
+		}
+		mergeAudioQuery(&audioQuery, body)
+	}
+	s.sanitizeQuery(&audioQuery)
+	if err := trimQueryText(&audioQuery); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if err := convertRomaji(&audioQuery); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if err := s.applyPreset(&audioQuery); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	speaker, err := s.resolveSpeaker(audioQuery.Speaker)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	audioQuery.Speaker = speaker
+	if audioQuery.Text != "" {
+		if err := s.autoNarrator(&audioQuery); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+	}
+
+	if audioQuery.Text == "" || audioQuery.Speaker == "" {
+		writeLocalizedError(w, http.StatusBadRequest, errCodeMissingParameters, msgMissingTextAndSpeaker)
+		return
+	}
+
+	if err := s.validateTextLength(audioQuery.Text); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	if err := s.validateSpeaker(audioQuery.Speaker); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	if err := s.validateVoiceRanges(audioQuery); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	if err := validateOptionalRange(audioQuery.EmotionLevel, EmotionLevelMin, EmotionLevelMax); err != nil {
+		writeAPIError(w, rangeError(errCodeInvalidEmotionLevel, "emotion_level", Range{EmotionLevelMin, EmotionLevelMax}, ""))
+		return
+	}
+
+	if err := validateSampleRate(audioQuery.SampleRate); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	if err := validatePCMFormat(audioQuery.Channels, audioQuery.BitDepth); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	if err := validateOptionalRange(audioQuery.Volume, VolumeMin, VolumeMax); err != nil {
+		writeAPIError(w, rangeError(errCodeInvalidVolume, "volume", Range{VolumeMin, VolumeMax}, ""))
+		return
+	}
+
+	if err := validateOptionalRange(audioQuery.TargetDurationMs, TargetDurationMin, TargetDurationMax); err != nil {
+		writeAPIError(w, rangeError(errCodeInvalidDuration, "target_duration_ms", Range{TargetDurationMin, TargetDurationMax}, ""))
+		return
+	}
+
+	if err := validateOptionalRange(audioQuery.LeadSilenceMs, SilenceMin, SilenceMax); err != nil {
+		writeAPIError(w, rangeError(errCodeInvalidSilence, "lead_silence_ms", Range{SilenceMin, SilenceMax}, ""))
+		return
+	}
+
+	if err := validateOptionalRange(audioQuery.TrailSilenceMs, SilenceMin, SilenceMax); err != nil {
+		writeAPIError(w, rangeError(errCodeInvalidSilence, "trail_silence_ms", Range{SilenceMin, SilenceMax}, ""))
+		return
+	}
+
+	s.normalizeEmotion(&audioQuery)
+
+	if audioQuery.Kana != "" {
+		if err := validateKana(audioQuery.Kana); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+	} else if kana, err := s.analyzeKana(r.Context(), audioQuery.Text); err == nil {
+		audioQuery.Kana = kana
+	} else {
+		// The preview is optional, so the query is still returned without it
+		slog.WarnContext(r.Context(), "Failed to analyze reading", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(audioQuery); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to encode audio query: %v", err))
+		return
+	}
+}
+
+// audioETag returns a strong ETag derived from the audio content.
+func audioETag(audio []byte) string {
+	sum := sha256.Sum256(audio)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// dryRunResult is returned by /synthesis in dry-run mode.
+type dryRunResult struct {
+	Valid  bool       `json:"valid"`
+	Format string     `json:"format"`
+	Query  AudioQuery `json:"query"`
+}
+
+// isDryRun reports whether the client only wants the query validated, with
+// validate=true or an X-Dry-Run: true header.
+func isDryRun(r *http.Request) bool {
+	return r.URL.Query().Get("validate") == "true" || strings.EqualFold(r.Header.Get("X-Dry-Run"), "true")
+}
+
+func (s *Server) handleSynthesis(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	if id, ok := s.voicevoxStyleID(r); ok {
+		s.handleVoicevoxSynthesis(w, r, id)
+		return
+	}
+
+	var query AudioQuery
+	if err := s.decodeJSONBody(w, r, &query); err != nil {
+		writeAPIError(w, bodyError(err))
+		return
+	}
+	if r.URL.Query().Get("markup") == "true" {
+		query.Markup = true
+	}
+	if r.URL.Query().Get("romaji") == "true" {
+		query.Romaji = true
+	}
+	if raw := r.URL.Query().Get("sample_rate"); raw != "" {
+		rate, err := parseSampleRateParam(raw)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		query.SampleRate = rate
+	}
+	if rawChannels, rawBitDepth := r.URL.Query().Get("channels"), r.URL.Query().Get("bit_depth"); rawChannels != "" || rawBitDepth != "" {
+		channels, bitDepth, err := parsePCMFormatParams(rawChannels, rawBitDepth)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		if channels != nil {
+			query.Channels = channels
+		}
+		if bitDepth != nil {
+			query.BitDepth = bitDepth
+		}
+	}
+	if raw := r.URL.Query().Get("volume"); raw != "" {
+		volume, err := parseOptionalIntParam(raw, VolumeMin, VolumeMax)
+		if err != nil {
+			writeAPIError(w, rangeError(errCodeInvalidVolume, "volume", Range{VolumeMin, VolumeMax}, ""))
+			return
+		}
+		query.Volume = volume
+	}
+
+	s.serveSynthesis(w, r, query)
+}
+
+// serveSynthesis validates query and writes its audio in the format the
+// client asked for.
+func (s *Server) serveSynthesis(w http.ResponseWriter, r *http.Request, query AudioQuery) {
+	noteQuery(r.Context(), query)
+	if err := s.validateSynthesisQuery(&query); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	format, err := requestedAudioFormat(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if err := s.checkTranscoder(format); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	if isDryRun(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(dryRunResult{Valid: true, Format: format, Query: query}); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to encode dry run result: %v", err))
+		}
+		return
+	}
+
+	wav, cached, err := s.synthesizeQuery(r.Context(), query)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	s.writeAudio(w, r, wav, cached, format)
+}
+
+// writeAudio writes wav to the client in format with the X-Audio-* headers,
+// honouring the meta and response options.
+func (s *Server) writeAudio(w http.ResponseWriter, r *http.Request, wav []byte, cached bool, format string) {
+	if s.memoryCache != nil || s.diskCache != nil {
+		if cached {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+	}
+
+	var sampleRate uint32
+	if wavFmt, pcm, err := decodeWAV(wav); err == nil {
+		sampleRate = wavFmt.SampleRate
+		w.Header().Set("X-Audio-Sample-Rate", strconv.Itoa(int(wavFmt.SampleRate)))
+		w.Header().Set("X-Audio-Channels", strconv.Itoa(int(wavFmt.Channels)))
+		w.Header().Set("X-Audio-Duration-Ms", strconv.FormatInt(wavFmt.duration(len(pcm)).Milliseconds(), 10))
+		if format == "wav" {
+			w.Header().Set("X-Audio-Bits-Per-Sample", strconv.Itoa(int(wavFmt.BitsPerSample)))
+		}
+	} else {
+		slog.WarnContext(r.Context(), "Failed to read WAV format", "error", err)
+	}
+
+	// In metadata mode only the headers are sent. With a cache enabled,
+	// fetching the audio afterwards does not run the engine again.
+	if r.URL.Query().Get("meta") == "true" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	audio := wav
+	if format != "wav" {
+		var err error
+		audio, err = s.transcodeCached(r.Context(), wav, format)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, errCodeTranscodeFailed, fmt.Sprintf("Failed to transcode audio: %v", err))
+			return
+		}
+	}
+
+	if wantsJSONAudio(r) {
+		if err := writeAudioJSON(w, audio, format, sampleRate); err != nil {
+			slog.WarnContext(r.Context(), "Failed to write JSON audio response", "error", err)
+		}
+		return
+	}
+
+	if r.URL.Query().Get("download") == "true" {
+		name := downloadFilename(r.URL.Query().Get("filename"), format)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+
+	// ServeContent sets Content-Length and handles Range requests. The ETag
+	// lets If-Range detect that a repeated synthesis produced other audio.
+	w.Header().Set("Content-Type", audioContentTypes[format])
+	w.Header().Set("ETag", audioETag(audio))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(audio))
+}
+
+// maxFilenameLength caps the length in runes of a download filename.
+const maxFilenameLength = 100
+
+// downloadFilename turns the filename requested by the client into a safe
+// attachment name with the extension of format. Everything up to the last
+// slash or backslash is dropped, as are control characters and quotes, and
+// "speech" is used when nothing is left.
+func downloadFilename(name, format string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > maxFilenameLength {
+		name = string(runes[:maxFilenameLength])
+	}
+	name = strings.Trim(name, " .")
+	if name == "" {
+		name = "speech"
+	}
+
+	ext := "." + format
+	if !strings.EqualFold(filepath.Ext(name), ext) {
+		name += ext
+	}
+	return name
+}
+
+func (s *Server) handleSpeakers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	var speakers any = s.speakers
+	if s.cfg.VoicevoxCompat {
+		speakers = s.voicevoxSpeakers()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(speakers); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to encode speakers: %v", err))
+		return
+	}
+}
+
+func (s *Server) handleEmotions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	emotions := engineEmotions
+	if name := r.URL.Query().Get("speaker"); name != "" {
+		name, err := s.resolveSpeaker(name)
+		if err == nil {
+			err = s.validateSpeaker(name)
+		}
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		emotions = s.speakerEmotions(name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(emotions); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to encode emotions: %v", err))
+		return
+	}
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status": "ok"}`))
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if s.warmingUp() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "unavailable",
+			"error":  "engine is warming up",
+		})
+		return
+	}
+	if err := s.engine.Check(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "unavailable",
+			"error":  err.Error(),
+		})
+		return
+	}
+	w.Write([]byte(`{"status": "ok"}`))
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(buildinfo.Get()); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to encode version: %v", err))
+		return
+	}
+}
+
+// handleSetting renders the settings page
+func (s *Server) handleSetting(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+
+		lang := requestLang(r)
+
+		current := s.settings.Get()
+		data := SettingsData{
+			CorsPolicyMode: current.CorsPolicyMode,
+			AllowOrigin:    current.AllowOrigin,
+			Lang:           lang,
+			CSRFToken:      s.csrfToken(s.csrfSession(w, r)),
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := settingsTemplate.Execute(w, data); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to render template: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// PlaygroundData is rendered into the playground page.
+type PlaygroundData struct {
+	Lang          string
+	SpeedMin      int
+	SpeedMax      int
+	PitchMin      int
+	PitchMax      int
+	Speed         int
+	Pitch         int
+	RequireAPIKey bool
+}
+
+// handlePlayground renders a page for trying synthesis from the browser
+func (s *Server) handlePlayground(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	// The sliders start at the server defaults, or at vpeak's own defaults
+	// when none are configured
+	data := PlaygroundData{
+		Lang:          requestLang(r),
+		SpeedMin:      SpeedMin,
+		SpeedMax:      SpeedMax,
+		PitchMin:      PitchMin,
+		PitchMax:      PitchMax,
+		Speed:         100,
+		RequireAPIKey: len(s.cfg.APIKeys) > 0,
+	}
+	if s.cfg.DefaultSpeed != nil {
+		data.Speed = *s.cfg.DefaultSpeed
+	}
+	if s.cfg.DefaultPitch != nil {
+		data.Pitch = *s.cfg.DefaultPitch
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := playgroundTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// langCookieMaxAge keeps the language choice for a year.
+const langCookieMaxAge = 365 * 24 * 60 * 60
+
+// handleSetLang stores the page language in the lang cookie so the pages are
+// rendered in it on the next load
+func (s *Server) handleSetLang(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var body struct {
+		Lang string `json:"lang"`
+	}
+	if err := s.decodeJSONBody(w, r, &body); err != nil {
+		writeAPIError(w, bodyError(err))
+		return
+	}
+	if normalizeLang(body.Lang) != body.Lang {
+		writeJSONError(w, http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("Unsupported language: %s", body.Lang))
+		return
+	}
+
+	// The page scripts do not read the cookie, but it is left readable so
+	// it mirrors the localStorage value
+	http.SetCookie(w, &http.Cookie{
+		Name:     "lang",
+		Value:    body.Lang,
+		Path:     "/",
+		MaxAge:   langCookieMaxAge,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status": "success"}`))
+}
+
+// handleSettings returns the current settings as JSON, in the shape
+// /update-settings accepts.
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(s.settings.Get()); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to encode settings: %v", err))
+		return
+	}
+}
+
+// handleUpdateSettings applies and persists the settings sent by the settings page
+func (s *Server) handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var settings struct {
+		SettingsData
+		Confirm bool `json:"confirm"`
+	}
+	if err := s.decodeJSONBody(w, r, &settings); err != nil {
+		writeAPIError(w, bodyError(err))
+		return
+	}
+
+	updated := PersistedSettings{
+		CorsPolicyMode: settings.CorsPolicyMode,
+		AllowOrigin:    settings.AllowOrigin,
+	}
+	// Any other mode would silently turn the CORS headers off
+	if updated.CorsPolicyMode != "localapps" && updated.CorsPolicyMode != "all" {
+		writeLocalizedError(w, http.StatusBadRequest, errCodeInvalidCorsPolicy, msgInvalidCorsPolicy, updated.CorsPolicyMode)
+		return
+	}
+
+	// The check and the save happen under the settings lock, so concurrent
+	// updates cannot both see the previous mode
+	previous, err := s.settings.Swap(updated, func(previous PersistedSettings) error {
+		// Allowing every origin exposes the API to any site the user
+		// visits, so it has to be asked for explicitly
+		if updated.CorsPolicyMode == "all" && previous.CorsPolicyMode != "all" && !settings.Confirm {
+			return newLocalizedError(http.StatusBadRequest, errCodeConfirmationRequired, msgConfirmCorsAll)
+		}
+		if s.cfg.ConfigPath != "" {
+			if err := saveSettings(s.cfg.ConfigPath, updated); err != nil {
+				return newAPIError(http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to save settings: %v", err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if updated.CorsPolicyMode == "all" && previous.CorsPolicyMode != "all" {
+		slog.WarnContext(r.Context(), "CORS policy switched to all, requests from any origin are now allowed", "previous", previous.CorsPolicyMode)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status": "success"}`))
+}
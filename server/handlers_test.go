@@ -0,0 +1,323 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+	engine.setCheckErr(errEngineUnavailable)
+
+	// Liveness does not depend on the engine
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["status"] != "ok" {
+		t.Errorf("status field = %q, want ok", body["status"])
+	}
+}
+
+func TestReady(t *testing.T) {
+	s, engine := newTestServer(t, Config{CorsPolicyMode: "all"})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	engine.setCheckErr(errors.New("voicepeak not available"))
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["status"] != "unavailable" || body["error"] != "voicepeak not available" {
+		t.Errorf("body = %v, want status unavailable and the engine error", body)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+	}
+}
+
+// decodeAudioQuery decodes the query returned by /audio_query.
+func decodeAudioQuery(t *testing.T, rec *httptest.ResponseRecorder) AudioQuery {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var query AudioQuery
+	if err := json.NewDecoder(rec.Body).Decode(&query); err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
+
+func TestAudioQuerySources(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	t.Run("query only", func(t *testing.T) {
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			got := decodeAudioQuery(t, serve(s, httptest.NewRequest(method, "/audio_query?text=%E3%81%82&speaker=f1&speed=120", nil)))
+			if got.Text != "あ" || got.Speaker != "f1" || got.Speed == nil || *got.Speed != 120 {
+				t.Errorf("%s: query = %+v, want text あ, speaker f1 and speed 120", method, got)
+			}
+		}
+	})
+
+	t.Run("body only", func(t *testing.T) {
+		long := strings.Repeat("あ", 5000)
+		got := decodeAudioQuery(t, serve(s, jsonRequest(t, http.MethodPost, "/audio_query", AudioQuery{Text: long, Speaker: "m1", Pitch: intPtr(-50)})))
+		if got.Text != long || got.Speaker != "m1" || got.Pitch == nil || *got.Pitch != -50 {
+			t.Errorf("query = %+v, want the body fields", got)
+		}
+	})
+
+	t.Run("body wins", func(t *testing.T) {
+		r := jsonRequest(t, http.MethodPost, "/audio_query?text=query&speaker=f1&speed=80", AudioQuery{Text: "ボディ", Speed: intPtr(150)})
+		got := decodeAudioQuery(t, serve(s, r))
+		if got.Text != "ボディ" || got.Speed == nil || *got.Speed != 150 {
+			t.Errorf("query = %+v, want text and speed from the body", got)
+		}
+		if got.Speaker != "f1" {
+			t.Errorf("speaker = %q, want f1 from the query string", got.Speaker)
+		}
+	})
+
+	t.Run("validated", func(t *testing.T) {
+		r := jsonRequest(t, http.MethodPost, "/audio_query?text=a&speaker=f1", AudioQuery{Speed: intPtr(500)})
+		wantError(t, serve(s, r), http.StatusBadRequest, errCodeInvalidSpeed)
+
+		got := decodeAudioQuery(t, serve(s, jsonRequest(t, http.MethodPost, "/audio_query", AudioQuery{Text: "a", Speaker: "f1", Emotion: "bored"})))
+		if got.Emotion != "" {
+			t.Errorf("emotion = %q, want unsupported emotions blanked", got.Emotion)
+		}
+	})
+}
+
+func TestVersion(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not a JSON object of strings: %v", err)
+	}
+	for _, field := range []string{"version", "commit", "go_version", "vpeak_version"} {
+		if body[field] == "" {
+			t.Errorf("%s is missing from %s", field, rec.Body)
+		}
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodPost, "/version", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestPageLanguageFromCookie(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	for cookie, want := range map[string]string{
+		"lang=en":      "en",
+		"lang=ja":      "ja",
+		"lang=fr":      "ja",
+		"lang=en-US":   "ja",
+		"lang=":        "ja",
+		"":             "ja",
+		"lang=%3Cb%3E": "ja",
+	} {
+		for _, target := range []string{"/", "/setting"} {
+			r := httptest.NewRequest(http.MethodGet, target, nil)
+			if cookie != "" {
+				r.Header.Set("Cookie", cookie)
+			}
+			rec := serve(s, r)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s with %q: status = %d, want %d", target, cookie, rec.Code, http.StatusOK)
+			}
+			if body := rec.Body.String(); !strings.Contains(body, `data-lang="`+want+`"`) {
+				t.Errorf("%s with %q: page is not rendered in %s", target, cookie, want)
+			}
+		}
+	}
+}
+
+func TestSetLang(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/set-lang", map[string]string{"lang": "en"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "lang" || cookies[0].Value != "en" || cookies[0].Path != "/" || cookies[0].MaxAge <= 0 {
+		t.Fatalf("cookies = %v, want a persistent lang=en cookie", cookies)
+	}
+
+	// The next page is rendered in the saved language
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	if body := serve(s, r).Body.String(); !strings.Contains(body, `data-lang="en"`) {
+		t.Error("page is not rendered in the language of the cookie")
+	}
+
+	rec = serve(s, jsonRequest(t, http.MethodPost, "/set-lang", map[string]string{"lang": "fr"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidBody)
+	if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("cookies = %v for an unsupported language, want none", cookies)
+	}
+}
+
+func TestSynthesisRange(t *testing.T) {
+	s, _ := newTestServer(t, Config{CacheSize: 10})
+	want := fakeWAV("こんにちは")
+
+	// The second request is answered from the cache
+	for i, cache := range []string{"MISS", "HIT"} {
+		r := jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"})
+		r.Header.Set("Range", "bytes=0-99")
+		rec := serve(s, r)
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("response %d: status = %d, want %d", i, rec.Code, http.StatusPartialContent)
+		}
+		if got := rec.Header().Get("X-Cache"); got != cache {
+			t.Errorf("response %d: X-Cache = %q, want %q", i, got, cache)
+		}
+		if got, wantRange := rec.Header().Get("Content-Range"), fmt.Sprintf("bytes 0-99/%d", len(want)); got != wantRange {
+			t.Errorf("response %d: Content-Range = %q, want %q", i, got, wantRange)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), want[:100]) {
+			t.Errorf("response %d: body is not the first 100 bytes of the audio", i)
+		}
+	}
+}
+
+func TestDownloadFilename(t *testing.T) {
+	tests := []struct {
+		name, format, want string
+	}{
+		{"", "wav", "speech.wav"},
+		{"greeting", "wav", "greeting.wav"},
+		{"greeting.WAV", "wav", "greeting.WAV"},
+		{"greeting.wav", "mp3", "greeting.wav.mp3"},
+		{"../../etc/passwd", "wav", "passwd.wav"},
+		{`C:\Windows\evil.bat`, "wav", "evil.bat.wav"},
+		{"a\"b\r\nSet-Cookie: x=1", "wav", "abSet-Cookie: x=1.wav"},
+		{"..", "wav", "speech.wav"},
+		{" . ", "ogg", "speech.ogg"},
+		{"こんにちは", "wav", "こんにちは.wav"},
+		{strings.Repeat("あ", 150), "wav", strings.Repeat("あ", maxFilenameLength) + ".wav"},
+	}
+	for _, tt := range tests {
+		if got := downloadFilename(tt.name, tt.format); got != tt.want {
+			t.Errorf("downloadFilename(%q, %q) = %q, want %q", tt.name, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestSynthesisDownload(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	query := AudioQuery{Text: "こんにちは", Speaker: "f1"}
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query))
+	if got := rec.Header().Get("Content-Disposition"); got != "" {
+		t.Errorf("inline response has Content-Disposition %q", got)
+	}
+
+	for target, want := range map[string]string{
+		"/synthesis?download=true":                                `attachment; filename=speech.wav`,
+		"/synthesis?download=true&filename=hello":                 `attachment; filename=hello.wav`,
+		"/synthesis?download=true&filename=..%2F..%2Fa%22%0D%0Ab": `attachment; filename=ab.wav`,
+	} {
+		rec := serve(s, jsonRequest(t, http.MethodPost, target, query))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d; body: %s", target, rec.Code, http.StatusOK, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != want {
+			t.Errorf("%s: Content-Disposition = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestOversizedBody(t *testing.T) {
+	const limit = 256
+	s, engine := newTestServer(t, Config{MaxRequestBytes: limit})
+	big := AudioQuery{Text: strings.Repeat("あ", limit), Speaker: "f1"}
+
+	for _, r := range []*http.Request{
+		jsonRequest(t, http.MethodPost, "/synthesis", big),
+		jsonRequest(t, http.MethodPost, "/audio_query", big),
+		jsonRequest(t, http.MethodPost, "/jobs", big),
+		jsonRequest(t, http.MethodPost, "/synthesis_batch", []AudioQuery{big}),
+		settingsRequest(t, s, map[string]any{"corsPolicyMode": "localapps", "allowOrigin": strings.Repeat("a", limit)}),
+	} {
+		wantError(t, serve(s, r), http.StatusRequestEntityTooLarge, errCodeRequestTooLarge)
+	}
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times for oversized bodies", len(calls))
+	}
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "あ", Speaker: "f1"}))
+	if rec.Code != http.StatusOK {
+		t.Errorf("small body: status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	s, _ := newTestServer(t, Config{CacheSize: 10, FFmpegPath: useFakeFFmpeg(t)})
+	ts := httptest.NewServer(s.Routes())
+	defer ts.Close()
+	query := `{"text": "こんにちは", "speaker": "f1"}`
+
+	tests := []struct {
+		name, target, body, cache string
+	}{
+		{"synthesized", "/synthesis", query, "MISS"},
+		{"cached", "/synthesis", query, "HIT"},
+		{"json", "/synthesis?response=json", query, "HIT"},
+		{"transcoded", "/synthesis?format=mp3", query, "HIT"},
+		{"batch", "/synthesis_batch", `[` + query + `]`, ""},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(ts.URL+tt.target, "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d; body: %s", tt.name, resp.StatusCode, http.StatusOK, data)
+		}
+		if got := resp.Header.Get("X-Cache"); got != tt.cache {
+			t.Errorf("%s: X-Cache = %q, want %q", tt.name, got, tt.cache)
+		}
+		if resp.ContentLength != int64(len(data)) || len(resp.TransferEncoding) != 0 {
+			t.Errorf("%s: Content-Length = %d, transfer encoding %q, want %d bytes sent as is",
+				tt.name, resp.ContentLength, resp.TransferEncoding, len(data))
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// endpointInfo describes an endpoint in the JSON index served at /.
+type endpointInfo struct {
+	Path        string   `json:"path"`
+	Methods     []string `json:"methods"`
+	Description string   `json:"description"`
+}
+
+// apiEndpoints lists the endpoints shown in the JSON index.
+var apiEndpoints = []endpointInfo{
+	{"/audio_query", []string{"GET", "POST"}, "Validate a query and return it normalized"},
+	{"/synthesis", []string{"POST"}, "Synthesize speech from a JSON query"},
+	{"/synthesis_file", []string{"POST"}, "Synthesize the text of an uploaded file"},
+	{"/synthesis_stream", []string{"GET"}, "Stream audio sentence by sentence as Server-Sent Events"},
+	{"/ws/synthesis", []string{"GET"}, "Synthesize queries over a WebSocket"},
+	{"/synthesis_batch", []string{"POST"}, "Synthesize several queries into a ZIP archive"},
+	{"/synthesis_dialogue", []string{"POST"}, "Synthesize several lines into one audio file"},
+	{"/jobs", []string{"POST"}, "Submit a synthesis to run in the background"},
+	{"/jobs/{id}", []string{"GET"}, "Get the status of a job"},
+	{"/jobs/{id}/audio", []string{"GET"}, "Download the audio of a finished job"},
+	{"/speakers", []string{"GET"}, "List the available speakers"},
+	{"/speakers/{name}/sample", []string{"GET"}, "Listen to a sample of a speaker"},
+	{"/emotions", []string{"GET"}, "List the supported emotions"},
+	{"/presets", []string{"GET"}, "List the voice presets"},
+	{"/health", []string{"GET"}, "Liveness probe"},
+	{"/ready", []string{"GET"}, "Readiness probe"},
+	{"/version", []string{"GET"}, "Server and library versions"},
+	{"/openapi.json", []string{"GET"}, "OpenAPI description of the API"},
+	{"/docs", []string{"GET"}, "Browsable API documentation"},
+	{"/playground", []string{"GET"}, "Web page for trying synthesis"},
+	{"/settings", []string{"GET"}, "Current CORS settings"},
+}
+
+// prefersJSON reports whether the Accept header ranks application/json
+// above text/html. Ties go to HTML, which browsers ask for.
+func prefersJSON(r *http.Request) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch mediaType {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// writeIndexJSON writes the endpoint list served at / to API clients.
+func (s *Server) writeIndexJSON(w http.ResponseWriter) {
+	endpoints := apiEndpoints
+	if s.metrics != nil {
+		endpoints = append(endpoints[:len(endpoints):len(endpoints)], endpointInfo{"/metrics", []string{"GET"}, "Prometheus metrics"})
+	}
+	if s.cfg.EnableAdmin {
+		endpoints = append(endpoints[:len(endpoints):len(endpoints)], endpointInfo{"/admin/shutdown", []string{"POST"}, "Stop the server gracefully"})
+	}
+	if s.recent != nil {
+		endpoints = append(endpoints[:len(endpoints):len(endpoints)], endpointInfo{"/admin/recent", []string{"GET"}, "Recently served requests"})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"endpoints": endpoints}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+	}
+}
@@ -0,0 +1,67 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIndexNegotiation(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	tests := []struct {
+		accept   string
+		wantJSON bool
+	}{
+		{"", false},
+		{"text/html", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"application/json", true},
+		{"application/json, text/html;q=0.5", true},
+		{"text/html, application/json", false},
+		{"application/json;q=0", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		rec := serve(s, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Accept %q: status = %d, want %d", tt.accept, rec.Code, http.StatusOK)
+		}
+		if got := rec.Header().Get("Vary"); !strings.Contains(got, "Accept") {
+			t.Errorf("Accept %q: Vary = %q, want it to name Accept", tt.accept, got)
+		}
+
+		contentType := rec.Header().Get("Content-Type")
+		if !tt.wantJSON {
+			if !strings.HasPrefix(contentType, "text/html") {
+				t.Errorf("Accept %q: Content-Type = %q, want the HTML page", tt.accept, contentType)
+			}
+			continue
+		}
+		if contentType != "application/json" {
+			t.Fatalf("Accept %q: Content-Type = %q, want application/json", tt.accept, contentType)
+		}
+		var index struct {
+			Endpoints []endpointInfo `json:"endpoints"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&index); err != nil {
+			t.Fatal(err)
+		}
+		paths := map[string]bool{}
+		for _, e := range index.Endpoints {
+			paths[e.Path] = true
+		}
+		for _, path := range []string{"/audio_query", "/synthesis", "/speakers"} {
+			if !paths[path] {
+				t.Errorf("Accept %q: index does not list %s", tt.accept, path)
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job states reported by GET /jobs/{id}.
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobError   = "error"
+)
+
+// job is a synthesis submitted to /jobs and run in the background.
+type job struct {
+	id    string
+	query AudioQuery
+
+	// The fields below are guarded by jobStore.mu
+	status   string
+	err      error
+	finished time.Time
+}
+
+// jobStore keeps submitted jobs in memory until TTL after they finish. The
+// audio of finished jobs is kept in storage rather than in memory.
+type jobStore struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	queue   chan *job
+	ttl     time.Duration
+	storage Storage
+}
+
+func newJobStore(queueSize int, ttl time.Duration, storage Storage) *jobStore {
+	return &jobStore{
+		jobs:    map[string]*job{},
+		queue:   make(chan *job, queueSize),
+		ttl:     ttl,
+		storage: storage,
+	}
+}
+
+// storageKey returns the key of the audio of the job with id.
+func (js *jobStore) storageKey(id string) string {
+	return id + ".wav"
+}
+
+// submit queues query and returns the new job, or false when the queue is
+// full.
+func (js *jobStore) submit(query AudioQuery) (*job, bool) {
+	j := &job{id: uuid.New().String(), query: query, status: jobPending}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	select {
+	case js.queue <- j:
+	default:
+		return nil, false
+	}
+	js.jobs[j.id] = j
+	return j, true
+}
+
+// jobState is a snapshot of a job taken under jobStore.mu.
+type jobState struct {
+	status string
+	err    error
+}
+
+// get returns the state of the job with id.
+func (js *jobStore) get(id string) (jobState, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.jobs[id]
+	if !ok {
+		return jobState{}, false
+	}
+	return jobState{j.status, j.err}, true
+}
+
+func (js *jobStore) setStatus(j *job, status string) {
+	js.mu.Lock()
+	j.status = status
+	js.mu.Unlock()
+}
+
+// finish stores the audio of j and records its result. A job whose audio
+// cannot be stored fails.
+func (js *jobStore) finish(j *job, wav []byte, err error) {
+	if err == nil {
+		if putErr := js.storage.Put(js.storageKey(j.id), wav); putErr != nil {
+			err = fmt.Errorf("Failed to store job audio: %v", putErr)
+		}
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j.err, j.finished = err, time.Now()
+	if err != nil {
+		j.status = jobError
+	} else {
+		j.status = jobDone
+	}
+}
+
+// expire removes jobs that finished more than TTL ago, with their audio.
+func (js *jobStore) expire() {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for id, j := range js.jobs {
+		if !j.finished.IsZero() && time.Since(j.finished) > js.ttl {
+			js.remove(id)
+		}
+	}
+}
+
+// removeAll removes every job and its audio. It is called on Close.
+func (js *jobStore) removeAll() {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for id := range js.jobs {
+		js.remove(id)
+	}
+}
+
+// remove deletes the job with id. The caller must hold js.mu.
+func (js *jobStore) remove(id string) {
+	delete(js.jobs, id)
+	if err := js.storage.Delete(js.storageKey(id)); err != nil {
+		slog.Warn("Failed to remove job audio", "job_id", id, "error", err)
+	}
+}
+
+// cleanup expires finished jobs until done is closed.
+func (js *jobStore) cleanup(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		js.expire()
+	}
+}
+
+// jobWorkers returns how many jobs run concurrently: one per synthesis slot,
+// or 4 when the slots are unlimited.
+func jobWorkers(maxConcurrent int) int {
+	if maxConcurrent > 0 {
+		return maxConcurrent
+	}
+	return 4
+}
+
+// runJobs synthesizes queued jobs until done is closed. Workers wait for a
+// synthesis slot instead of failing when the server is busy, so the slots
+// bound how many jobs run at once.
+func (s *Server) runJobs(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-s.jobs.queue:
+			s.jobs.setStatus(j, jobRunning)
+			wav, _, err := s.synthesizeQuery(withSlotWait(ctx), j.query)
+			s.jobs.finish(j, wav, err)
+		}
+	}
+}
+
+// jobResponse is the JSON describing a job.
+type jobResponse struct {
+	JobID  string     `json:"job_id"`
+	Status string     `json:"status"`
+	Error  *errorBody `json:"error,omitempty"`
+}
+
+// handleJobs accepts POST /jobs with an AudioQuery and queues it.
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var query AudioQuery
+	if err := s.decodeJSONBody(w, r, &query); err != nil {
+		writeAPIError(w, bodyError(err))
+		return
+	}
+	noteQuery(r.Context(), query)
+	if err := s.validateSynthesisQuery(&query); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	j, ok := s.jobs.submit(query)
+	if !ok {
+		writeJSONError(w, http.StatusServiceUnavailable, errCodeServerBusy, "job queue is full, please retry later")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+j.id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(jobResponse{JobID: j.id, Status: jobPending})
+}
+
+// handleJob serves GET /jobs/{id} and GET /jobs/{id}/audio.
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	if rest != "" && rest != "audio" {
+		http.NotFound(w, r)
+		return
+	}
+
+	state, ok := s.jobs.get(id)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errCodeJobNotFound, "Job not found; it may have expired")
+		return
+	}
+
+	if rest == "" {
+		resp := jobResponse{JobID: id, Status: state.status}
+		if state.err != nil {
+			body := errorBodyOf(state.err, responseLang(w))
+			resp.Error = &body
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	switch state.status {
+	case jobPending, jobRunning:
+		writeJSONError(w, http.StatusConflict, errCodeJobNotReady, fmt.Sprintf("Job is %s", state.status))
+		return
+	case jobError:
+		writeAPIError(w, state.err)
+		return
+	}
+
+	format, err := requestedAudioFormat(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if err := s.checkTranscoder(format); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	wav, err := readStorage(s.jobs.storage, s.jobs.storageKey(id))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to read job audio: %v", err))
+		return
+	}
+	s.writeAudio(w, r, wav, false, format)
+}
@@ -0,0 +1,99 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// submitJob posts query to /jobs and returns the new job.
+func submitJob(t *testing.T, s *Server, query AudioQuery) jobResponse {
+	t.Helper()
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/jobs", query))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	var resp jobResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.JobID == "" || resp.Status != jobPending {
+		t.Fatalf("job = %+v, want a pending job", resp)
+	}
+	if got, want := rec.Header().Get("Location"), "/jobs/"+resp.JobID; got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+	return resp
+}
+
+// pollJob polls the job with id until it finishes and returns its status.
+func pollJob(t *testing.T, s *Server, id string) jobResponse {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := serve(s, httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		var resp jobResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status == jobDone || resp.Status == jobError {
+			return resp
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job is still %s", resp.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobSubmitPollFetch(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	j := submitJob(t, s, AudioQuery{Text: "こんにちは", Speaker: "f1"})
+	if resp := pollJob(t, s, j.JobID); resp.Status != jobDone {
+		t.Fatalf("job = %+v, want done", resp)
+	}
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/jobs/"+j.JobID+"/audio", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), fakeWAV("こんにちは")) {
+		t.Error("job audio is not the WAV written by the engine")
+	}
+}
+
+func TestJobAudioBeforeDone(t *testing.T) {
+	engine, started, release := blockingEngine()
+	s, _ := newTestServer(t, Config{Engine: engine})
+
+	j := submitJob(t, s, AudioQuery{Text: "こんにちは", Speaker: "f1"})
+	<-started
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/jobs/"+j.JobID+"/audio", nil))
+	wantError(t, rec, http.StatusConflict, errCodeJobNotReady)
+
+	close(release)
+	if resp := pollJob(t, s, j.JobID); resp.Status != jobDone {
+		t.Fatalf("job = %+v, want done", resp)
+	}
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/jobs/"+j.JobID+"/audio", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status after the job finished = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestUnknownJob(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	for _, target := range []string{"/jobs/missing", "/jobs/missing/audio"} {
+		wantError(t, serve(s, httptest.NewRequest(http.MethodGet, target, nil)), http.StatusNotFound, errCodeJobNotFound)
+	}
+}
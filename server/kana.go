@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// kanaTimeout bounds a single run of the reading analysis command.
+const kanaTimeout = 10 * time.Second
+
+// analyzeKana returns the reading of text as printed by KanaCommand. vpeak
+// does not expose the readings VOICEPEAK uses, so this relies on an
+// external analyzer such as MeCab; its reading may differ from the
+// engine's. An empty string is returned when no command is configured.
+func (s *Server) analyzeKana(ctx context.Context, text string) (string, error) {
+	args := strings.Fields(s.cfg.KanaCommand)
+	if len(args) == 0 {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, kanaTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text + "\n")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("kana command failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// Analyzers end each input line with a line break, so whitespace is
+	// collapsed to return the reading as a single line
+	return strings.Join(strings.Fields(stdout.String()), " "), nil
+}
+
+// validateKana rejects readings that contain anything other than kana,
+// the long vowel mark (full or half width), whitespace and punctuation.
+func validateKana(kana string) error {
+	for _, r := range kana {
+		if unicode.In(r, unicode.Hiragana, unicode.Katakana) || r == 'ー' || r == 'ｰ' || unicode.IsSpace(r) || unicode.IsPunct(r) {
+			continue
+		}
+		return newAPIError(http.StatusBadRequest, errCodeInvalidKana, fmt.Sprintf("Invalid kana: %q is not a kana character", r))
+	}
+	return nil
+}
+
+// spokenText returns the text the engine should read for query. vpeak has
+// no way to pass a reading alongside the text, so a kana override replaces
+// the text; kana is read as written, which fixes the reading.
+func spokenText(query AudioQuery) string {
+	if query.Kana != "" {
+		return query.Kana
+	}
+	return query.Text
+}
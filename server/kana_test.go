@@ -0,0 +1,110 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runFakeKana reads text from stdin like the command run by analyzeKana and
+// prints it with hiragana turned into katakana, its "reading".
+func runFakeKana() int {
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return 1
+	}
+	fmt.Print(strings.Map(func(r rune) rune {
+		if r >= 'ぁ' && r <= 'ゖ' {
+			return r + 'ァ' - 'ぁ'
+		}
+		return r
+	}, string(input)))
+	return 0
+}
+
+// useFakeKana makes the test binary stand in for the kana command and
+// returns its path, for Config.KanaCommand.
+func useFakeKana(t *testing.T) string {
+	t.Helper()
+	t.Setenv(fakeKanaEnv, "1")
+	path, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// audioQueryKana returns the kana field of the /audio_query response for
+// query.
+func audioQueryKana(t *testing.T, s *Server, query AudioQuery) (string, bool) {
+	t.Helper()
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/audio_query", query))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	kana, ok := body["kana"].(string)
+	return kana, ok
+}
+
+func TestAudioQueryKanaPreview(t *testing.T) {
+	s, _ := newTestServer(t, Config{KanaCommand: useFakeKana(t)})
+
+	kana, ok := audioQueryKana(t, s, AudioQuery{Text: "こんにちは\n", Speaker: "f1"})
+	if !ok || kana != "コンニチハ" {
+		t.Errorf("kana = %q, want the reading printed by the command", kana)
+	}
+}
+
+func TestAudioQueryWithoutKanaPreview(t *testing.T) {
+	for name, command := range map[string]string{
+		"no command":     "",
+		"failed command": filepath.Join(t.TempDir(), "missing-analyzer"),
+	} {
+		s, _ := newTestServer(t, Config{KanaCommand: command})
+		if kana, ok := audioQueryKana(t, s, AudioQuery{Text: "こんにちは", Speaker: "f1"}); ok {
+			t.Errorf("%s: kana = %q, want the field left out", name, kana)
+		}
+	}
+}
+
+func TestValidateKana(t *testing.T) {
+	for _, kana := range []string{"こんにちは", "コンニチハ", "ｺﾝﾆﾁﾊ", "ラーメン、たべたい！", "きょう は はれ"} {
+		if err := validateKana(kana); err != nil {
+			t.Errorf("validateKana(%q): %v", kana, err)
+		}
+	}
+	for _, kana := range []string{"今日", "konnichiha", "こんにちは1"} {
+		if err := validateKana(kana); err == nil {
+			t.Errorf("validateKana(%q) succeeded, want an error", kana)
+		}
+	}
+}
+
+func TestSynthesisKanaOverride(t *testing.T) {
+	s, engine := newTestServer(t, Config{DictionaryFile: writeDictionary(t, "はし\t橋\n")})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "箸", Kana: "はし", Speaker: "f1"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	// The reading is synthesized as written, without dictionary replacements
+	if calls := engine.Calls(); len(calls) != 1 || calls[0].Text != "はし" {
+		t.Errorf("engine calls = %+v, want the kana read instead of the text", calls)
+	}
+
+	rec = serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "箸", Kana: "箸", Speaker: "f1"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidKana)
+	rec = serve(s, jsonRequest(t, http.MethodPost, "/audio_query", AudioQuery{Text: "箸", Kana: "hashi", Speaker: "f1"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidKana)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// autoNarratorLangs are the languages detectLanguage can report.
+var autoNarratorLangs = []string{"ja", "en"}
+
+// detectLanguage guesses the language of text from its scripts: any kana
+// makes it Japanese, and Latin letters without kana or kanji make it
+// English. Text of kanji alone could as well be Chinese, so it is reported
+// as undetected like text in other scripts or without letters; ok is false
+// then.
+func detectLanguage(text string) (lang string, ok bool) {
+	var kana, han, latin, other int
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.IsLetter(r):
+			other++
+		}
+	}
+
+	switch {
+	case kana > 0:
+		return "ja", true
+	case latin > 0 && han == 0 && other == 0:
+		return "en", true
+	}
+	return "", false
+}
+
+// validateAutoNarrators checks the language to narrator mapping of
+// AutoNarrators.
+func (s *Server) validateAutoNarrators() error {
+	for lang, name := range s.cfg.AutoNarrators {
+		if !slices.Contains(autoNarratorLangs, lang) {
+			return fmt.Errorf("invalid auto narrator language %q: must be one of %s", lang, strings.Join(autoNarratorLangs, ", "))
+		}
+		if err := s.validateSpeaker(name); err != nil {
+			return fmt.Errorf("invalid auto narrator for %s: unknown speaker %s", lang, name)
+		}
+	}
+	return nil
+}
+
+// autoNarrator fills in the speaker of a query without one from the
+// detected language of its text, when AutoNarrators is set. A 400 error
+// asks for a speaker when the language cannot be detected or has no
+// narrator.
+func (s *Server) autoNarrator(query *AudioQuery) error {
+	if query.Speaker != "" || len(s.cfg.AutoNarrators) == 0 {
+		return nil
+	}
+
+	text := query.Text
+	if query.Kana != "" {
+		text = query.Kana
+	} else if query.Markup {
+		// Tag names would count as English otherwise
+		if segments, err := parseMarkup(query.Text); err == nil {
+			var sb strings.Builder
+			for _, seg := range segments {
+				sb.WriteString(seg.Text)
+			}
+			text = sb.String()
+		}
+	}
+
+	lang, ok := detectLanguage(text)
+	if !ok {
+		return newAPIError(http.StatusBadRequest, errCodeLanguageUndetected, "Could not detect the language of the text; specify a speaker")
+	}
+	name, ok := s.cfg.AutoNarrators[lang]
+	if !ok {
+		return newAPIError(http.StatusBadRequest, errCodeLanguageUndetected, fmt.Sprintf("No narrator is configured for %s text; specify a speaker", lang))
+	}
+	query.Speaker = name
+	return nil
+}
@@ -0,0 +1,80 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text, lang string
+		ok         bool
+	}{
+		{"こんにちは", "ja", true},
+		{"今日はいい天気", "ja", true},
+		{"VOICEPEAKで読む", "ja", true},
+		{"Hello, world", "en", true},
+		{"今日天気很好", "", false},
+		{"Hello 世界", "", false},
+		{"Привет", "", false},
+		{"123 !?", "", false},
+	}
+	for _, tt := range tests {
+		lang, ok := detectLanguage(tt.text)
+		if lang != tt.lang || ok != tt.ok {
+			t.Errorf("detectLanguage(%q) = %q, %v, want %q, %v", tt.text, lang, ok, tt.lang, tt.ok)
+		}
+	}
+}
+
+func TestAutoNarrator(t *testing.T) {
+	s, engine := newTestServer(t, Config{AutoNarrators: map[string]string{"ja": "f2", "en": "m1"}})
+
+	for text, want := range map[string]string{
+		"こんにちは":        "f2",
+		"Good morning": "m1",
+	} {
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: text}))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, want %d; body: %s", text, rec.Code, http.StatusOK, rec.Body)
+		}
+		calls := engine.Calls()
+		if got := calls[len(calls)-1].Opts.Narrator; got != want {
+			t.Errorf("%q: narrator = %q, want %q", text, got, want)
+		}
+	}
+
+	// A speaker in the query wins
+	if rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "Hello", Speaker: "f1"})); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	calls := engine.Calls()
+	if got := calls[len(calls)-1].Opts.Narrator; got != "f1" {
+		t.Errorf("narrator = %q, want the requested f1", got)
+	}
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "今日天気很好"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeLanguageUndetected)
+}
+
+func TestAutoNarratorWithoutNarratorForLanguage(t *testing.T) {
+	s, _ := newTestServer(t, Config{AutoNarrators: map[string]string{"ja": "f2"}})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "Hello"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeLanguageUndetected)
+}
+
+func TestNewRejectsInvalidAutoNarrators(t *testing.T) {
+	for _, narrators := range []map[string]string{
+		{"fr": "f1"},
+		{"ja": "nobody"},
+	} {
+		cfg := Config{Engine: &fakeEngine{}, TmpDir: t.TempDir(), AutoNarrators: narrators}
+		if s, err := New(cfg); err == nil {
+			s.Close()
+			t.Errorf("New accepted the auto narrators %v", narrators)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultMessageLang is used for error messages when the client states no
+// supported language, matching the default of the web pages.
+const defaultMessageLang = "ja"
+
+// Keys of the localized error messages.
+const (
+	msgMethodNotAllowed      = "method_not_allowed"
+	msgMethodsNotAllowed     = "methods_not_allowed"
+	msgInvalidBody           = "invalid_body"
+	msgRequestTooLarge       = "request_too_large"
+	msgMissingText           = "missing_text"
+	msgMissingTextAndSpeaker = "missing_text_and_speaker"
+	msgEmptyText             = "empty_text"
+	msgOutOfRange            = "out_of_range"
+	msgSpeakerOutOfRange     = "speaker_out_of_range"
+	msgUnknownSpeaker        = "unknown_speaker"
+	msgTextTooLong           = "text_too_long"
+	msgUnauthorized          = "unauthorized"
+	msgRateLimited           = "rate_limited"
+	msgServerBusy            = "server_busy"
+	msgInvalidCorsPolicy     = "invalid_cors_policy"
+	msgConfirmCorsAll        = "confirm_cors_all"
+)
+
+// messageCatalog holds the format strings of the localized error messages by
+// key and language. The English ones are also used for logs and Error().
+var messageCatalog = map[string]map[string]string{
+	msgMethodNotAllowed: {
+		"en": "Only %s method is allowed",
+		"ja": "%s メソッドのみ使用できます",
+	},
+	msgMethodsNotAllowed: {
+		"en": "Only %s and %s methods are allowed",
+		"ja": "%s と %s メソッドのみ使用できます",
+	},
+	msgInvalidBody: {
+		"en": "Failed to decode request body: %v",
+		"ja": "リクエストボディを解析できません: %v",
+	},
+	msgRequestTooLarge: {
+		"en": "Request body must be at most %d bytes",
+		"ja": "リクエストボディは %d バイト以内にしてください",
+	},
+	msgMissingText: {
+		"en": "Missing required parameter: text",
+		"ja": "必須パラメータ text がありません",
+	},
+	msgMissingTextAndSpeaker: {
+		"en": "Missing required parameters: text and speaker",
+		"ja": "必須パラメータ text と speaker がありません",
+	},
+	msgEmptyText: {
+		"en": "text must not be empty",
+		"ja": "text が空です",
+	},
+	msgOutOfRange: {
+		"en": "Invalid %s: value must be between %d and %d",
+		"ja": "%s が不正です: %d から %d の範囲で指定してください",
+	},
+	msgSpeakerOutOfRange: {
+		"en": "Invalid %s: value must be between %d and %d for speaker %s",
+		"ja": "%[1]s が不正です: 話者 %[4]s では %[2]d から %[3]d の範囲で指定してください",
+	},
+	msgUnknownSpeaker: {
+		"en": "unknown speaker: %s",
+		"ja": "話者 %s は存在しません",
+	},
+	msgTextTooLong: {
+		"en": "Text is %d characters long, the maximum is %d",
+		"ja": "テキストが %d 文字あります。最大 %d 文字までです",
+	},
+	msgUnauthorized: {
+		"en": "Invalid or missing API key",
+		"ja": "API キーがないか、正しくありません",
+	},
+	msgRateLimited: {
+		"en": "Too many requests",
+		"ja": "リクエストが多すぎます",
+	},
+	msgServerBusy: {
+		"en": "server is busy, please retry later",
+		"ja": "サーバーが混雑しています。しばらくしてから再試行してください",
+	},
+	msgInvalidCorsPolicy: {
+		"en": "Invalid corsPolicyMode %q: must be localapps or all",
+		"ja": "corsPolicyMode %q は不正です: localapps か all を指定してください",
+	},
+	msgConfirmCorsAll: {
+		"en": `Switching the CORS policy to "all" allows requests from any website; send "confirm": true to apply it`,
+		"ja": `CORS ポリシーを "all" にすると、あらゆるウェブサイトからのリクエストが許可されます。適用するには "confirm": true を送信してください`,
+	},
+}
+
+// localize formats the message for key in lang, falling back to English.
+func localize(lang, key string, args ...any) string {
+	formats := messageCatalog[key]
+	format, ok := formats[lang]
+	if !ok {
+		format = formats["en"]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// messageLang picks the language of error messages: the lang cookie set by
+// the web pages, then the best supported language in Accept-Language, and
+// Japanese otherwise.
+func messageLang(r *http.Request) string {
+	if c, err := r.Cookie("lang"); err == nil && normalizeLang(c.Value) == c.Value {
+		return c.Value
+	}
+
+	best, bestQ := defaultMessageLang, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if primary != "ja" && primary != "en" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		// The first of equally preferred languages wins
+		if q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
+
+// langWriter carries the message language of a request down to the error
+// writers, which only get the ResponseWriter.
+type langWriter struct {
+	http.ResponseWriter
+	lang string
+}
+
+// Flush passes through to the underlying writer so streaming handlers work.
+func (lw *langWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes through to the underlying writer so WebSocket upgrades work.
+func (lw *langWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(lw.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (lw *langWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// responseLang returns the message language recorded by withLang in w or one
+// of the writers it wraps.
+func responseLang(w http.ResponseWriter) string {
+	for w != nil {
+		if lw, ok := w.(*langWriter); ok {
+			return lw.lang
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	return defaultMessageLang
+}
+
+// Middleware to record the language of error messages for the request
+func (s *Server) withLang(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(&langWriter{ResponseWriter: w, lang: messageLang(r)}, r)
+	}
+}
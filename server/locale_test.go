@@ -0,0 +1,58 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestLocalizedErrors(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	tests := []struct {
+		acceptLanguage, cookie, want string
+	}{
+		{"", "", "話者 nobody は存在しません"},
+		{"ja", "", "話者 nobody は存在しません"},
+		{"en", "", "unknown speaker: nobody"},
+		{"en-US,en;q=0.9", "", "unknown speaker: nobody"},
+		{"ja;q=0.5, en;q=0.8", "", "unknown speaker: nobody"},
+		{"fr, de", "", "話者 nobody は存在しません"},
+		{"en", "lang=ja", "話者 nobody は存在しません"},
+		{"", "lang=en", "unknown speaker: nobody"},
+	}
+	for _, tt := range tests {
+		r := jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "あ", Speaker: "nobody"})
+		if tt.acceptLanguage != "" {
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		if tt.cookie != "" {
+			r.Header.Set("Cookie", tt.cookie)
+		}
+		rec := serve(s, r)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusBadRequest, rec.Body)
+		}
+		var resp errorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		// The code does not depend on the language
+		if resp.Error.Code != errCodeUnknownSpeaker || resp.Error.Message != tt.want {
+			t.Errorf("Accept-Language %q, cookie %q: error = %+v, want %s with %q",
+				tt.acceptLanguage, tt.cookie, resp.Error, errCodeUnknownSpeaker, tt.want)
+		}
+	}
+}
+
+func TestLocalizedMessagesAreComplete(t *testing.T) {
+	for key, formats := range messageCatalog {
+		for _, lang := range []string{"ja", "en"} {
+			if formats[lang] == "" {
+				t.Errorf("message %s has no %s text", key, lang)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// responseWriter records the status code and body size written by a handler.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += n
+	return n, err
+}
+
+// Flush passes through to the underlying writer so streaming handlers work
+// behind the logging and metrics middlewares.
+func (rw *responseWriter) Flush() {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes through to the underlying writer so WebSocket upgrades work
+// behind the logging and metrics middlewares.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil && rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Middleware to log each request with its status, size and duration, and
+// to record it for GET /admin/recent when enabled
+func (s *Server) logRequests(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+
+		var info *requestInfo
+		if s.recent != nil {
+			info = &requestInfo{}
+			r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		}
+
+		handler(rw, r)
+
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		durationMs := float64(time.Since(start).Microseconds()) / 1000
+
+		slog.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote_addr", r.RemoteAddr,
+			"status", rw.status,
+			"size", rw.size,
+			"duration_ms", durationMs,
+		)
+
+		if info != nil {
+			info.mu.Lock()
+			entry := recentRequest{
+				Time:       start,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Status:     rw.status,
+				DurationMs: durationMs,
+				Speaker:    info.speaker,
+				TextLength: info.textLength,
+			}
+			info.mu.Unlock()
+			s.recent.add(entry)
+		}
+	}
+}
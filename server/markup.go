@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxBreak caps the duration of a single <break> tag.
+const maxBreak = 10 * time.Second
+
+// markupSegment is a piece of text preceded by a pause.
+type markupSegment struct {
+	Pause time.Duration
+	Text  string
+}
+
+// parseMarkup parses the SSML subset accepted in marked-up text: <break
+// time="500ms"/> inserts a pause, and <emphasis>...</emphasis> is accepted
+// but only its text is kept since vpeak has no equivalent. Special
+// characters in the text must be escaped as in XML.
+func parseMarkup(text string) ([]markupSegment, error) {
+	dec := xml.NewDecoder(strings.NewReader("<speak>" + text + "</speak>"))
+
+	var segments []markupSegment
+	var current markupSegment
+	var sb strings.Builder
+	flush := func() {
+		current.Text = strings.TrimSpace(sb.String())
+		if current.Text != "" || current.Pause > 0 {
+			segments = append(segments, current)
+		}
+		current = markupSegment{}
+		sb.Reset()
+	}
+
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed markup: %v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 1 && t.Name.Local == "speak":
+			case t.Name.Local == "emphasis":
+			case t.Name.Local == "break":
+				pause, err := breakDuration(t)
+				if err != nil {
+					return nil, err
+				}
+				if strings.TrimSpace(sb.String()) != "" {
+					flush()
+				}
+				current.Pause += pause
+			default:
+				return nil, fmt.Errorf("unsupported markup tag: <%s>", t.Name.Local)
+			}
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			sb.Write(t)
+		case xml.Comment, xml.ProcInst, xml.Directive:
+			return nil, errors.New("malformed markup: only <break> and <emphasis> are allowed")
+		}
+	}
+	flush()
+
+	return segments, nil
+}
+
+// breakDuration reads the time attribute of a <break> tag.
+func breakDuration(t xml.StartElement) (time.Duration, error) {
+	value, found := "", false
+	for _, attr := range t.Attr {
+		if attr.Name.Local != "time" {
+			return 0, fmt.Errorf("unsupported <break> attribute: %s", attr.Name.Local)
+		}
+		value, found = attr.Value, true
+	}
+	if !found {
+		return 0, errors.New("<break> requires a time attribute")
+	}
+
+	if !strings.HasSuffix(value, "ms") && !strings.HasSuffix(value, "s") {
+		return 0, fmt.Errorf("invalid break time %q: use ms or s", value)
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 || d > maxBreak {
+		return 0, fmt.Errorf("invalid break time %q: must be between 0 and %s", value, maxBreak)
+	}
+	return d, nil
+}
+
+// validateMarkup checks marked-up text and reports problems as 400 errors.
+func validateMarkup(text string) error {
+	segments, err := parseMarkup(text)
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, errCodeInvalidMarkup, err.Error())
+	}
+	for _, seg := range segments {
+		if seg.Text != "" {
+			return nil
+		}
+	}
+	return newAPIError(http.StatusBadRequest, errCodeInvalidMarkup, "markup contains no text")
+}
@@ -0,0 +1,82 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMarkup(t *testing.T) {
+	tests := []struct {
+		text string
+		want []markupSegment
+	}{
+		{"こんにちは", []markupSegment{{Text: "こんにちは"}}},
+		{`こんにちは<break time="500ms"/>さようなら`, []markupSegment{
+			{Text: "こんにちは"},
+			{Pause: 500 * time.Millisecond, Text: "さようなら"},
+		}},
+		{`<break time="1s"/><emphasis>強調</emphasis>です`, []markupSegment{{Pause: time.Second, Text: "強調です"}}},
+		{`前<break time="200ms"/><break time="300ms"/>後`, []markupSegment{
+			{Text: "前"},
+			{Pause: 500 * time.Millisecond, Text: "後"},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := parseMarkup(tt.text)
+		if err != nil {
+			t.Errorf("parseMarkup(%q): %v", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMarkup(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseMarkupRejectsMalformedInput(t *testing.T) {
+	for _, text := range []string{
+		`<break time="500ms">`,
+		`<break/>`,
+		`<break time="5"/>`,
+		`<break time="11s"/>`,
+		`<break time="1s" strength="x"/>`,
+		`<prosody rate="slow">速く</prosody>`,
+		`<!-- comment -->text`,
+		`a & b`,
+	} {
+		if _, err := parseMarkup(text); err == nil {
+			t.Errorf("parseMarkup(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestSynthesisMarkup(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	query := AudioQuery{Text: `こんにちは<break time="500ms"/>さようなら`, Speaker: "f1", Markup: true}
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	calls := engine.Calls()
+	if len(calls) != 2 || calls[0].Text != "こんにちは" || calls[1].Text != "さようなら" {
+		t.Errorf("engine calls = %+v, want one per segment", calls)
+	}
+	want := 10*fakeRuneDuration + 500*time.Millisecond
+	if got := wavDuration(t, rec.Body.Bytes()); got != want {
+		t.Errorf("duration = %s, want %s", got, want)
+	}
+
+	query.Text = `こんにちは<break time="500ms">`
+	rec = serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidMarkup)
+
+	query.Text = `<break time="500ms"/>`
+	rec = serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidMarkup)
+}
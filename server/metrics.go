@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors of a Server and the /metrics
+// handler serving them.
+type metrics struct {
+	requestsTotal     *prometheus.CounterVec
+	synthesisDuration prometheus.Histogram
+	synthesisErrors   prometheus.Counter
+	synthesisInFlight prometheus.Gauge
+
+	handler http.Handler
+}
+
+// newMetrics creates the collectors and registers them in a new registry.
+func newMetrics() *metrics {
+	m := &metrics{
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "vpeakserver_http_requests_total",
+			Help: "Number of HTTP requests by endpoint and status code.",
+		}, []string{"endpoint", "status"}),
+
+		synthesisDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "vpeakserver_synthesis_duration_seconds",
+			Help:    "Duration of /synthesis requests.",
+			Buckets: []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32, 64},
+		}),
+
+		synthesisErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vpeakserver_synthesis_errors_total",
+			Help: "Number of failed vpeak.GenerateSpeech calls.",
+		}),
+
+		synthesisInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "vpeakserver_synthesis_in_flight",
+			Help: "Number of vpeak.GenerateSpeech calls currently running.",
+		}),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		m.requestsTotal,
+		m.synthesisDuration,
+		m.synthesisErrors,
+		m.synthesisInFlight,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	m.handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	return m
+}
+
+// Middleware to count requests per endpoint and status
+func (s *Server) instrument(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.metrics == nil {
+			handler(w, r)
+			return
+		}
+
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+
+		handler(rw, r)
+
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		s.metrics.requestsTotal.WithLabelValues(endpoint, strconv.Itoa(rw.status)).Inc()
+		if endpoint == "/synthesis" {
+			s.metrics.synthesisDuration.Observe(time.Since(start).Seconds())
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// originsPollInterval is how often the allowed origins file is checked for
+// changes.
+const originsPollInterval = 2 * time.Second
+
+// originList is the set of allowed origins read from a file. It is reloaded
+// when the file changes.
+type originList struct {
+	path string
+
+	mu      sync.RWMutex
+	origins map[string]struct{}
+	modTime time.Time
+	size    int64
+}
+
+// newOriginList loads the origins in path.
+func newOriginList(path string) (*originList, error) {
+	l := &originList{path: path}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// loadOrigins reads one origin per line from path. Blank lines and lines
+// starting with # are ignored.
+func loadOrigins(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open allowed origins file: %w", err)
+	}
+	defer f.Close()
+
+	origins := map[string]struct{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		origins[line] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read allowed origins file: %w", err)
+	}
+	return origins, nil
+}
+
+// reload reads the file again and replaces the loaded origins.
+func (l *originList) reload() error {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat allowed origins file: %w", err)
+	}
+	origins, err := loadOrigins(l.path)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.origins = origins
+	l.modTime = info.ModTime()
+	l.size = info.Size()
+	l.mu.Unlock()
+	return nil
+}
+
+// changed reports whether the file differs from the loaded version.
+func (l *originList) changed() bool {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return !info.ModTime().Equal(l.modTime) || info.Size() != l.size
+}
+
+// Contains reports whether origin is in the list or matches one of its
+// wildcard patterns (see matchOrigin). A nil list contains nothing.
+func (l *originList) Contains(origin string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, ok := l.origins[origin]; ok {
+		return true
+	}
+	for pattern := range l.origins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch polls the file until done is closed and reloads it when it changes.
+// When the file cannot be read the previous origins stay in effect.
+func (l *originList) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(originsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if !l.changed() {
+			continue
+		}
+		if err := l.reload(); err != nil {
+			slog.Warn("Failed to reload allowed origins", "path", l.path, "error", err)
+			continue
+		}
+		slog.Info("Reloaded allowed origins", "path", l.path)
+	}
+}
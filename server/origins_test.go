@@ -0,0 +1,113 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeOrigins writes content to path and moves its modification time
+// forward, so a rewrite within the timestamp resolution is still noticed.
+func writeOrigins(t *testing.T, path, content string, age time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadOrigins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "origins.txt")
+	writeOrigins(t, path, "# allowed sites\nhttps://a.example.com\n\n   https://b.example.com  \n#https://c.example.com\n", 0)
+
+	origins, err := loadOrigins(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(origins) != 2 {
+		t.Errorf("loaded %d origins, want 2: %v", len(origins), origins)
+	}
+	for _, origin := range []string{"https://a.example.com", "https://b.example.com"} {
+		if _, ok := origins[origin]; !ok {
+			t.Errorf("%s was not loaded", origin)
+		}
+	}
+
+	if _, err := loadOrigins(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("loading a missing file succeeded, want an error")
+	}
+}
+
+func TestOriginListReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "origins.txt")
+	writeOrigins(t, path, "https://old.example.com\n", time.Hour)
+
+	l, err := newOriginList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Contains("https://old.example.com") || l.Contains("https://new.example.com") {
+		t.Fatal("list does not hold the origins of the file")
+	}
+	if l.changed() {
+		t.Error("list reports a change before the file was edited")
+	}
+
+	writeOrigins(t, path, "https://new.example.com\nhttps://*.apps.example.com\n", 0)
+	if !l.changed() {
+		t.Fatal("list does not notice the edited file")
+	}
+	if err := l.reload(); err != nil {
+		t.Fatal(err)
+	}
+	for origin, want := range map[string]bool{
+		"https://old.example.com":      false,
+		"https://new.example.com":      true,
+		"https://one.apps.example.com": true,
+	} {
+		if got := l.Contains(origin); got != want {
+			t.Errorf("Contains(%q) = %v after the reload, want %v", origin, got, want)
+		}
+	}
+
+	// A file that cannot be read keeps the previous origins
+	os.Remove(path)
+	if l.changed() || l.reload() == nil || !l.Contains("https://new.example.com") {
+		t.Error("a removed file replaced the loaded origins")
+	}
+
+	var nilList *originList
+	if nilList.Contains("https://new.example.com") {
+		t.Error("a nil list contains an origin")
+	}
+}
+
+func TestAllowedOriginsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "origins.txt")
+	writeOrigins(t, path, "https://app.example.com\n", 0)
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "localapps", AllowedOriginsFile: path})
+
+	for origin, want := range map[string]string{
+		"https://app.example.com":   "https://app.example.com",
+		"https://other.example.com": "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/speakers", nil)
+		r.Header.Set("Origin", origin)
+		if got := serve(s, r).Header().Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", origin, got, want)
+		}
+	}
+
+	if s, err := New(Config{Engine: &fakeEngine{}, TmpDir: t.TempDir(), AllowedOriginsFile: filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		s.Close()
+		t.Error("New succeeded with a missing origins file, want an error")
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// supportedChannels are the channel counts a query may request.
+	supportedChannels = []int{1, 2}
+	// supportedBitDepths are the sample sizes a query may request.
+	supportedBitDepths = []int{8, 16, 24}
+)
+
+// validatePCMFormat rejects channel counts and bit depths that are not
+// supported.
+func validatePCMFormat(channels, bitDepth *int) error {
+	if channels != nil && !slices.Contains(supportedChannels, *channels) {
+		return newAPIError(http.StatusBadRequest, errCodeInvalidChannels, fmt.Sprintf("Invalid channels %d: must be one of %s", *channels, joinInts(supportedChannels)))
+	}
+	if bitDepth != nil && !slices.Contains(supportedBitDepths, *bitDepth) {
+		return newAPIError(http.StatusBadRequest, errCodeInvalidBitDepth, fmt.Sprintf("Invalid bit_depth %d: must be one of %s", *bitDepth, joinInts(supportedBitDepths)))
+	}
+	return nil
+}
+
+// joinInts lists values separated by commas.
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+// convertPCM converts data to the requested channel count and bit depth,
+// leaving out a nil one. Down-mixing to mono averages the channels and
+// up-mixing copies the mono channel to each output channel. data is returned
+// as is when it already has the format; otherwise a new WAV is returned and
+// data is not modified.
+func convertPCM(data []byte, channels, bitDepth *int) ([]byte, error) {
+	format, pcm, err := decodeWAV(data)
+	if err != nil {
+		return nil, err
+	}
+	if format.AudioFormat != 1 {
+		return nil, fmt.Errorf("unsupported WAV encoding %d: only integer PCM can be converted", format.AudioFormat)
+	}
+
+	out := format
+	if channels != nil {
+		out.Channels = uint16(*channels)
+	}
+	if bitDepth != nil {
+		out.BitsPerSample = uint16(*bitDepth)
+	}
+	if out == format {
+		return data, nil
+	}
+
+	inWidth, outWidth := int(format.BitsPerSample)/8, int(out.BitsPerSample)/8
+	inChannels, outChannels := int(format.Channels), int(out.Channels)
+	frames := len(pcm) / format.blockAlign()
+
+	converted := make([]byte, frames*out.blockAlign())
+	for f := 0; f < frames; f++ {
+		in := f * format.blockAlign()
+		at := f * out.blockAlign()
+		if outChannels == 1 && inChannels > 1 {
+			var sum float64
+			for c := 0; c < inChannels; c++ {
+				sum += pcmSample(pcm, in+c*inWidth, inWidth)
+			}
+			putPCMSample(converted, at, outWidth, sum/float64(inChannels))
+			continue
+		}
+		for c := 0; c < outChannels; c++ {
+			// Channels beyond the input repeat its last one
+			v := pcmSample(pcm, in+min(c, inChannels-1)*inWidth, inWidth)
+			putPCMSample(converted, at+c*outWidth, outWidth, v)
+		}
+	}
+	return encodeWAV(out, converted), nil
+}
+
+// parsePCMFormatParams parses the optional channels and bit_depth
+// parameters.
+func parsePCMFormatParams(rawChannels, rawBitDepth string) (*int, *int, error) {
+	channels, err := parseOptionalIntParam(rawChannels, 1, math.MaxUint16)
+	if err != nil {
+		return nil, nil, newAPIError(http.StatusBadRequest, errCodeInvalidChannels, fmt.Sprintf("Invalid channels parameter: %v", err))
+	}
+	bitDepth, err := parseOptionalIntParam(rawBitDepth, 1, math.MaxUint16)
+	if err != nil {
+		return nil, nil, newAPIError(http.StatusBadRequest, errCodeInvalidBitDepth, fmt.Sprintf("Invalid bit_depth parameter: %v", err))
+	}
+	if err := validatePCMFormat(channels, bitDepth); err != nil {
+		return nil, nil, err
+	}
+	return channels, bitDepth, nil
+}
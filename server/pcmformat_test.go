@@ -0,0 +1,109 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/binary"
+	"net/http"
+	"testing"
+)
+
+func TestConvertPCMStereoToMono(t *testing.T) {
+	in := wavFormat{AudioFormat: 1, Channels: 2, SampleRate: 48000, BitsPerSample: 16}
+	pcm := make([]byte, 2*in.blockAlign())
+	for i, v := range []int16{0x2000, 0x1000, -0x1000, -0x3000} {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(v))
+	}
+
+	out, err := convertPCM(encodeWAV(in, pcm), intPtr(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, mono, err := decodeWAV(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.Channels != 1 || format.BitsPerSample != 16 || format.SampleRate != in.SampleRate {
+		t.Errorf("format = %+v, want 16-bit mono at %d Hz", format, in.SampleRate)
+	}
+	if len(mono) != 4 {
+		t.Fatalf("got %d bytes of PCM, want 4", len(mono))
+	}
+	// Each frame is the average of its channels
+	for i, want := range []int16{0x1800, -0x2000} {
+		if got := int16(binary.LittleEndian.Uint16(mono[2*i:])); got != want {
+			t.Errorf("frame %d = %#x, want %#x", i, got, want)
+		}
+	}
+}
+
+func TestConvertPCM24To16Bit(t *testing.T) {
+	in := wavFormat{AudioFormat: 1, Channels: 1, SampleRate: 48000, BitsPerSample: 24}
+	samples := []int32{0x400000, -0x400000, 0x123456, 0x7fffff}
+	pcm := make([]byte, len(samples)*3)
+	for i, v := range samples {
+		pcm[3*i], pcm[3*i+1], pcm[3*i+2] = byte(v), byte(v>>8), byte(v>>16)
+	}
+
+	out, err := convertPCM(encodeWAV(in, pcm), nil, intPtr(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, converted, err := decodeWAV(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.BitsPerSample != 16 || format.Channels != 1 {
+		t.Errorf("format = %+v, want 16-bit mono", format)
+	}
+	if len(converted) != 2*len(samples) {
+		t.Fatalf("got %d bytes of PCM, want %d", len(converted), 2*len(samples))
+	}
+	for i, want := range []int16{0x4000, -0x4000, 0x1234, 0x7fff} {
+		if got := int16(binary.LittleEndian.Uint16(converted[2*i:])); got != want {
+			t.Errorf("sample %d = %#x, want %#x", i, got, want)
+		}
+	}
+}
+
+func TestConvertPCMKeepsMatchingFormat(t *testing.T) {
+	data := fakeWAV("こんにちは")
+	got, err := convertPCM(data, intPtr(int(fakeFormat.Channels)), intPtr(int(fakeFormat.BitsPerSample)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &got[0] != &data[0] {
+		t.Error("audio already in the requested format was converted")
+	}
+}
+
+func TestSynthesisPCMFormat(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	_, original, _ := decodeWAV(fakeWAV("こんにちは"))
+	frames := len(original) / fakeFormat.blockAlign()
+
+	query := AudioQuery{Text: "こんにちは", Speaker: "f1", Channels: intPtr(2), BitDepth: intPtr(8)}
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	format, pcm, err := decodeWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.Channels != 2 || format.BitsPerSample != 8 || len(pcm) != frames*2 {
+		t.Errorf("format = %+v with %d bytes of PCM, want 8-bit stereo with %d", format, len(pcm), frames*2)
+	}
+
+	for _, q := range []AudioQuery{
+		{Text: "こんにちは", Speaker: "f1", Channels: intPtr(3)},
+		{Text: "こんにちは", Speaker: "f1", BitDepth: intPtr(12)},
+	} {
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", q))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("channels %v, bit depth %v: status = %d, want %d", q.Channels, q.BitDepth, rec.Code, http.StatusBadRequest)
+		}
+	}
+	wantError(t, serve(s, jsonRequest(t, http.MethodPost, "/synthesis?bit_depth=32", AudioQuery{Text: "こんにちは", Speaker: "f1"})), http.StatusBadRequest, errCodeInvalidBitDepth)
+	wantError(t, serve(s, jsonRequest(t, http.MethodPost, "/synthesis?channels=stereo", AudioQuery{Text: "こんにちは", Speaker: "f1"})), http.StatusBadRequest, errCodeInvalidChannels)
+}
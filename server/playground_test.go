@@ -0,0 +1,37 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlayground(t *testing.T) {
+	speed := 150
+	s, _ := newTestServer(t, Config{DefaultSpeed: &speed})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/playground", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want an HTML page", got)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"fetch('/speakers')",
+		"fetch('/emotions?speaker='",
+		"fetch('/synthesis'",
+		`<audio id="player"`,
+		fmt.Sprintf(`id="speed" name="speed" type="range" min="%d" max="%d" value="150"`, SpeedMin, SpeedMax),
+		fmt.Sprintf(`id="pitch" name="pitch" type="range" min="%d" max="%d" value="0"`, PitchMin, PitchMax),
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+}
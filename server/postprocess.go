@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+const (
+	// trimThreshold is the level (relative to full scale) below which
+	// samples count as silence when trimming, roughly -40 dBFS.
+	trimThreshold = 0.01
+	// normalizePeak is the level the loudest sample is scaled to.
+	normalizePeak = 0.95
+)
+
+// postProcessWAV applies the configured post-processing to the engine
+// output. When nothing is enabled data is returned as is; otherwise a new
+// WAV is returned and data is not modified.
+func (s *Server) postProcessWAV(data []byte) ([]byte, error) {
+	if !s.cfg.TrimSilence && !s.cfg.Normalize {
+		return data, nil
+	}
+
+	format, pcm, err := decodeWAV(data)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.TrimSilence {
+		pcm = trimSilence(format, pcm)
+	}
+	if s.cfg.Normalize {
+		pcm = normalizePCM(format, pcm)
+	}
+	return encodeWAV(format, pcm), nil
+}
+
+// pcmSample reads the sample at byte offset i as a value in [-1, 1).
+func pcmSample(pcm []byte, i, width int) float64 {
+	switch width {
+	case 1:
+		// 8-bit PCM is unsigned
+		return (float64(pcm[i]) - 128) / 128
+	case 2:
+		return float64(int16(binary.LittleEndian.Uint16(pcm[i:]))) / (1 << 15)
+	case 3:
+		v := int32(pcm[i]) | int32(pcm[i+1])<<8 | int32(int8(pcm[i+2]))<<16
+		return float64(v) / (1 << 23)
+	default:
+		return float64(int32(binary.LittleEndian.Uint32(pcm[i:]))) / (1 << 31)
+	}
+}
+
+// putPCMSample writes v, clamped to the range of width, at byte offset i.
+func putPCMSample(pcm []byte, i, width int, v float64) {
+	scale := math.Ldexp(1, width*8-1)
+	n := math.Round(v * scale)
+	n = math.Max(-scale, math.Min(scale-1, n))
+
+	switch width {
+	case 1:
+		pcm[i] = byte(int(n) + 128)
+	case 2:
+		binary.LittleEndian.PutUint16(pcm[i:], uint16(int16(n)))
+	case 3:
+		x := int32(n)
+		pcm[i], pcm[i+1], pcm[i+2] = byte(x), byte(x>>8), byte(x>>16)
+	default:
+		binary.LittleEndian.PutUint32(pcm[i:], uint32(int32(n)))
+	}
+}
+
+// applyVolume returns wav with its samples scaled to percent of their
+// level. Samples that would exceed full scale are clipped. At 100 percent
+// wav is returned as is.
+func applyVolume(wav []byte, percent int) ([]byte, error) {
+	if percent == 100 {
+		return wav, nil
+	}
+
+	format, pcm, err := decodeWAV(wav)
+	if err != nil {
+		return nil, err
+	}
+
+	width := int(format.BitsPerSample) / 8
+	gain := float64(percent) / 100
+	out := make([]byte, len(pcm))
+	for i := 0; i+width <= len(pcm); i += width {
+		putPCMSample(out, i, width, pcmSample(pcm, i, width)*gain)
+	}
+	return encodeWAV(format, out), nil
+}
+
+// fitDuration returns wav cut off or padded with trailing silence so that it
+// plays for d.
+func fitDuration(wav []byte, d time.Duration) ([]byte, error) {
+	format, pcm, err := decodeWAV(wav)
+	if err != nil {
+		return nil, err
+	}
+
+	out := silencePCM(format, d)
+	copy(out, pcm)
+	return encodeWAV(format, out), nil
+}
+
+// padSilence returns wav with lead of silence before it and trail after it.
+func padSilence(wav []byte, lead, trail time.Duration) ([]byte, error) {
+	format, pcm, err := decodeWAV(wav)
+	if err != nil {
+		return nil, err
+	}
+
+	before, after := silencePCM(format, lead), silencePCM(format, trail)
+	out := make([]byte, 0, len(before)+len(pcm)+len(after))
+	out = append(append(append(out, before...), pcm...), after...)
+	return encodeWAV(format, out), nil
+}
+
+// trimSilence drops the frames at both ends of pcm in which every channel
+// is below trimThreshold. Audio that is silent throughout is kept as is.
+// The result shares memory with pcm.
+func trimSilence(format wavFormat, pcm []byte) []byte {
+	width := int(format.BitsPerSample) / 8
+	frame := format.blockAlign()
+
+	loud := func(offset int) bool {
+		for i := offset; i < offset+frame; i += width {
+			if math.Abs(pcmSample(pcm, i, width)) >= trimThreshold {
+				return true
+			}
+		}
+		return false
+	}
+
+	start := 0
+	for start < len(pcm) && !loud(start) {
+		start += frame
+	}
+	if start == len(pcm) {
+		return pcm
+	}
+	end := len(pcm)
+	for end > start && !loud(end-frame) {
+		end -= frame
+	}
+	return pcm[start:end]
+}
+
+// normalizePCM returns a copy of pcm scaled so that its loudest sample
+// reaches normalizePeak. Silent input is returned unchanged.
+func normalizePCM(format wavFormat, pcm []byte) []byte {
+	width := int(format.BitsPerSample) / 8
+
+	var peak float64
+	for i := 0; i+width <= len(pcm); i += width {
+		peak = math.Max(peak, math.Abs(pcmSample(pcm, i, width)))
+	}
+	if peak == 0 {
+		return pcm
+	}
+
+	gain := normalizePeak / peak
+	out := make([]byte, len(pcm))
+	for i := 0; i+width <= len(pcm); i += width {
+		putPCMSample(out, i, width, pcmSample(pcm, i, width)*gain)
+	}
+	return out
+}
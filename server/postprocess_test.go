@@ -0,0 +1,248 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// pcm16 returns 16-bit PCM holding samples.
+func pcm16(samples ...int16) []byte {
+	pcm := make([]byte, 2*len(samples))
+	for i, v := range samples {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(v))
+	}
+	return pcm
+}
+
+func TestTrimSilence(t *testing.T) {
+	mono := wavFormat{AudioFormat: 1, Channels: 1, SampleRate: 48000, BitsPerSample: 16}
+	stereo := wavFormat{AudioFormat: 1, Channels: 2, SampleRate: 48000, BitsPerSample: 16}
+
+	tests := []struct {
+		name   string
+		format wavFormat
+		pcm    []byte
+		want   []byte
+	}{
+		{"mono", mono, pcm16(0, 10, 5000, -6000, 0, 20), pcm16(5000, -6000)},
+		{"one loud channel keeps the frame", stereo, pcm16(0, 0, 0, 4000, 3000, 0, 0, 0), pcm16(0, 4000, 3000, 0)},
+		{"silent throughout", mono, pcm16(0, 0, 0), pcm16(0, 0, 0)},
+		{"nothing to trim", mono, pcm16(5000, 6000), pcm16(5000, 6000)},
+	}
+	for _, tt := range tests {
+		if got := trimSilence(tt.format, tt.pcm); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: trimSilence = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizePCM(t *testing.T) {
+	format := wavFormat{AudioFormat: 1, Channels: 1, SampleRate: 48000, BitsPerSample: 16}
+
+	got := normalizePCM(format, pcm16(1000, -2000, 500))
+	peak := int16(math.Round(normalizePeak * (1 << 15)))
+	if want := pcm16(peak/2, -peak, peak/4); !bytes.Equal(got, want) {
+		t.Errorf("normalizePCM = %v, want %v", got, want)
+	}
+
+	silent := pcm16(0, 0)
+	if got := normalizePCM(format, silent); !bytes.Equal(got, silent) {
+		t.Errorf("normalizePCM of silence = %v, want it unchanged", got)
+	}
+}
+
+func TestPostProcessing(t *testing.T) {
+	s, _ := newTestServer(t, Config{TrimSilence: true, Normalize: true})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	_, pcm, err := decodeWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, original, _ := decodeWAV(fakeWAV("こんにちは"))
+	if len(pcm) != len(original) {
+		t.Fatalf("audio has %d bytes of PCM, want %d", len(pcm), len(original))
+	}
+	want := int16(math.Round(normalizePeak * (1 << 15)))
+	if got := int16(binary.LittleEndian.Uint16(pcm)); got != want {
+		t.Errorf("normalized sample = %d, want %d", got, want)
+	}
+}
+
+func TestApplyVolume(t *testing.T) {
+	format := wavFormat{AudioFormat: 1, Channels: 1, SampleRate: 48000, BitsPerSample: 16}
+	wav := encodeWAV(format, pcm16(1000, -2000, 30000, -30000))
+
+	tests := []struct {
+		percent int
+		want    []byte
+	}{
+		{100, pcm16(1000, -2000, 30000, -30000)},
+		{50, pcm16(500, -1000, 15000, -15000)},
+		{0, pcm16(0, 0, 0, 0)},
+		// Samples beyond full scale are clipped
+		{200, pcm16(2000, -4000, math.MaxInt16, math.MinInt16)},
+	}
+	for _, tt := range tests {
+		got, err := applyVolume(wav, tt.percent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, pcm, _ := decodeWAV(got); !bytes.Equal(pcm, tt.want) {
+			t.Errorf("applyVolume at %d%% = %v, want %v", tt.percent, pcm, tt.want)
+		}
+	}
+}
+
+func TestSynthesisVolume(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	volume := func(v int) *int { return &v }
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1", Volume: volume(100)}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), fakeWAV("こんにちは")) {
+		t.Error("volume 100 changed the audio")
+	}
+
+	rec = serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1", Volume: volume(50)}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	_, pcm, err := decodeWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := int16(binary.LittleEndian.Uint16(pcm)); got != fakeSample/2 {
+		t.Errorf("sample at volume 50 = %d, want %d", got, fakeSample/2)
+	}
+
+	for _, v := range []int{VolumeMin - 1, VolumeMax + 1} {
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1", Volume: volume(v)}))
+		wantError(t, rec, http.StatusBadRequest, errCodeInvalidVolume)
+	}
+}
+
+func TestVolumeErrorSameForEveryInput(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	volume := 201
+
+	requests := map[string]*http.Request{
+		"body":  jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1", Volume: &volume}),
+		"param": jsonRequest(t, http.MethodPost, "/synthesis?volume=201", AudioQuery{Text: "こんにちは", Speaker: "f1"}),
+		"query": httptest.NewRequest(http.MethodGet, "/synthesis_stream?text=こんにちは&speaker=f1&volume=201", nil),
+	}
+	messages := map[string]bool{}
+	for name, r := range requests {
+		r.Header.Set("Accept-Language", "en")
+		rec := serve(s, r)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want %d; body: %s", name, rec.Code, http.StatusBadRequest, rec.Body)
+		}
+		var resp errorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Error.Code != errCodeInvalidVolume {
+			t.Errorf("%s: error code = %q, want %q", name, resp.Error.Code, errCodeInvalidVolume)
+		}
+		messages[resp.Error.Message] = true
+	}
+	if len(messages) != 1 {
+		t.Errorf("messages differ by input: %v", messages)
+	}
+}
+
+func TestTargetDuration(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	target := func(ms int) *int { return &ms }
+
+	// fakeWAV speaks "こんにちは" for 50ms
+	for _, ms := range []int{20, 50, 200} {
+		query := AudioQuery{Text: "こんにちは", Speaker: "f1", TargetDurationMs: target(ms)}
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%dms: status = %d, want %d; body: %s", ms, rec.Code, http.StatusOK, rec.Body)
+		}
+		data := rec.Body.Bytes()
+		if got, want := wavDuration(t, data), time.Duration(ms)*time.Millisecond; got != want {
+			t.Errorf("%dms: duration = %v, want %v", ms, got, want)
+		}
+
+		// The speech is kept and only silence is added after it
+		_, pcm, _ := decodeWAV(data)
+		_, original, _ := decodeWAV(fakeWAV("こんにちは"))
+		kept := min(len(pcm), len(original))
+		if !bytes.Equal(pcm[:kept], original[:kept]) || !bytes.Equal(pcm[kept:], make([]byte, len(pcm)-kept)) {
+			t.Errorf("%dms: audio is not the speech cut or padded with silence", ms)
+		}
+	}
+
+	for _, ms := range []int{0, -10, TargetDurationMax + 1} {
+		query := AudioQuery{Text: "こんにちは", Speaker: "f1", TargetDurationMs: target(ms)}
+		wantError(t, serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)), http.StatusBadRequest, errCodeInvalidDuration)
+	}
+}
+
+func TestSilencePadding(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	_, original, _ := decodeWAV(fakeWAV("こんにちは"))
+	bytesPerMs := int(fakeFormat.SampleRate) / 1000 * fakeFormat.blockAlign()
+
+	tests := []struct {
+		lead, trail *int
+	}{
+		{intPtr(30), intPtr(70)},
+		{intPtr(100), nil},
+		{nil, intPtr(20)},
+		{intPtr(0), intPtr(0)},
+	}
+	for _, tt := range tests {
+		leadMs, trailMs := 0, 0
+		if tt.lead != nil {
+			leadMs = *tt.lead
+		}
+		if tt.trail != nil {
+			trailMs = *tt.trail
+		}
+
+		query := AudioQuery{Text: "こんにちは", Speaker: "f1", LeadSilenceMs: tt.lead, TrailSilenceMs: tt.trail}
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%dms+%dms: status = %d, want %d; body: %s", leadMs, trailMs, rec.Code, http.StatusOK, rec.Body)
+		}
+		data := rec.Body.Bytes()
+		// fakeWAV speaks "こんにちは" for 50ms
+		if got, want := wavDuration(t, data), time.Duration(50+leadMs+trailMs)*time.Millisecond; got != want {
+			t.Errorf("%dms+%dms: duration = %v, want %v", leadMs, trailMs, got, want)
+		}
+
+		_, pcm, _ := decodeWAV(data)
+		lead, trail := leadMs*bytesPerMs, trailMs*bytesPerMs
+		if len(pcm) != lead+len(original)+trail {
+			t.Fatalf("%dms+%dms: %d bytes of PCM, want %d", leadMs, trailMs, len(pcm), lead+len(original)+trail)
+		}
+		if !bytes.Equal(pcm[:lead], make([]byte, lead)) || !bytes.Equal(pcm[lead:lead+len(original)], original) || !bytes.Equal(pcm[lead+len(original):], make([]byte, trail)) {
+			t.Errorf("%dms+%dms: audio is not the speech between silences", leadMs, trailMs)
+		}
+	}
+
+	for _, ms := range []int{SilenceMin - 1, SilenceMax + 1} {
+		query := AudioQuery{Text: "こんにちは", Speaker: "f1", LeadSilenceMs: intPtr(ms)}
+		wantError(t, serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)), http.StatusBadRequest, errCodeInvalidSilence)
+		query = AudioQuery{Text: "こんにちは", Speaker: "f1", TrailSilenceMs: intPtr(ms)}
+		wantError(t, serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)), http.StatusBadRequest, errCodeInvalidSilence)
+	}
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// PprofHandler serves the net/http/pprof profiles under /debug/pprof/. The
+// handlers have no authentication, so they should only be reachable by
+// trusted clients, for example on a listener bound to localhost.
+func PprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	registerPprof(mux)
+	return mux
+}
+
+// registerPprof adds the pprof handlers to mux. They are registered without
+// the CORS, API key and compression middlewares, which profiling tools
+// cannot deal with.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
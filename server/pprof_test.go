@@ -0,0 +1,41 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPprof(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		// The profiles are served without the API key, which profiling tools
+		// cannot send
+		s, _ := newTestServer(t, Config{EnablePprof: enabled, APIKeys: []string{"secret"}})
+
+		want := http.StatusNotFound
+		if enabled {
+			want = http.StatusOK
+		}
+		for _, target := range []string{"/debug/pprof/", "/debug/pprof/cmdline"} {
+			if rec := serve(s, httptest.NewRequest(http.MethodGet, target, nil)); rec.Code != want {
+				t.Errorf("%s enabled %v: status = %d, want %d", target, enabled, rec.Code, want)
+			}
+		}
+	}
+}
+
+func TestPprofHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	PprofHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = httptest.NewRecorder()
+	PprofHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/synthesis", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("/synthesis: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
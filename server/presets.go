@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// Preset is a named bundle of voice settings that a query can refer to with
+// its preset field instead of repeating them.
+type Preset struct {
+	Name    string `json:"name"`
+	Speaker string `json:"speaker,omitempty"`
+	Emotion string `json:"emotion,omitempty"`
+	Speed   *int   `json:"speed,omitempty"`
+	Pitch   *int   `json:"pitch,omitempty"`
+}
+
+// LoadPresets reads a JSON array of presets from the given file.
+func LoadPresets(path string) ([]Preset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read presets file: %w", err)
+	}
+
+	var list []Preset
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse presets file: %w", err)
+	}
+	return list, nil
+}
+
+// validatePresets checks the presets against the speakers, so that a
+// broken preset is reported at startup rather than on every request.
+func (s *Server) validatePresets() error {
+	seen := map[string]bool{}
+	for i, p := range s.cfg.Presets {
+		if p.Name == "" {
+			return fmt.Errorf("preset at index %d has no name", i)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate preset %s", p.Name)
+		}
+		seen[p.Name] = true
+
+		if p.Speaker != "" {
+			name, err := s.resolveSpeaker(p.Speaker)
+			if err == nil {
+				err = s.validateSpeaker(name)
+			}
+			if err != nil {
+				return fmt.Errorf("preset %s: unknown speaker %s", p.Name, p.Speaker)
+			}
+		}
+		if p.Emotion != "" && !slices.Contains(engineEmotions, p.Emotion) {
+			return fmt.Errorf("preset %s: unsupported emotion %s", p.Name, p.Emotion)
+		}
+		if err := validateOptionalRange(p.Speed, SpeedMin, SpeedMax); err != nil {
+			return fmt.Errorf("preset %s: invalid speed: %w", p.Name, err)
+		}
+		if err := validateOptionalRange(p.Pitch, PitchMin, PitchMax); err != nil {
+			return fmt.Errorf("preset %s: invalid pitch: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// applyPreset fills in the fields of query left out by the client from the
+// preset it names, and clears the preset field. Fields sent with the query
+// take precedence. An unknown preset is a 400 error.
+func (s *Server) applyPreset(query *AudioQuery) error {
+	if query.Preset == "" {
+		return nil
+	}
+	i := slices.IndexFunc(s.cfg.Presets, func(p Preset) bool { return p.Name == query.Preset })
+	if i < 0 {
+		return newAPIError(http.StatusBadRequest, errCodeUnknownPreset, fmt.Sprintf("Unknown preset: %s", query.Preset))
+	}
+
+	p := s.cfg.Presets[i]
+	if query.Speaker == "" {
+		query.Speaker = p.Speaker
+	}
+	if query.Emotion == "" {
+		query.Emotion = p.Emotion
+	}
+	if query.Speed == nil {
+		query.Speed = p.Speed
+	}
+	if query.Pitch == nil {
+		query.Pitch = p.Pitch
+	}
+	query.Preset = ""
+	return nil
+}
+
+// handlePresets lists the presets a query can refer to.
+func (s *Server) handlePresets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	presets := s.cfg.Presets
+	if presets == nil {
+		presets = []Preset{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(presets); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to encode presets: %v", err))
+		return
+	}
+}
@@ -0,0 +1,104 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testPresets are presets with every field set and with only some.
+var testPresets = []Preset{
+	{Name: "calm-narrator", Speaker: "m1", Emotion: "sad", Speed: intPtr(80), Pitch: intPtr(-50)},
+	{Name: "fast", Speed: intPtr(180)},
+}
+
+func TestPresets(t *testing.T) {
+	s, _ := newTestServer(t, Config{Presets: testPresets})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/presets", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var listed []Preset
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(listed, testPresets) {
+		t.Errorf("/presets = %+v, want %+v", listed, testPresets)
+	}
+
+	s, _ = newTestServer(t, Config{})
+	if body := serve(s, httptest.NewRequest(http.MethodGet, "/presets", nil)).Body.String(); body != "[]\n" {
+		t.Errorf("/presets without presets = %q, want an empty list", body)
+	}
+}
+
+func TestPresetExpansion(t *testing.T) {
+	s, engine := newTestServer(t, Config{Presets: testPresets})
+
+	tests := []struct {
+		name    string
+		query   AudioQuery
+		speaker string
+		emotion string
+		speed   int
+		pitch   int
+	}{
+		{"expanded", AudioQuery{Preset: "calm-narrator"}, "m1", "sad", 80, -50},
+		{"speaker overridden", AudioQuery{Preset: "calm-narrator", Speaker: "f2"}, "f2", "sad", 80, -50},
+		{"emotion overridden", AudioQuery{Preset: "calm-narrator", Emotion: "happy"}, "m1", "happy", 80, -50},
+		{"speed overridden", AudioQuery{Preset: "calm-narrator", Speed: intPtr(120)}, "m1", "sad", 120, -50},
+		{"pitch overridden", AudioQuery{Preset: "calm-narrator", Pitch: intPtr(0)}, "m1", "sad", 80, 0},
+		{"partial preset", AudioQuery{Preset: "fast", Speaker: "f3", Pitch: intPtr(10)}, "f3", "", 180, 10},
+	}
+	for _, tt := range tests {
+		tt.query.Text = "こんにちは"
+		if rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", tt.query)); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d; body: %s", tt.name, rec.Code, http.StatusOK, rec.Body)
+		}
+		calls := engine.Calls()
+		opts := calls[len(calls)-1].Opts
+		if opts.Narrator != tt.speaker || opts.Emotion != tt.emotion || opts.Speed == nil || *opts.Speed != tt.speed || opts.Pitch == nil || *opts.Pitch != tt.pitch {
+			t.Errorf("%s: engine options = %+v, want %s/%q at speed %d and pitch %d", tt.name, opts, tt.speaker, tt.emotion, tt.speed, tt.pitch)
+		}
+	}
+
+	query := AudioQuery{Text: "こんにちは", Preset: "no-such-preset"}
+	wantError(t, serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)), http.StatusBadRequest, errCodeUnknownPreset)
+	wantError(t, serve(s, jsonRequest(t, http.MethodPost, "/audio_query", query)), http.StatusBadRequest, errCodeUnknownPreset)
+}
+
+func TestNewRejectsInvalidPresets(t *testing.T) {
+	for name, presets := range map[string][]Preset{
+		"no name":         {{Speaker: "f1"}},
+		"duplicate":       {{Name: "a"}, {Name: "a"}},
+		"unknown speaker": {{Name: "a", Speaker: "nobody"}},
+		"unknown emotion": {{Name: "a", Emotion: "bored"}},
+		"speed too high":  {{Name: "a", Speed: intPtr(SpeedMax + 1)}},
+	} {
+		if s, err := New(Config{Engine: &fakeEngine{}, TmpDir: t.TempDir(), Presets: presets}); err == nil {
+			s.Close()
+			t.Errorf("%s: New accepted the presets", name)
+		}
+	}
+}
+
+func TestLoadPresets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "presets.json")
+	if err := os.WriteFile(path, []byte(`[{"name": "calm-narrator", "speaker": "m1", "emotion": "sad", "speed": 80, "pitch": -50}, {"name": "fast", "speed": 180}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	presets, err := LoadPresets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(presets, testPresets) {
+		t.Errorf("LoadPresets = %+v, want %+v", presets, testPresets)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter keeps a token bucket for each client IP.
+type rateLimiter struct {
+	limit      rate.Limit
+	burst      int
+	trustProxy bool
+
+	mu       sync.Mutex
+	limiters map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(limit float64, burst int, trustProxy bool) *rateLimiter {
+	return &rateLimiter{
+		limit:      rate.Limit(limit),
+		burst:      burst,
+		trustProxy: trustProxy,
+		limiters:   map[string]*clientLimiter{},
+	}
+}
+
+// limiterStaleAfter is how long a client must be idle before its limiter is dropped.
+const limiterStaleAfter = 3 * time.Minute
+
+// clientIP returns the address used to identify the client. X-Forwarded-For
+// is only honored when the server runs behind a trusted proxy.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (l *rateLimiter) limiterFor(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cl, ok := l.limiters[ip]
+	if !ok {
+		cl = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[ip] = cl
+	}
+	cl.lastSeen = time.Now()
+	return cl.limiter
+}
+
+// cleanup periodically removes limiters of clients that have gone idle so
+// the map does not grow without bound. It returns when done is closed.
+func (l *rateLimiter) cleanup(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		l.mu.Lock()
+		for ip, cl := range l.limiters {
+			if time.Since(cl.lastSeen) > limiterStaleAfter {
+				delete(l.limiters, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Middleware to limit the request rate of each client IP
+func (s *Server) limitRate(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter == nil {
+			handler(w, r)
+			return
+		}
+
+		if delay := s.rateLimited(r); delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeLocalizedError(w, http.StatusTooManyRequests, errCodeRateLimited, msgRateLimited)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// rateLimited takes a token from the bucket of the client of r. It returns 0
+// when the request may go ahead, or how long the client has to wait when it
+// is over the limit, in which case no token is used.
+func (s *Server) rateLimited(r *http.Request) time.Duration {
+	if s.limiter == nil {
+		return 0
+	}
+	reservation := s.limiter.limiterFor(clientIP(r, s.limiter.trustProxy)).Reserve()
+	delay := reservation.Delay()
+	if delay > 0 {
+		reservation.Cancel()
+	}
+	return delay
+}
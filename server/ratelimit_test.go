@@ -0,0 +1,65 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimitPerIP(t *testing.T) {
+	const burst = 2
+	s, _ := newTestServer(t, Config{RateLimit: 0.01, RateBurst: burst})
+
+	synthesize := func(remoteAddr string) *http.Response {
+		r := jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"})
+		r.RemoteAddr = remoteAddr
+		return serve(s, r).Result()
+	}
+
+	for i := 0; i < burst; i++ {
+		if resp := synthesize("192.0.2.1:1234"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i, resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	// The port is not part of the client identity
+	resp := synthesize("192.0.2.1:5678")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("request over the burst: status = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if code := errorCode(t, resp.Body); code != errCodeRateLimited {
+		t.Errorf("error code = %q, want %q", code, errCodeRateLimited)
+	}
+	if retry, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retry < 1 {
+		t.Errorf("Retry-After = %q, want a positive number of seconds", resp.Header.Get("Retry-After"))
+	}
+
+	if resp := synthesize("192.0.2.2:1234"); resp.StatusCode != http.StatusOK {
+		t.Errorf("other client: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	for _, trust := range []bool{false, true} {
+		s, _ := newTestServer(t, Config{RateLimit: 0.01, RateBurst: 1, TrustProxy: trust})
+
+		statuses := make([]int, 2)
+		for i, forwarded := range []string{"198.51.100.1", "198.51.100.2"} {
+			r := jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"})
+			r.RemoteAddr = "192.0.2.1:1234"
+			r.Header.Set("X-Forwarded-For", forwarded+", 192.0.2.1")
+			statuses[i] = serve(s, r).Code
+		}
+
+		// Behind a trusted proxy the two requests come from different clients
+		want := http.StatusTooManyRequests
+		if trust {
+			want = http.StatusOK
+		}
+		if statuses[0] != http.StatusOK || statuses[1] != want {
+			t.Errorf("trust proxy %v: statuses = %v, want [200 %d]", trust, statuses, want)
+		}
+	}
+}
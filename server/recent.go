@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// recentRequest is an entry of GET /admin/recent. Only the length of the
+// text is kept, never the text itself.
+type recentRequest struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMs float64   `json:"duration_ms"`
+	Speaker    string    `json:"speaker,omitempty"`
+	TextLength int       `json:"text_length,omitempty"`
+}
+
+// recentLog is a ring buffer of the last requests. It is safe for
+// concurrent use.
+type recentLog struct {
+	mu      sync.Mutex
+	entries []recentRequest
+	next    int
+	full    bool
+}
+
+func newRecentLog(size int) *recentLog {
+	return &recentLog{entries: make([]recentRequest, size)}
+}
+
+// add records entry, replacing the oldest one when the buffer is full.
+func (l *recentLog) add(entry recentRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns the recorded requests, oldest first.
+func (l *recentLog) list() []recentRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]recentRequest{}, l.entries[:l.next]...)
+	}
+	return append(append([]recentRequest{}, l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+type requestInfoKey struct{}
+
+// requestInfo collects what handlers learn about a request for its
+// recentLog entry.
+type requestInfo struct {
+	mu         sync.Mutex
+	speaker    string
+	textLength int
+}
+
+// noteQuery records the speaker and text length of query on the request of
+// ctx, if the request is being recorded. Requests with several queries keep
+// the first one.
+func noteQuery(ctx context.Context, query AudioQuery) {
+	info, ok := ctx.Value(requestInfoKey{}).(*requestInfo)
+	if !ok {
+		return
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.speaker == "" && info.textLength == 0 {
+		info.speaker = query.Speaker
+		info.textLength = utf8.RuneCountInString(query.Text)
+	}
+}
+
+// handleAdminRecent serves GET /admin/recent, the last requests oldest first.
+func (s *Server) handleAdminRecent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(s.recent.list()); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to encode recent requests: %v", err))
+		return
+	}
+}
@@ -0,0 +1,80 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recentRequests fetches GET /admin/recent from s.
+func recentRequests(t *testing.T, s *Server) []recentRequest {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/admin/recent", nil)
+	r.Header.Set("X-API-Key", "secret")
+	rec := serve(s, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var list []recentRequest
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestAdminRecent(t *testing.T) {
+	s, _ := newTestServer(t, Config{EnableAdmin: true, APIKeys: []string{"secret"}, RecentRequests: 3})
+
+	wantError(t, serve(s, httptest.NewRequest(http.MethodGet, "/admin/recent", nil)), http.StatusUnauthorized, errCodeUnauthorized)
+
+	requests := []*http.Request{
+		jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "秘密の合言葉", Speaker: "f1"}),
+		httptest.NewRequest(http.MethodGet, "/emotions", nil),
+		httptest.NewRequest(http.MethodGet, "/no-such-page", nil),
+	}
+	for _, r := range requests {
+		r.Header.Set("X-API-Key", "secret")
+		serve(s, r)
+	}
+
+	// The log holds 3 requests, so the unauthorized one has been replaced
+	list := recentRequests(t, s)
+	want := []recentRequest{
+		{Method: http.MethodPost, Path: "/synthesis", Status: http.StatusOK, Speaker: "f1", TextLength: 6},
+		{Method: http.MethodGet, Path: "/emotions", Status: http.StatusOK},
+		{Method: http.MethodGet, Path: "/no-such-page", Status: http.StatusNotFound},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("got %d recent requests, want %d: %+v", len(list), len(want), list)
+	}
+	for i, got := range list {
+		w := want[i]
+		if got.Method != w.Method || got.Path != w.Path || got.Status != w.Status || got.Speaker != w.Speaker || got.TextLength != w.TextLength {
+			t.Errorf("recent request %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	// The text itself is never kept
+	r := httptest.NewRequest(http.MethodGet, "/admin/recent", nil)
+	r.Header.Set("X-API-Key", "secret")
+	if body := serve(s, r).Body.String(); strings.Contains(body, "秘密") {
+		t.Errorf("recent requests contain the text: %s", body)
+	}
+}
+
+func TestRecentLogWraps(t *testing.T) {
+	l := newRecentLog(2)
+	if got := l.list(); len(got) != 0 {
+		t.Errorf("empty log lists %d requests", len(got))
+	}
+	for _, path := range []string{"/a", "/b", "/c"} {
+		l.add(recentRequest{Path: path})
+	}
+	if got := l.list(); len(got) != 2 || got[0].Path != "/b" || got[1].Path != "/c" {
+		t.Errorf("list = %+v, want /b then /c", got)
+	}
+}
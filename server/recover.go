@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Middleware to turn handler panics into 500 responses
+func (s *Server) recoverPanics(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// net/http uses this panic to abort a response on purpose
+				panic(v)
+			}
+			slog.ErrorContext(r.Context(), "Handler panicked", "path", r.URL.Path, "panic", v, "stack", string(debug.Stack()))
+			writeJSONError(w, http.StatusInternalServerError, errCodeInternal, "Internal server error")
+		}()
+		handler(w, r)
+	}
+}
+
+// panicError converts a recovered panic value into an error.
+func panicError(v any) error {
+	if err, ok := v.(error); ok {
+		return fmt.Errorf("panic: %w", err)
+	}
+	return fmt.Errorf("panic: %v", v)
+}
@@ -0,0 +1,59 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRequestIDEchoed(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	r := httptest.NewRequest(http.MethodGet, "/speakers", nil)
+	r.Header.Set("X-Request-ID", "frontend-42")
+	if got := serve(s, r).Header().Get("X-Request-ID"); got != "frontend-42" {
+		t.Errorf("X-Request-ID = %q, want the one sent", got)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	for _, sent := range []string{"", "has space", strings.Repeat("x", maxRequestIDLength+1), "ログ"} {
+		r := httptest.NewRequest(http.MethodGet, "/speakers", nil)
+		if sent != "" {
+			r.Header.Set("X-Request-ID", sent)
+		}
+		got := serve(s, r).Header().Get("X-Request-ID")
+		if _, err := uuid.Parse(got); err != nil {
+			t.Errorf("sent %q: X-Request-ID = %q, want a generated UUID", sent, got)
+		}
+	}
+}
+
+func TestContextHandlerAddsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(ContextHandler{Handler: slog.NewTextHandler(&buf, nil)})
+
+	s, _ := newTestServer(t, Config{})
+	var ctx context.Context
+	handler := s.withRequestID(func(w http.ResponseWriter, r *http.Request) { ctx = r.Context() })
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Request-ID", "abc123")
+	handler(httptest.NewRecorder(), r)
+
+	logger.InfoContext(ctx, "with ID")
+	logger.Info("without ID")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "request_id=abc123") || strings.Contains(lines[1], "request_id") {
+		t.Errorf("log = %q, want the request ID on the first entry only", lines)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// supportedSampleRates are the output rates a query may request.
+var supportedSampleRates = []int{8000, 16000, 22050, 44100, 48000}
+
+// validateSampleRate rejects rates that are not in supportedSampleRates.
+func validateSampleRate(rate *int) error {
+	if rate == nil {
+		return nil
+	}
+	for _, r := range supportedSampleRates {
+		if *rate == r {
+			return nil
+		}
+	}
+
+	rates := make([]string, len(supportedSampleRates))
+	for i, r := range supportedSampleRates {
+		rates[i] = strconv.Itoa(r)
+	}
+	return newAPIError(http.StatusBadRequest, errCodeInvalidSampleRate, fmt.Sprintf("Invalid sample_rate %d: must be one of %s", *rate, strings.Join(rates, ", ")))
+}
+
+// resampleWAV converts data to rate using linear interpolation. data is
+// returned as is when it already has that rate; otherwise a new WAV is
+// returned and data is not modified.
+func resampleWAV(data []byte, rate int) ([]byte, error) {
+	format, pcm, err := decodeWAV(data)
+	if err != nil {
+		return nil, err
+	}
+	if int(format.SampleRate) == rate || format.SampleRate == 0 {
+		return data, nil
+	}
+
+	width := int(format.BitsPerSample) / 8
+	channels := int(format.Channels)
+	frame := format.blockAlign()
+	inFrames := len(pcm) / frame
+	outFrames := int(int64(inFrames) * int64(rate) / int64(format.SampleRate))
+
+	out := make([]byte, outFrames*frame)
+	step := float64(format.SampleRate) / float64(rate)
+	for i := 0; i < outFrames; i++ {
+		pos := float64(i) * step
+		j := int(pos)
+		frac := pos - float64(j)
+		next := min(j+1, inFrames-1)
+		for c := 0; c < channels; c++ {
+			a := pcmSample(pcm, j*frame+c*width, width)
+			b := pcmSample(pcm, next*frame+c*width, width)
+			putPCMSample(out, i*frame+c*width, width, a+(b-a)*frac)
+		}
+	}
+
+	format.SampleRate = uint32(rate)
+	return encodeWAV(format, out), nil
+}
+
+// parseSampleRateParam parses the optional sample_rate parameter.
+func parseSampleRateParam(raw string) (*int, error) {
+	rate, err := parseOptionalIntParam(raw, 1, math.MaxInt32)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, errCodeInvalidSampleRate, fmt.Sprintf("Invalid sample_rate parameter: %v", err))
+	}
+	if err := validateSampleRate(rate); err != nil {
+		return nil, err
+	}
+	return rate, nil
+}
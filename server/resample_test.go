@@ -0,0 +1,59 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestSynthesisSampleRate(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	_, original, _ := decodeWAV(fakeWAV("こんにちは"))
+	frames := len(original) / fakeFormat.blockAlign()
+
+	for _, rate := range []int{8000, 22050, 48000} {
+		query := AudioQuery{Text: "こんにちは", Speaker: "f1", SampleRate: intPtr(rate)}
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%d Hz: status = %d, want %d; body: %s", rate, rec.Code, http.StatusOK, rec.Body)
+		}
+
+		format, pcm, err := decodeWAV(rec.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(format.SampleRate) != rate || format.Channels != fakeFormat.Channels || format.BitsPerSample != fakeFormat.BitsPerSample {
+			t.Errorf("%d Hz: format = %+v", rate, format)
+		}
+		if want := frames * rate / int(fakeFormat.SampleRate); len(pcm)/format.blockAlign() != want {
+			t.Errorf("%d Hz: %d frames, want %d", rate, len(pcm)/format.blockAlign(), want)
+		}
+		if got := rec.Header().Get("X-Audio-Sample-Rate"); got != strconv.Itoa(rate) {
+			t.Errorf("%d Hz: X-Audio-Sample-Rate = %q", rate, got)
+		}
+	}
+}
+
+func TestResampleWAVKeepsMatchingRate(t *testing.T) {
+	data := fakeWAV("こんにちは")
+	got, err := resampleWAV(data, int(fakeFormat.SampleRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("audio at the requested rate was changed")
+	}
+}
+
+func TestSynthesisRejectsUnsupportedSampleRate(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1", SampleRate: intPtr(12345)}))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidSampleRate)
+
+	rec = serve(s, jsonRequest(t, http.MethodPost, "/synthesis?sample_rate=fast", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidSampleRate)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/shinshin86/vpeak"
+)
+
+const (
+	// retryBaseDelay is the wait before the first retry; it doubles with
+	// every further attempt up to retryMaxDelay.
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
+// retryable reports whether a failed engine call may succeed when repeated.
+// Only failures reported as server faults by engineError are assumed to be
+// transient.
+func retryable(err error) bool {
+	return engineError(err).Code == errCodeSynthesisFailed
+}
+
+// retryDelay returns the backoff before retry number attempt (from 1).
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempt && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMaxDelay)
+}
+
+// synthesizeWithRetry runs the engine, retrying retryable failures up to
+// SynthesisRetries times with exponential backoff. It stops early when ctx
+// is cancelled and then returns the last engine error.
+func (s *Server) synthesizeWithRetry(ctx context.Context, text string, opts vpeak.Options) error {
+	for attempt := 0; ; attempt++ {
+		err := s.engine.Synthesize(ctx, text, opts)
+		if err == nil || attempt >= s.cfg.SynthesisRetries || !retryable(err) {
+			return err
+		}
+
+		delay := retryDelay(attempt + 1)
+		slog.WarnContext(ctx, "Retrying synthesis", "attempt", attempt+1, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,79 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shinshin86/vpeak"
+)
+
+// failingEngine returns a fakeEngine whose first failures calls fail with
+// err.
+func failingEngine(failures int, err error) *fakeEngine {
+	engine := &fakeEngine{}
+	engine.synthesize = func(ctx context.Context, text string, opts vpeak.Options) error {
+		if len(engine.Calls()) <= failures {
+			return err
+		}
+		return nil
+	}
+	return engine
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+	engine := failingEngine(2, errors.New("resource busy"))
+	s, _ := newTestServer(t, Config{Engine: engine, SynthesisRetries: 3})
+
+	start := time.Now()
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if calls := engine.Calls(); len(calls) != 3 {
+		t.Errorf("engine called %d times, want 3", len(calls))
+	}
+	if elapsed, backoff := time.Since(start), retryDelay(1)+retryDelay(2); elapsed < backoff {
+		t.Errorf("retries took %s, want at least the %s of backoff", elapsed, backoff)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	engine := failingEngine(10, errors.New("resource busy"))
+	s, _ := newTestServer(t, Config{Engine: engine, SynthesisRetries: 1})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+	wantError(t, rec, http.StatusInternalServerError, errCodeSynthesisFailed)
+	if calls := engine.Calls(); len(calls) != 2 {
+		t.Errorf("engine called %d times, want 2", len(calls))
+	}
+}
+
+func TestRetrySkipsClientErrors(t *testing.T) {
+	engine := failingEngine(10, fmt.Errorf("%w: no such voice", errUnknownNarrator))
+	s, _ := newTestServer(t, Config{Engine: engine, SynthesisRetries: 3})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeUnknownSpeaker)
+	if calls := engine.Calls(); len(calls) != 1 {
+		t.Errorf("engine called %d times, want 1", len(calls))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{
+		1:  retryBaseDelay,
+		2:  2 * retryBaseDelay,
+		3:  4 * retryBaseDelay,
+		10: retryMaxDelay,
+	} {
+		if got := retryDelay(attempt); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
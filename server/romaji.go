@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// romajiSyllables maps Hepburn and Kunrei-shiki syllables to hiragana.
+var romajiSyllables = map[string]string{
+	"a": "あ", "i": "い", "u": "う", "e": "え", "o": "お",
+	"ka": "か", "ki": "き", "ku": "く", "ke": "け", "ko": "こ", "kya": "きゃ", "kyu": "きゅ", "kyo": "きょ",
+	"ga": "が", "gi": "ぎ", "gu": "ぐ", "ge": "げ", "go": "ご", "gya": "ぎゃ", "gyu": "ぎゅ", "gyo": "ぎょ",
+	"sa": "さ", "shi": "し", "si": "し", "su": "す", "se": "せ", "so": "そ",
+	"sha": "しゃ", "shu": "しゅ", "sho": "しょ", "she": "しぇ", "sya": "しゃ", "syu": "しゅ", "syo": "しょ",
+	"za": "ざ", "ji": "じ", "zi": "じ", "zu": "ず", "ze": "ぜ", "zo": "ぞ",
+	"ja": "じゃ", "ju": "じゅ", "jo": "じょ", "je": "じぇ", "zya": "じゃ", "zyu": "じゅ", "zyo": "じょ",
+	"ta": "た", "chi": "ち", "ti": "ち", "tsu": "つ", "tu": "つ", "te": "て", "to": "と",
+	"cha": "ちゃ", "chu": "ちゅ", "cho": "ちょ", "che": "ちぇ", "tya": "ちゃ", "tyu": "ちゅ", "tyo": "ちょ",
+	"da": "だ", "di": "ぢ", "du": "づ", "de": "で", "do": "ど",
+	"na": "な", "ni": "に", "nu": "ぬ", "ne": "ね", "no": "の", "nya": "にゃ", "nyu": "にゅ", "nyo": "にょ",
+	"ha": "は", "hi": "ひ", "fu": "ふ", "hu": "ふ", "he": "へ", "ho": "ほ", "hya": "ひゃ", "hyu": "ひゅ", "hyo": "ひょ",
+	"fa": "ふぁ", "fi": "ふぃ", "fe": "ふぇ", "fo": "ふぉ",
+	"ba": "ば", "bi": "び", "bu": "ぶ", "be": "べ", "bo": "ぼ", "bya": "びゃ", "byu": "びゅ", "byo": "びょ",
+	"pa": "ぱ", "pi": "ぴ", "pu": "ぷ", "pe": "ぺ", "po": "ぽ", "pya": "ぴゃ", "pyu": "ぴゅ", "pyo": "ぴょ",
+	"ma": "ま", "mi": "み", "mu": "む", "me": "め", "mo": "も", "mya": "みゃ", "myu": "みゅ", "myo": "みょ",
+	"ya": "や", "yu": "ゆ", "yo": "よ",
+	"ra": "ら", "ri": "り", "ru": "る", "re": "れ", "ro": "ろ", "rya": "りゃ", "ryu": "りゅ", "ryo": "りょ",
+	"wa": "わ", "wo": "を",
+	"va": "ゔぁ", "vi": "ゔぃ", "vu": "ゔ", "ve": "ゔぇ", "vo": "ゔぉ",
+}
+
+// romajiPunctuation maps ASCII punctuation to its Japanese form. A hyphen
+// marks a long vowel.
+var romajiPunctuation = map[rune]string{
+	'.': "。", ',': "、", '!': "！", '?': "？", '-': "ー",
+}
+
+// romajiMacrons are the long vowels of Hepburn, written as the vowel
+// followed by a long vowel mark.
+var romajiMacrons = map[rune]string{
+	'ā': "a-", 'ī': "i-", 'ū': "u-", 'ē': "e-", 'ō': "o-",
+	'â': "a-", 'î': "i-", 'û': "u-", 'ê': "e-", 'ô': "o-",
+}
+
+// romajiToKana converts romaji text to hiragana. Hepburn and Kunrei-shiki
+// spellings are accepted, including doubled consonants for っ, n or n' for
+// ん, and macrons or a hyphen for long vowels. Whitespace and digits are
+// kept. Text with other characters, or letters that do not form syllables,
+// is rejected.
+func romajiToKana(text string) (string, error) {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if long, ok := romajiMacrons[r]; ok {
+			sb.WriteString(long)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	src := sb.String()
+
+	var out strings.Builder
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+		case unicode.IsSpace(rune(c)) || c >= '0' && c <= '9':
+			out.WriteByte(c)
+			i++
+			continue
+		case c == '\'':
+			// Only separates n from a following vowel or y
+			i++
+			continue
+		case c < 0x80 && romajiPunctuation[rune(c)] != "":
+			out.WriteString(romajiPunctuation[rune(c)])
+			i++
+			continue
+		default:
+			r := []rune(src[i:])[0]
+			return "", fmt.Errorf("%q is not romaji", r)
+		}
+
+		next := byte(0)
+		if i+1 < len(src) {
+			next = src[i+1]
+		}
+		switch {
+		case c == 'n' && next == 'n':
+			// In konnichiwa the second n starts the next syllable
+			out.WriteString("ん")
+			i++
+			if after := src[i+1:]; after == "" || !isRomajiVowel(after[0]) && after[0] != 'y' {
+				i++
+			}
+			continue
+		case c == 'n' && !isRomajiVowel(next) && next != 'y':
+			out.WriteString("ん")
+			i++
+			continue
+		case c == 'm' && (next == 'b' || next == 'p'):
+			out.WriteString("ん")
+			i++
+			continue
+		case c == next && !isRomajiVowel(c) || c == 't' && strings.HasPrefix(src[i+1:], "ch"):
+			out.WriteString("っ")
+			i++
+			continue
+		}
+
+		matched := false
+		for size := 3; size >= 1; size-- {
+			if i+size > len(src) {
+				continue
+			}
+			if kana, ok := romajiSyllables[src[i:i+size]]; ok {
+				out.WriteString(kana)
+				i += size
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			end := i + 1
+			for end < min(i+3, len(src)) && src[end] >= 'a' && src[end] <= 'z' {
+				end++
+			}
+			return "", fmt.Errorf("%q is not a romaji syllable", src[i:end])
+		}
+	}
+	return out.String(), nil
+}
+
+func isRomajiVowel(c byte) bool {
+	return strings.IndexByte("aiueo", c) >= 0
+}
+
+// convertRomaji converts the text of a query with Romaji set to hiragana,
+// and clears the flag so the query is not converted twice.
+func convertRomaji(query *AudioQuery) error {
+	if !query.Romaji {
+		return nil
+	}
+	if query.Markup {
+		return newAPIError(http.StatusBadRequest, errCodeInvalidRomaji, "romaji cannot be combined with markup")
+	}
+	kana, err := romajiToKana(query.Text)
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, errCodeInvalidRomaji, fmt.Sprintf("Failed to convert romaji: %v", err))
+	}
+	query.Text = kana
+	query.Romaji = false
+	return nil
+}
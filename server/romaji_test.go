@@ -0,0 +1,84 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRomajiToKana(t *testing.T) {
+	for romaji, want := range map[string]string{
+		"konnichiwa":        "こんにちわ",
+		"Arigatou, sensei!": "ありがとう、 せんせい！",
+		"sakka":             "さっか",
+		"matcha":            "まっちゃ",
+		"kon'ya":            "こんや",
+		"shinbun":           "しんぶん",
+		"senpai 2":          "せんぱい 2",
+		"Tōkyō":             "とーきょー",
+		"tyotto":            "ちょっと",
+	} {
+		got, err := romajiToKana(romaji)
+		if err != nil {
+			t.Errorf("romajiToKana(%q): %v", romaji, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("romajiToKana(%q) = %q, want %q", romaji, got, want)
+		}
+	}
+}
+
+func TestRomajiToKanaRejectsOtherText(t *testing.T) {
+	for _, text := range []string{"こんにちは", "hello", "xyz", "qwerty", "konnichiwa@example"} {
+		if kana, err := romajiToKana(text); err == nil {
+			t.Errorf("romajiToKana(%q) = %q, want an error", text, kana)
+		}
+	}
+}
+
+func TestSynthesisRomaji(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	query := AudioQuery{Text: "konnichiwa", Speaker: "f1", Romaji: true}
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/audio_query", query))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/audio_query: status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var converted AudioQuery
+	if err := json.NewDecoder(rec.Body).Decode(&converted); err != nil {
+		t.Fatal(err)
+	}
+	if converted.Text != "こんにちわ" || converted.Romaji {
+		t.Errorf("/audio_query = %+v, want the converted text without the romaji flag", converted)
+	}
+
+	for _, r := range []*http.Request{
+		jsonRequest(t, http.MethodPost, "/synthesis", query),
+		jsonRequest(t, http.MethodPost, "/synthesis?romaji=true", AudioQuery{Text: "konnichiwa", Speaker: "f1"}),
+	} {
+		if rec := serve(s, r); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d; body: %s", r.URL, rec.Code, http.StatusOK, rec.Body)
+		}
+		calls := engine.Calls()
+		if got := calls[len(calls)-1].Text; got != "こんにちわ" {
+			t.Errorf("%s: engine text = %q, want こんにちわ", r.URL, got)
+		}
+	}
+
+	// Romaji is off by default
+	if rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "konnichiwa", Speaker: "f1"})); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	calls := engine.Calls()
+	if got := calls[len(calls)-1].Text; got != "konnichiwa" {
+		t.Errorf("engine text without romaji = %q, want konnichiwa", got)
+	}
+
+	for _, target := range []string{"/audio_query", "/synthesis"} {
+		bad := AudioQuery{Text: "こんにちは", Speaker: "f1", Romaji: true}
+		wantError(t, serve(s, jsonRequest(t, http.MethodPost, target, bad)), http.StatusBadRequest, errCodeInvalidRomaji)
+	}
+}
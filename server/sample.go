@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultSampleText is the phrase read by /speakers/{name}/sample unless
+// SampleText is set.
+const DefaultSampleText = "こんにちは。これはサンプル音声です。"
+
+// sampleCache keeps the speaker samples for the life of the server. Neither
+// the sample text nor the speakers change while it runs, so entries never
+// expire.
+type sampleCache struct {
+	mu      sync.Mutex
+	samples map[string][]byte
+}
+
+func (c *sampleCache) get(name string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wav, ok := c.samples[name]
+	return wav, ok
+}
+
+func (c *sampleCache) add(name string, wav []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.samples == nil {
+		c.samples = make(map[string][]byte)
+	}
+	c.samples[name] = wav
+}
+
+// handleSpeakerSample serves GET /speakers/{name}/sample, the sample text read
+// by the speaker with the default settings.
+func (s *Server) handleSpeakerSample(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/speakers/"), "/")
+	if rest != "sample" {
+		http.NotFound(w, r)
+		return
+	}
+	// The speaker may also be given by its ID
+	speaker, err := s.resolveSpeaker(name)
+	if err == nil {
+		err = s.validateSpeaker(speaker)
+	}
+	if err != nil {
+		writeLocalizedError(w, http.StatusNotFound, errCodeUnknownSpeaker, msgUnknownSpeaker, name)
+		return
+	}
+	name = speaker
+
+	wav, cached := s.samples.get(name)
+	if !cached {
+		query := AudioQuery{Text: s.cfg.SampleText, Speaker: name}
+		if err := s.validateSynthesisQuery(&query); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		wav, _, err = s.synthesizeQuery(r.Context(), query)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		s.samples.add(name, wav)
+	}
+
+	// The sample only changes when the server is reconfigured, so clients
+	// may keep it for a day. It may require an API key, so shared caches
+	// must not hand it to other clients
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	s.writeAudio(w, r, wav, cached, "wav")
+}
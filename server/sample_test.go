@@ -0,0 +1,54 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpeakerSample(t *testing.T) {
+	s, engine := newTestServer(t, Config{SampleText: "サンプル"})
+
+	for i := 0; i < 2; i++ {
+		rec := serve(s, httptest.NewRequest(http.MethodGet, "/speakers/f1/sample", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "audio/wav" {
+			t.Errorf("Content-Type = %q, want audio/wav", got)
+		}
+		if got, want := rec.Header().Get("Cache-Control"), "private, max-age=86400"; got != want {
+			t.Errorf("Cache-Control = %q, want %q", got, want)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), fakeWAV("サンプル")) {
+			t.Error("body is not the sample text read by the engine")
+		}
+	}
+
+	// The second request is served from the sample cache
+	calls := engine.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("engine called %d times, want 1", len(calls))
+	}
+	if calls[0].Text != "サンプル" || calls[0].Opts.Narrator != "f1" {
+		t.Errorf("engine call = %+v, want the sample text read by f1", calls[0])
+	}
+}
+
+func TestSpeakerSampleUnknownSpeaker(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/speakers/nobody/sample", nil))
+	wantError(t, rec, http.StatusNotFound, errCodeUnknownSpeaker)
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times for an unknown speaker", len(calls))
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/speakers/f1/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status for another speaker path = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
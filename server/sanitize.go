@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// sanitizeText removes characters that are invisible in the text but can
+// confuse the engine: control characters other than newlines and tabs, and
+// format characters such as zero-width spaces, byte order marks and bidi
+// controls. With nfkc the text is also NFKC-normalized, which turns
+// full-width ASCII and half-width katakana into their usual forms. Ordinary
+// Japanese text is left as is either way.
+func sanitizeText(text string, nfkc bool) string {
+	if nfkc {
+		text = norm.NFKC.String(text)
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case unicode.Is(unicode.Cc, r), unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// sanitizeQuery applies sanitizeText to the text and kana of query when
+// SanitizeText is set.
+func (s *Server) sanitizeQuery(query *AudioQuery) {
+	if !s.cfg.SanitizeText {
+		return
+	}
+	query.Text = sanitizeText(query.Text, s.cfg.NormalizeUnicode)
+	query.Kana = sanitizeText(query.Kana, s.cfg.NormalizeUnicode)
+}
+
+// trimQueryText removes the whitespace around the text and kana of query,
+// leaving the whitespace inside them. A text that is only whitespace counts
+// as missing, which is a 400 error unless a kana override is given.
+func trimQueryText(query *AudioQuery) error {
+	text, kana := strings.TrimSpace(query.Text), strings.TrimSpace(query.Kana)
+	if text == "" && query.Text != "" && kana == "" {
+		return newLocalizedError(http.StatusBadRequest, errCodeMissingParameters, msgEmptyText)
+	}
+	query.Text, query.Kana = text, kana
+	return nil
+}
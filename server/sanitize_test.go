@@ -0,0 +1,126 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		name, text string
+		nfkc       bool
+		want       string
+	}{
+		{"japanese", "こんにちは、世界。カタカナ漢字", true, "こんにちは、世界。カタカナ漢字"},
+		{"control characters", "こん\x00に\x07ち\x1bは\x7f", false, "こんにちは"},
+		{"newline and tab kept", "一行目\n\t二行目", false, "一行目\n\t二行目"},
+		{"zero-width and bidi", "\ufeffこん\u200bにち\u200dは\u202e", false, "こんにちは"},
+		{"full-width ascii", "ＡＢＣ１２３", true, "ABC123"},
+		{"half-width katakana", "ｶﾀｶﾅ", true, "カタカナ"},
+		{"no normalization", "ＡＢＣｶﾀｶﾅ", false, "ＡＢＣｶﾀｶﾅ"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeText(tt.text, tt.nfkc); got != tt.want {
+			t.Errorf("%s: sanitizeText(%q, %v) = %q, want %q", tt.name, tt.text, tt.nfkc, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeQuery(t *testing.T) {
+	const text = "ｺﾝﾆﾁﾊ\u200b\x00ＡＢＣ"
+
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"off", Config{}, text},
+		{"sanitize", Config{SanitizeText: true}, "ｺﾝﾆﾁﾊＡＢＣ"},
+		{"sanitize and normalize", Config{SanitizeText: true, NormalizeUnicode: true}, "コンニチハABC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, engine := newTestServer(t, tt.cfg)
+			query := AudioQuery{Text: text, Speaker: "f1"}
+
+			rec := serve(s, jsonRequest(t, http.MethodPost, "/audio_query", query))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			var normalized AudioQuery
+			if err := json.NewDecoder(rec.Body).Decode(&normalized); err != nil {
+				t.Fatal(err)
+			}
+			if normalized.Text != tt.want {
+				t.Errorf("/audio_query text = %q, want %q", normalized.Text, tt.want)
+			}
+
+			if rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if got := engine.Calls()[0].Text; got != tt.want {
+				t.Errorf("engine text = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWhitespaceOnlyText(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	for _, text := range []string{" ", "\n\t", "　 \r\n"} {
+		for _, target := range []string{"/audio_query", "/synthesis"} {
+			r := jsonRequest(t, http.MethodPost, target, AudioQuery{Text: text, Speaker: "f1"})
+			r.Header.Set("Accept-Language", "en")
+			rec := serve(s, r)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("%s %q: status = %d, want %d", target, text, rec.Code, http.StatusBadRequest)
+			}
+			var resp errorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error.Code != errCodeMissingParameters || resp.Error.Message != "text must not be empty" {
+				t.Errorf("%s %q: error = %+v, want %s saying the text must not be empty", target, text, resp.Error, errCodeMissingParameters)
+			}
+		}
+	}
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times for whitespace-only text", len(calls))
+	}
+}
+
+func TestTextTrimmed(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	// Whitespace inside the text is kept
+	for text, want := range map[string]string{
+		"  こんにちは 世界\n": "こんにちは 世界",
+		"　こんにちは\t":     "こんにちは",
+		"こんにちは\n世界":    "こんにちは\n世界",
+	} {
+		query := AudioQuery{Text: text, Speaker: "f1"}
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/audio_query", query))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, want %d; body: %s", text, rec.Code, http.StatusOK, rec.Body)
+		}
+		var trimmed AudioQuery
+		if err := json.NewDecoder(rec.Body).Decode(&trimmed); err != nil {
+			t.Fatal(err)
+		}
+		if trimmed.Text != want {
+			t.Errorf("%q: /audio_query text = %q, want %q", text, trimmed.Text, want)
+		}
+
+		if rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)); rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, want %d; body: %s", text, rec.Code, http.StatusOK, rec.Body)
+		}
+		calls := engine.Calls()
+		if got := calls[len(calls)-1].Text; got != want {
+			t.Errorf("%q: engine text = %q, want %q", text, got, want)
+		}
+	}
+}
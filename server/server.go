@@ -0,0 +1,495 @@
+// Package server implements the vpeakserver HTTP API and its settings pages.
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Config holds the options a Server is created with.
+type Config struct {
+	// CORS settings. CorsPolicyMode is "localapps" or "all" and, together
+	// with AllowedOrigin, can later be changed from the settings page.
+	CorsPolicyMode       string
+	AllowedOrigin        string
+	CorsMaxAge           int
+	CorsAllowCredentials bool
+
+	// CorsStrict rejects requests from disallowed origins in localapps mode
+	// with 403 instead of only leaving out the CORS headers.
+	CorsStrict bool
+
+	// CorsAllowHeaders are request headers allowed in addition to the ones
+	// the server reads, which are always allowed.
+	CorsAllowHeaders []string
+
+	// AllowedOriginsFile lists further allowed origins, one per line. It is
+	// reloaded when it changes.
+	AllowedOriginsFile string
+
+	// DictionaryFile lists replacements applied to query texts before
+	// synthesis, see loadDictionary. It is reloaded when it changes.
+	DictionaryFile string
+
+	// ConfigPath is where settings changes are saved. Empty disables saving.
+	ConfigPath string
+
+	// TmpDir is where synthesized audio is written before being served.
+	// Empty means the current working directory.
+	TmpDir    string
+	KeepAudio bool
+
+	// MaxConcurrent limits simultaneous engine calls (0 means unlimited).
+	// A request waits up to QueueTimeout for a free slot.
+	MaxConcurrent int
+	QueueTimeout  time.Duration
+
+	// SynthesisTimeout bounds a single synthesis, including retries (0
+	// means no limit).
+	SynthesisTimeout time.Duration
+
+	// SynthesisRetries is how often a failed engine call is retried.
+	SynthesisRetries int
+
+	// Speakers replaces the built-in narrator list when non-nil.
+	Speakers []Speaker
+
+	// VoicevoxCompat makes /audio_query and /synthesis accept the VOICEVOX
+	// engine API when the speaker parameter is a style ID, and adds the
+	// VOICEVOX fields to /speakers, see voicevox.go.
+	VoicevoxCompat bool
+
+	// AutoNarrators maps languages ("ja" or "en") to the speaker used for
+	// queries without one, chosen by the detected language of the text.
+	// When empty such queries use the engine's default narrator.
+	AutoNarrators map[string]string
+
+	// Presets are the named voice settings queries can refer to with their
+	// preset field, listed at /presets.
+	Presets []Preset
+
+	// DefaultSpeed and DefaultPitch are used when a query omits them.
+	DefaultSpeed *int
+	DefaultPitch *int
+
+	// SplitOn, when not empty, splits synthesis text into segments that are
+	// synthesized separately and joined with SegmentGap of silence.
+	SplitOn    string
+	SegmentGap time.Duration
+
+	// TrimSilence strips leading and trailing silence from the engine
+	// output, and Normalize peak-normalizes it.
+	TrimSilence bool
+	Normalize   bool
+
+	// KanaCommand, when set, is run with the text on stdin to add a kana
+	// reading preview to /audio_query responses. Arguments are separated by
+	// spaces.
+	KanaCommand string
+
+	// Engine generates the speech; nil means VOICEPEAK through vpeak.
+	Engine Engine
+
+	// FFmpegPath is the ffmpeg binary used for mp3/ogg output.
+	FFmpegPath string
+
+	// RequireEngine makes New fail when the engine cannot be used. Without
+	// it the server starts degraded: /ready reports the problem and the
+	// synthesis endpoints answer 503 engine_unavailable until the engine
+	// becomes available.
+	RequireEngine bool
+
+	// Warmup runs a throwaway synthesis when the server is created, so the
+	// first request does not pay for loading the engine. /ready answers 503
+	// until it has finished.
+	Warmup bool
+
+	// EnableMetrics exposes Prometheus metrics at /metrics.
+	EnableMetrics bool
+
+	// EnablePprof serves the net/http/pprof profiles under /debug/pprof/,
+	// without authentication. See also PprofHandler.
+	EnablePprof bool
+
+	// EnableGzip compresses JSON and HTML responses for clients that
+	// accept gzip.
+	EnableGzip bool
+
+	// EnableAdmin registers POST /admin/shutdown, which signals
+	// ShutdownRequested, and GET /admin/recent. It requires APIKeys.
+	EnableAdmin bool
+	// RecentRequests is the number of requests kept for GET /admin/recent
+	// (0 disables the log).
+	RecentRequests int
+
+	// RateLimit is the allowed /synthesis requests per second for each
+	// client IP (0 disables limiting).
+	RateLimit  float64
+	RateBurst  int
+	TrustProxy bool
+
+	// APIKeys, when not empty, are required on the synthesis endpoints.
+	APIKeys []string
+
+	// MaxTextLength caps the text of a query in runes (0 means no limit).
+	MaxTextLength int
+
+	// SanitizeText strips control and zero-width characters from query
+	// texts before synthesis. NormalizeUnicode also NFKC-normalizes them.
+	SanitizeText     bool
+	NormalizeUnicode bool
+
+	// MaxTextBytes caps the size of files uploaded to /synthesis_file
+	// (default 1 MiB).
+	MaxTextBytes int64
+
+	// MaxRequestBytes caps the size of JSON request bodies (default 1 MiB).
+	MaxRequestBytes int64
+
+	// JobQueueSize caps the jobs waiting in /jobs (default 100), and
+	// finished jobs are kept for JobTTL (default 1h).
+	JobQueueSize int
+	JobTTL       time.Duration
+
+	// MaxBatch caps the number of queries in /synthesis_batch and lines in
+	// /synthesis_dialogue (default 10).
+	MaxBatch int
+
+	// SpeakerDefaultEmotions applies the default_emotion of a speaker
+	// when a query omits the emotion or sends one the speaker does not
+	// support. Without it such emotions are blanked.
+	SpeakerDefaultEmotions bool
+
+	// SampleText is the phrase read by /speakers/{name}/sample (default
+	// DefaultSampleText).
+	SampleText string
+
+	// CacheSize is the number of results kept in memory (0 disables it).
+	// CacheMaxBytes also limits their total size, counting WAV and
+	// transcoded audio alike (0 means no limit).
+	// CacheDir enables a disk cache whose entries expire after CacheTTL.
+	// CacheStorage, when set, holds that cache instead of CacheDir.
+	CacheSize     int
+	CacheMaxBytes int64
+	CacheDir      string
+	CacheTTL      time.Duration
+	CacheStorage  Storage
+
+	// JobStorage holds the audio of finished /jobs. It defaults to files
+	// in a vpeakserver-jobs directory under TmpDir, or under the system
+	// temp directory when TmpDir is empty.
+	JobStorage Storage
+}
+
+// Server serves the vpeakserver endpoints. It logs through the default
+// slog logger.
+type Server struct {
+	cfg Config
+
+	// settings holds the CORS settings, which can be changed while the
+	// server is running.
+	settings *Settings
+	// origins holds the origins from AllowedOriginsFile, if any.
+	origins *originList
+	// dictionary holds the replacements from DictionaryFile, if any.
+	dictionary *dictionary
+	// csrfKey signs the CSRF tokens of the settings page.
+	csrfKey []byte
+	// corsAllowHeaders is the Access-Control-Allow-Headers value.
+	corsAllowHeaders string
+
+	engine   Engine
+	speakers []Speaker
+
+	// slots limits the number of concurrent GenerateSpeech calls.
+	// A nil channel means there is no limit.
+	slots chan struct{}
+
+	memoryCache *audioCache
+	diskCache   *diskAudioCache
+	// synthesisGroup coalesces concurrent synthesis of identical queries.
+	synthesisGroup singleflight.Group
+
+	limiter *rateLimiter
+	metrics *metrics
+	// recent holds the last requests for GET /admin/recent, when enabled.
+	recent  *recentLog
+	jobs    *jobStore
+	samples sampleCache
+
+	// warmedUp is closed when the warmup has finished. It is nil without
+	// Warmup.
+	warmedUp chan struct{}
+
+	done         chan struct{}
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+// New validates cfg and creates a Server. Background maintenance started
+// here is stopped by Close.
+func New(cfg Config) (*Server, error) {
+	if cfg.FFmpegPath == "" {
+		cfg.FFmpegPath = "ffmpeg"
+	}
+	if cfg.EnableAdmin && len(cfg.APIKeys) == 0 {
+		return nil, errors.New("the admin endpoints require an API key")
+	}
+	if cfg.SynthesisRetries < 0 {
+		return nil, fmt.Errorf("invalid synthesis retries %d: must not be negative", cfg.SynthesisRetries)
+	}
+	if cfg.SegmentGap < 0 {
+		return nil, fmt.Errorf("invalid segment gap %s: must not be negative", cfg.SegmentGap)
+	}
+	if cfg.MaxBatch <= 0 {
+		cfg.MaxBatch = 10
+	}
+	if cfg.MaxTextBytes <= 0 {
+		cfg.MaxTextBytes = 1 << 20
+	}
+	if cfg.MaxRequestBytes <= 0 {
+		cfg.MaxRequestBytes = 1 << 20
+	}
+	if cfg.JobQueueSize <= 0 {
+		cfg.JobQueueSize = 100
+	}
+	if cfg.JobTTL <= 0 {
+		cfg.JobTTL = time.Hour
+	}
+	if cfg.SampleText == "" {
+		cfg.SampleText = DefaultSampleText
+	}
+	if err := validateOptionalRange(cfg.DefaultSpeed, SpeedMin, SpeedMax); err != nil {
+		return nil, fmt.Errorf("invalid default speed: %w", err)
+	}
+	if err := validateOptionalRange(cfg.DefaultPitch, PitchMin, PitchMax); err != nil {
+		return nil, fmt.Errorf("invalid default pitch: %w", err)
+	}
+
+	s := &Server{
+		cfg: cfg,
+		settings: NewSettings(PersistedSettings{
+			CorsPolicyMode: cfg.CorsPolicyMode,
+			AllowOrigin:    cfg.AllowedOrigin,
+		}),
+		speakers: cfg.Speakers,
+		done:     make(chan struct{}),
+		shutdown: make(chan struct{}),
+	}
+	if s.speakers == nil {
+		s.speakers = defaultSpeakers
+	}
+	speakers, err := withSpeakerIDs(s.speakers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid speakers: %w", err)
+	}
+	s.speakers = speakers
+	if err := s.validateAutoNarrators(); err != nil {
+		return nil, err
+	}
+	if err := s.validatePresets(); err != nil {
+		return nil, fmt.Errorf("invalid presets: %w", err)
+	}
+	s.corsAllowHeaders = corsAllowHeaders(cfg)
+	s.engine = cfg.Engine
+	if s.engine == nil {
+		s.engine = vpeakEngine{}
+	}
+	if err := s.engine.Check(); err != nil {
+		if cfg.RequireEngine {
+			return nil, fmt.Errorf("the engine is not available: %w", err)
+		}
+		slog.Warn("Engine not available, synthesis requests will fail with 503 until it is", "error", err)
+	}
+
+	csrfKey, err := newCSRFKey()
+	if err != nil {
+		return nil, err
+	}
+	s.csrfKey = csrfKey
+
+	if cfg.AllowedOriginsFile != "" {
+		origins, err := newOriginList(cfg.AllowedOriginsFile)
+		if err != nil {
+			return nil, err
+		}
+		s.origins = origins
+		go s.origins.watch(s.done)
+	}
+
+	if cfg.DictionaryFile != "" {
+		dict, err := newDictionary(cfg.DictionaryFile)
+		if err != nil {
+			return nil, err
+		}
+		s.dictionary = dict
+		go s.dictionary.watch(s.done)
+	}
+
+	if cfg.EnableAdmin && cfg.RecentRequests > 0 {
+		s.recent = newRecentLog(cfg.RecentRequests)
+	}
+
+	if cfg.MaxConcurrent > 0 {
+		s.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+
+	if cfg.TmpDir != "" {
+		if err := os.MkdirAll(cfg.TmpDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create audio directory %s: %w", cfg.TmpDir, err)
+		}
+	}
+
+	if cfg.CacheSize > 0 {
+		s.memoryCache = newAudioCache(cfg.CacheSize, cfg.CacheMaxBytes)
+	}
+
+	cacheStorage := cfg.CacheStorage
+	if cacheStorage == nil && cfg.CacheDir != "" {
+		storage, err := NewFileStorage(cfg.CacheDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		cacheStorage = storage
+	}
+	if cacheStorage != nil {
+		s.diskCache = newDiskAudioCache(cacheStorage, cfg.CacheTTL)
+		if timed, ok := cacheStorage.(TimedStorage); ok && cfg.CacheTTL > 0 {
+			go s.diskCache.sweep(timed, s.done)
+		}
+	}
+
+	if cfg.RateLimit > 0 {
+		if cfg.RateBurst < 1 {
+			return nil, fmt.Errorf("invalid rate burst %d: must be at least 1", cfg.RateBurst)
+		}
+		s.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst, cfg.TrustProxy)
+		go s.limiter.cleanup(s.done)
+	}
+
+	if cfg.EnableMetrics {
+		s.metrics = newMetrics()
+	}
+
+	jobStorage := cfg.JobStorage
+	if jobStorage == nil {
+		jobStorage = &FileStorage{Dir: filepath.Join(cmp.Or(cfg.TmpDir, os.TempDir()), "vpeakserver-jobs")}
+	}
+	s.jobs = newJobStore(cfg.JobQueueSize, cfg.JobTTL, jobStorage)
+	go s.jobs.cleanup(s.done)
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	go func() {
+		<-s.done
+		cancelJobs()
+	}()
+	for i := 0; i < jobWorkers(cfg.MaxConcurrent); i++ {
+		go s.runJobs(jobCtx)
+	}
+
+	if cfg.Warmup {
+		s.warmedUp = make(chan struct{})
+		go s.warmup()
+	}
+
+	return s, nil
+}
+
+// Close stops background maintenance and removes audio files left behind by
+// interrupted requests. Call it after the HTTP server has shut down.
+func (s *Server) Close() {
+	close(s.done)
+	s.jobs.removeAll()
+	if !s.cfg.KeepAudio {
+		s.removeLeftoverAudioFiles()
+	}
+}
+
+// Routes returns a mux with every endpoint registered.
+func (s *Server) Routes() http.Handler {
+	mux := http.NewServeMux()
+
+	s.handle(mux, "/", s.handleIndex)
+	s.handle(mux, "/audio_query", s.enableCORS(s.requireAPIKey(s.handleAudioQuery), http.MethodGet, http.MethodPost))
+	s.handle(mux, "/synthesis", s.enableCORS(s.requireAPIKey(s.limitRate(s.handleSynthesis)), http.MethodPost))
+	s.handle(mux, "/synthesis_file", s.enableCORS(s.requireAPIKey(s.limitRate(s.handleSynthesisFile)), http.MethodPost))
+	s.handle(mux, "/synthesis_stream", s.enableCORS(s.requireAPIKey(s.limitRate(s.handleSynthesisStream)), http.MethodGet))
+	s.handle(mux, "/ws/synthesis", answerOptions(s.requireAPIKey(s.limitRate(s.handleWebSocketSynthesis)), http.MethodGet))
+	s.handle(mux, "/synthesis_batch", s.enableCORS(s.requireAPIKey(s.limitRate(s.handleSynthesisBatch)), http.MethodPost))
+	s.handle(mux, "/synthesis_dialogue", s.enableCORS(s.requireAPIKey(s.limitRate(s.handleSynthesisDialogue)), http.MethodPost))
+	s.handle(mux, "/jobs", s.enableCORS(s.requireAPIKey(s.limitRate(s.handleJobs)), http.MethodPost))
+	// Polling is not rate limited, as it does not run the engine
+	s.handle(mux, "/jobs/", s.enableCORS(s.requireAPIKey(s.handleJob), http.MethodGet))
+	s.handle(mux, "/speakers", s.enableCORS(s.handleSpeakers, http.MethodGet))
+	s.handle(mux, "/speakers/", s.enableCORS(s.requireAPIKey(s.limitRate(s.handleSpeakerSample)), http.MethodGet))
+	s.handle(mux, "/emotions", s.enableCORS(s.handleEmotions, http.MethodGet))
+	s.handle(mux, "/presets", s.enableCORS(s.handlePresets, http.MethodGet))
+	s.handle(mux, "/version", s.enableCORS(s.handleVersion, http.MethodGet))
+	s.handle(mux, "/openapi.json", s.enableCORS(s.handleOpenAPI, http.MethodGet))
+	s.handle(mux, "/docs", answerOptions(s.handleDocs, http.MethodGet))
+	s.handle(mux, "/static/", answerOptions(staticHandler(), http.MethodGet, http.MethodHead))
+	s.handle(mux, "/favicon.ico", answerOptions(s.handleFavicon, http.MethodGet, http.MethodHead))
+
+	// Liveness and readiness probes are neither CORS-wrapped nor access-logged
+	mux.HandleFunc("/health", answerOptions(s.handleHealth, http.MethodGet))
+	mux.HandleFunc("/ready", answerOptions(s.handleReady, http.MethodGet))
+
+	if s.metrics != nil {
+		mux.Handle("/metrics", s.metrics.handler)
+	}
+	if s.cfg.EnablePprof {
+		registerPprof(mux)
+	}
+
+	s.handle(mux, "/setting", answerOptions(s.handleSetting, http.MethodGet))
+	s.handle(mux, "/playground", answerOptions(s.handlePlayground, http.MethodGet))
+	// Like the settings page, the current settings are not CORS-wrapped, so
+	// other sites cannot read them through a visitor's browser
+	s.handle(mux, "/settings", answerOptions(s.handleSettings, http.MethodGet))
+	s.handle(mux, "/update-settings", answerOptions(s.requireCSRF(s.handleUpdateSettings), http.MethodPost))
+	s.handle(mux, "/set-lang", answerOptions(s.handleSetLang, http.MethodPost))
+
+	// The admin endpoints are left unregistered unless enabled, so they
+	// answer 404 by default
+	if s.cfg.EnableAdmin {
+		s.handle(mux, "/admin/shutdown", answerOptions(s.requireAPIKey(s.handleAdminShutdown), http.MethodPost))
+		if s.recent != nil {
+			s.handle(mux, "/admin/recent", answerOptions(s.requireAPIKey(s.handleAdminRecent), http.MethodGet))
+		}
+	}
+
+	return mux
+}
+
+// handle registers handler on mux with access logging, metrics and
+// compression.
+func (s *Server) handle(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	mux.HandleFunc(pattern, s.withRequestID(s.withLang(s.logRequests(s.instrument(pattern, s.recoverPanics(s.compress(handler)))))))
+}
+
+// removeLeftoverAudioFiles deletes temporary audio-*.wav files left in the
+// temp directory by interrupted synthesis requests.
+func (s *Server) removeLeftoverAudioFiles() {
+	files, err := filepath.Glob(filepath.Join(s.cfg.TmpDir, "audio-*.wav"))
+	if err != nil {
+		slog.Warn("Failed to list leftover audio files", "error", err)
+		return
+	}
+
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			slog.Warn("Failed to remove leftover audio file", "path", file, "error", err)
+			continue
+		}
+		slog.Info("Removed leftover audio file", "path", file)
+	}
+}
@@ -0,0 +1,231 @@
+//go:build darwin || windows
+
+package server
+
+// The tests only build on the systems VOICEPEAK runs on, because vpeak exits
+// at init everywhere else. None of them run VOICEPEAK: the servers under test
+// synthesize with fakeEngine.
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shinshin86/vpeak"
+)
+
+const (
+	// fakeFFmpegEnv makes the test binary act as ffmpeg, see runFakeFFmpeg.
+	fakeFFmpegEnv = "VPEAKSERVER_TEST_FAKE_FFMPEG"
+	// fakeFFmpegLogEnv names a file each fake ffmpeg run appends a line to.
+	fakeFFmpegLogEnv = "VPEAKSERVER_TEST_FAKE_FFMPEG_LOG"
+	// fakeKanaEnv makes the test binary act as a kana command, see
+	// runFakeKana.
+	fakeKanaEnv = "VPEAKSERVER_TEST_FAKE_KANA"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeFFmpegEnv) != "" {
+		os.Exit(runFakeFFmpeg(os.Args[1:]))
+	}
+	if os.Getenv(fakeKanaEnv) != "" {
+		os.Exit(runFakeKana())
+	}
+	os.Exit(m.Run())
+}
+
+// runFakeFFmpeg reads WAV data from stdin like the ffmpeg command run by
+// transcodeAudio, and writes "fake <format> <input size>" instead of
+// encoding it.
+func runFakeFFmpeg(args []string) int {
+	var format string
+	for i, arg := range args {
+		if arg == "-f" && i+1 < len(args) {
+			format = args[i+1]
+		}
+	}
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil || len(input) == 0 {
+		fmt.Fprintln(os.Stderr, "no input")
+		return 1
+	}
+	if path := os.Getenv(fakeFFmpegLogEnv); path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintln(f, format)
+		f.Close()
+	}
+	fmt.Printf("fake %s %d", format, len(input))
+	return 0
+}
+
+// useFakeFFmpeg makes the test binary stand in for ffmpeg and returns its
+// path, for Config.FFmpegPath.
+func useFakeFFmpeg(t *testing.T) string {
+	t.Helper()
+	t.Setenv(fakeFFmpegEnv, "1")
+	path, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fakeFormat is the format of the audio written by fakeEngine, the one
+// VOICEPEAK writes.
+var fakeFormat = wavFormat{AudioFormat: 1, Channels: 1, SampleRate: 48000, BitsPerSample: 16}
+
+const (
+	// fakeRuneDuration is how long fakeEngine speaks each rune of the text.
+	fakeRuneDuration = 10 * time.Millisecond
+	// fakeSample is the value of every sample written by fakeEngine, loud
+	// enough not to count as silence.
+	fakeSample = 0x1000
+)
+
+// fakeWAV returns the audio fakeEngine writes for text.
+func fakeWAV(text string) []byte {
+	frames := int(fakeFormat.SampleRate) * utf8.RuneCountInString(text) * int(fakeRuneDuration/time.Millisecond) / 1000
+	pcm := make([]byte, frames*fakeFormat.blockAlign())
+	for i := 0; i < len(pcm); i += 2 {
+		binary.LittleEndian.PutUint16(pcm[i:], fakeSample)
+	}
+	return encodeWAV(fakeFormat, pcm)
+}
+
+// fakeCall is a call to fakeEngine.Synthesize.
+type fakeCall struct {
+	Text string
+	Opts vpeak.Options
+}
+
+// fakeEngine is an Engine that writes fakeWAV instead of running VOICEPEAK
+// and records its calls.
+type fakeEngine struct {
+	// synthesize, when set, is called before the audio is written, and an
+	// error it returns fails the call.
+	synthesize func(ctx context.Context, text string, opts vpeak.Options) error
+
+	mu       sync.Mutex
+	calls    []fakeCall
+	checkErr error
+}
+
+func (e *fakeEngine) Synthesize(ctx context.Context, text string, opts vpeak.Options) error {
+	e.mu.Lock()
+	e.calls = append(e.calls, fakeCall{Text: text, Opts: opts})
+	e.mu.Unlock()
+
+	if e.synthesize != nil {
+		if err := e.synthesize(ctx, text, opts); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(opts.Output, fakeWAV(text), 0o644)
+}
+
+func (e *fakeEngine) Check() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.checkErr
+}
+
+// setCheckErr changes the error returned by Check.
+func (e *fakeEngine) setCheckErr(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.checkErr = err
+}
+
+// Calls returns the calls made so far.
+func (e *fakeEngine) Calls() []fakeCall {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]fakeCall{}, e.calls...)
+}
+
+// newTestServer creates a Server with cfg, which synthesizes with a new
+// fakeEngine unless cfg sets an Engine, and writes its audio to a temporary
+// directory. The server is closed when the test ends.
+func newTestServer(t *testing.T, cfg Config) (*Server, *fakeEngine) {
+	t.Helper()
+	engine, _ := cfg.Engine.(*fakeEngine)
+	if cfg.Engine == nil {
+		engine = &fakeEngine{}
+		cfg.Engine = engine
+	}
+	if cfg.TmpDir == "" {
+		cfg.TmpDir = t.TempDir()
+	}
+
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s, engine
+}
+
+// jsonRequest returns a request with body encoded as JSON.
+func jsonRequest(t *testing.T, method, target string, body any) *http.Request {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to encode request body: %v", err)
+	}
+	r := httptest.NewRequest(method, target, bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// serve runs r through the routes of s and returns the response.
+func serve(s *Server, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, r)
+	return rec
+}
+
+// errorCode returns the code of the JSON error in body, failing the test when
+// body is not one.
+func errorCode(t *testing.T, body io.Reader) string {
+	t.Helper()
+	var resp errorResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		t.Fatalf("response is not a JSON error: %v", err)
+	}
+	return resp.Error.Code
+}
+
+// wantError checks that rec holds a JSON error with status and code.
+func wantError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, status, rec.Body)
+	}
+	if got := errorCode(t, rec.Body); got != code {
+		t.Errorf("error code = %q, want %q", got, code)
+	}
+}
+
+// wavDuration returns how long the WAV data plays for.
+func wavDuration(t *testing.T, data []byte) time.Duration {
+	t.Helper()
+	format, pcm, err := decodeWAV(data)
+	if err != nil {
+		t.Fatalf("response is not a WAV file: %v", err)
+	}
+	return format.duration(len(pcm))
+}
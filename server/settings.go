@@ -0,0 +1,44 @@
+package server
+
+import "sync"
+
+// Settings holds the settings that can be changed at runtime from the
+// settings page. It is safe for concurrent use.
+type Settings struct {
+	mu     sync.RWMutex
+	values PersistedSettings
+}
+
+// NewSettings returns Settings initialized with values.
+func NewSettings(values PersistedSettings) *Settings {
+	return &Settings{values: values}
+}
+
+// Get returns a copy of the current settings.
+func (s *Settings) Get() PersistedSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values
+}
+
+// Update replaces the current settings with values.
+func (s *Settings) Update(values PersistedSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = values
+}
+
+// Swap replaces the current settings with values and returns the ones it
+// replaced. commit is called with those first, under the same lock, so no
+// other update can come in between; when it returns an error the settings
+// are left unchanged.
+func (s *Settings) Swap(values PersistedSettings, commit func(previous PersistedSettings) error) (PersistedSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.values
+	if err := commit(previous); err != nil {
+		return previous, err
+	}
+	s.values = values
+	return previous, nil
+}
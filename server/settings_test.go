@@ -0,0 +1,216 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// settingsRequest returns a POST to /update-settings with body, carrying a
+// valid CSRF session and token.
+func settingsRequest(t *testing.T, s *Server, body any) *http.Request {
+	t.Helper()
+	const session = "test-session"
+	r := jsonRequest(t, http.MethodPost, "/update-settings", body)
+	r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: session})
+	r.Header.Set(csrfHeader, s.csrfToken(session))
+	return r
+}
+
+func TestUpdateSettingsWhileServing(t *testing.T) {
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "localapps"})
+	const origin = "http://localhost:3000"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			mode := "localapps"
+			if i%2 == 0 {
+				mode = "all"
+			}
+			body := map[string]any{"corsPolicyMode": mode, "allowOrigin": origin, "confirm": true}
+			if rec := serve(s, settingsRequest(t, s, body)); rec.Code != http.StatusOK {
+				t.Errorf("update %d: status = %d, want %d; body: %s", i, rec.Code, http.StatusOK, rec.Body)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/speakers", nil)
+			r.Header.Set("Origin", origin)
+			rec := serve(s, r)
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" && got != origin {
+				t.Errorf("request %d: Access-Control-Allow-Origin = %q, want * or %s", i, got, origin)
+			}
+		}()
+	}
+	wg.Wait()
+
+	rec := serve(s, settingsRequest(t, s, map[string]any{"corsPolicyMode": "localapps", "allowOrigin": ""}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := s.settings.Get(); got.CorsPolicyMode != "localapps" || got.AllowOrigin != "" {
+		t.Errorf("settings = %+v after the last update", got)
+	}
+}
+
+func TestSwitchToAllRequiresConfirmation(t *testing.T) {
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "localapps"})
+
+	var log bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&log, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	rec := serve(s, settingsRequest(t, s, map[string]any{"corsPolicyMode": "all"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeConfirmationRequired)
+	if got := s.settings.Get().CorsPolicyMode; got != "localapps" {
+		t.Fatalf("CORS policy = %q after an unconfirmed switch, want localapps", got)
+	}
+	if strings.Contains(log.String(), "level=WARN") {
+		t.Errorf("unconfirmed switch logged %q", log.String())
+	}
+
+	rec = serve(s, settingsRequest(t, s, map[string]any{"corsPolicyMode": "all", "confirm": true}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := s.settings.Get().CorsPolicyMode; got != "all" {
+		t.Errorf("CORS policy = %q after a confirmed switch, want all", got)
+	}
+	if got := log.String(); !strings.Contains(got, "level=WARN") || !strings.Contains(got, "previous=localapps") {
+		t.Errorf("log = %q, want a warning about the switch", got)
+	}
+
+	// Staying on all needs no confirmation
+	rec = serve(s, settingsRequest(t, s, map[string]any{"corsPolicyMode": "all", "allowOrigin": "https://example.com"}))
+	if rec.Code != http.StatusOK {
+		t.Errorf("update while on all: status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
+
+func TestUpdateSettingsRejectsUnknownMode(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "localapps", ConfigPath: configPath})
+
+	for _, mode := range []string{"All", "", "none"} {
+		rec := serve(s, settingsRequest(t, s, map[string]any{"corsPolicyMode": mode, "confirm": true}))
+		wantError(t, rec, http.StatusBadRequest, errCodeInvalidCorsPolicy)
+	}
+	if got := s.settings.Get().CorsPolicyMode; got != "localapps" {
+		t.Errorf("CORS policy = %q, want localapps", got)
+	}
+	if saved, err := LoadSettings(configPath); err != nil || saved != nil {
+		t.Errorf("saved settings = %+v, %v, want none", saved, err)
+	}
+}
+
+func TestConfirmationMessageLocalized(t *testing.T) {
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "localapps"})
+
+	for lang, want := range map[string]string{
+		"en": localize("en", msgConfirmCorsAll),
+		"ja": localize("ja", msgConfirmCorsAll),
+	} {
+		r := settingsRequest(t, s, map[string]any{"corsPolicyMode": "all"})
+		r.Header.Set("Accept-Language", lang)
+		rec := serve(s, r)
+		var resp errorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Error.Code != errCodeConfirmationRequired || resp.Error.Message != want {
+			t.Errorf("%s: error = %+v, want %s with %q", lang, resp.Error, errCodeConfirmationRequired, want)
+		}
+	}
+}
+
+func TestConcurrentSwitchToAllWarnsOnce(t *testing.T) {
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "localapps"})
+
+	var log syncBuffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&log, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := map[string]any{"corsPolicyMode": "all", "confirm": true}
+			if rec := serve(s, settingsRequest(t, s, body)); rec.Code != http.StatusOK {
+				t.Errorf("update %d: status = %d, want %d; body: %s", i, rec.Code, http.StatusOK, rec.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := strings.Count(log.String(), "CORS policy switched to all"); got != 1 {
+		t.Errorf("switch logged %d times, want once", got)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be written from several goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestGetSettings(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "localapps", AllowedOrigin: "https://app.example", ConfigPath: configPath})
+
+	get := func() PersistedSettings {
+		t.Helper()
+		rec := serve(s, httptest.NewRequest(http.MethodGet, "/settings", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("Cache-Control = %q, want no-store", got)
+		}
+		var settings PersistedSettings
+		if err := json.NewDecoder(rec.Body).Decode(&settings); err != nil {
+			t.Fatal(err)
+		}
+		return settings
+	}
+
+	if got, want := get(), (PersistedSettings{CorsPolicyMode: "localapps", AllowOrigin: "https://app.example"}); got != want {
+		t.Errorf("settings = %+v, want %+v", got, want)
+	}
+
+	want := PersistedSettings{CorsPolicyMode: "all", AllowOrigin: "https://other.example"}
+	if rec := serve(s, settingsRequest(t, s, map[string]any{"corsPolicyMode": want.CorsPolicyMode, "allowOrigin": want.AllowOrigin, "confirm": true})); rec.Code != http.StatusOK {
+		t.Fatalf("update: status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := get(); got != want {
+		t.Errorf("settings after the update = %+v, want %+v", got, want)
+	}
+	if saved, err := LoadSettings(configPath); err != nil || saved == nil || *saved != want {
+		t.Errorf("saved settings = %+v, %v, want %+v", saved, err, want)
+	}
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// Speaker describes a narrator that can be passed as the speaker parameter,
+// either by name or by ID. Speed and Pitch narrow the accepted ranges for
+// this narrator; when nil the global SpeedMin/SpeedMax and PitchMin/PitchMax
+// apply. DefaultEmotion is used instead of a missing or unsupported emotion
+// when SpeakerDefaultEmotions is set.
+type Speaker struct {
+	ID             *int     `json:"id,omitempty"`
+	Name           string   `json:"name"`
+	Label          string   `json:"label,omitempty"`
+	Emotions       []string `json:"emotions,omitempty"`
+	DefaultEmotion string   `json:"default_emotion,omitempty"`
+	Speed          *Range   `json:"speed,omitempty"`
+	Pitch          *Range   `json:"pitch,omitempty"`
+}
+
+// Range is an inclusive range of parameter values.
+type Range struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// validate checks that r is a non-empty range inside [min, max].
+func (r *Range) validate(min, max int) error {
+	if r == nil {
+		return nil
+	}
+	if r.Min > r.Max || r.Min < min || r.Max > max {
+		return fmt.Errorf("range %d to %d must be non-empty and between %d and %d", r.Min, r.Max, min, max)
+	}
+	return nil
+}
+
+// engineEmotions lists the emotions vpeak can apply.
+var engineEmotions = []string{"happy", "fun", "angry", "sad"}
+
+// defaultSpeakers mirrors the narrators supported by vpeak. They get the IDs
+// 0 to 6 in this order, see withSpeakerIDs.
+var defaultSpeakers = []Speaker{
+	{Name: "f1", Label: "Japanese Female 1", Emotions: []string{"happy", "fun", "angry", "sad"}},
+	{Name: "f2", Label: "Japanese Female 2", Emotions: []string{"happy", "fun", "angry", "sad"}},
+	{Name: "f3", Label: "Japanese Female 3", Emotions: []string{"happy", "fun", "angry", "sad"}},
+	{Name: "m1", Label: "Japanese Male 1", Emotions: []string{"happy", "fun", "angry", "sad"}},
+	{Name: "m2", Label: "Japanese Male 2", Emotions: []string{"happy", "fun", "angry", "sad"}},
+	{Name: "m3", Label: "Japanese Male 3", Emotions: []string{"happy", "fun", "angry", "sad"}},
+	{Name: "c", Label: "Japanese Female Child", Emotions: []string{"happy", "fun", "angry", "sad"}},
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// withSpeakerIDs returns a copy of list in which every speaker has an ID.
+// Either all speakers must have one, or none, in which case they are
+// numbered in order from 0. IDs must be unique and not negative.
+func withSpeakerIDs(list []Speaker) ([]Speaker, error) {
+	out := slices.Clone(list)
+	withID := 0
+	for _, sp := range out {
+		if sp.ID != nil {
+			withID++
+		}
+	}
+	if withID == 0 {
+		for i := range out {
+			out[i].ID = intPtr(i)
+		}
+		return out, nil
+	}
+	if withID != len(out) {
+		return nil, errors.New("either every speaker or none must have an id")
+	}
+
+	seen := map[int]string{}
+	for _, sp := range out {
+		if *sp.ID < 0 {
+			return nil, fmt.Errorf("speaker %s: id must not be negative", sp.Name)
+		}
+		if other, ok := seen[*sp.ID]; ok {
+			return nil, fmt.Errorf("speakers %s and %s have the same id %d", other, sp.Name, *sp.ID)
+		}
+		seen[*sp.ID] = sp.Name
+	}
+	return out, nil
+}
+
+// resolveSpeaker returns the name of the speaker given as name, which may
+// also be the ID of a speaker. Names take precedence, so a speakers file may
+// still use numeric names. A number that is neither is rejected with 400.
+func (s *Server) resolveSpeaker(name string) (string, error) {
+	id, err := strconv.Atoi(name)
+	if err != nil || s.validateSpeaker(name) == nil {
+		return name, nil
+	}
+	for _, sp := range s.speakers {
+		if sp.ID != nil && *sp.ID == id {
+			return sp.Name, nil
+		}
+	}
+	return "", newLocalizedError(http.StatusBadRequest, errCodeUnknownSpeaker, msgUnknownSpeaker, name)
+}
+
+// validateSpeaker checks that name is one of the configured speakers. Names
+// are matched exactly because vpeak treats narrator names case-sensitively.
+func (s *Server) validateSpeaker(name string) error {
+	for _, sp := range s.speakers {
+		if sp.Name == name {
+			return nil
+		}
+	}
+	return newLocalizedError(http.StatusBadRequest, errCodeUnknownSpeaker, msgUnknownSpeaker, name)
+}
+
+// speakerEmotions returns the emotions that can be used with the named
+// speaker. A speaker that lists no emotions supports every engine emotion,
+// and emotions vpeak cannot apply are left out.
+func (s *Server) speakerEmotions(name string) []string {
+	for _, sp := range s.speakers {
+		if sp.Name != name {
+			continue
+		}
+		if len(sp.Emotions) == 0 {
+			return engineEmotions
+		}
+		emotions := []string{}
+		for _, e := range sp.Emotions {
+			if slices.Contains(engineEmotions, e) {
+				emotions = append(emotions, e)
+			}
+		}
+		return emotions
+	}
+	return []string{}
+}
+
+// voiceRanges returns the speed and pitch ranges accepted for the named
+// speaker, falling back to the global ranges.
+func (s *Server) voiceRanges(name string) (speed, pitch Range) {
+	speed, pitch = Range{SpeedMin, SpeedMax}, Range{PitchMin, PitchMax}
+	for _, sp := range s.speakers {
+		if sp.Name != name {
+			continue
+		}
+		if sp.Speed != nil {
+			speed = *sp.Speed
+		}
+		if sp.Pitch != nil {
+			pitch = *sp.Pitch
+		}
+	}
+	return speed, pitch
+}
+
+// validateVoiceRanges checks the speed and pitch of query against the ranges
+// of its speaker.
+func (s *Server) validateVoiceRanges(query AudioQuery) error {
+	speed, pitch := s.voiceRanges(query.Speaker)
+	if err := validateOptionalRange(query.Speed, speed.Min, speed.Max); err != nil {
+		return rangeError(errCodeInvalidSpeed, "speed", speed, query.Speaker)
+	}
+	if err := validateOptionalRange(query.Pitch, pitch.Min, pitch.Max); err != nil {
+		return rangeError(errCodeInvalidPitch, "pitch", pitch, query.Speaker)
+	}
+	return nil
+}
+
+// rangeError reports a value of field outside r, naming the speaker when
+// there is one.
+func rangeError(code, field string, r Range, speaker string) *apiError {
+	if speaker == "" {
+		return newLocalizedError(http.StatusBadRequest, code, msgOutOfRange, field, r.Min, r.Max)
+	}
+	return newLocalizedError(http.StatusBadRequest, code, msgSpeakerOutOfRange, field, r.Min, r.Max, speaker)
+}
+
+// defaultEmotion returns the emotion used for the named speaker when a query
+// has none it supports, which is empty unless SpeakerDefaultEmotions is set.
+func (s *Server) defaultEmotion(name string) string {
+	if !s.cfg.SpeakerDefaultEmotions {
+		return ""
+	}
+	for _, sp := range s.speakers {
+		if sp.Name == name {
+			return sp.DefaultEmotion
+		}
+	}
+	return ""
+}
+
+// isValidEmotion reports whether emotion can be used with speaker. An empty
+// speaker lets the engine pick its default narrator, so any engine emotion
+// is accepted.
+func (s *Server) isValidEmotion(speaker, emotion string) bool {
+	if speaker == "" {
+		return slices.Contains(engineEmotions, emotion)
+	}
+	return slices.Contains(s.speakerEmotions(speaker), emotion)
+}
+
+// LoadSpeakers reads a JSON array of speakers from the given file.
+func LoadSpeakers(path string) ([]Speaker, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read speakers file: %w", err)
+	}
+
+	var list []Speaker
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse speakers file: %w", err)
+	}
+	if len(list) == 0 {
+		return nil, errors.New("speakers file lists no speakers")
+	}
+
+	seen := map[string]int{}
+	for i, sp := range list {
+		if sp.Name == "" {
+			return nil, fmt.Errorf("speaker at index %d has no name", i)
+		}
+		// Lookups by name disagree on which of two such entries wins
+		if other, ok := seen[sp.Name]; ok {
+			return nil, fmt.Errorf("speakers at index %d and %d have the same name %s", other, i, sp.Name)
+		}
+		seen[sp.Name] = i
+		if err := sp.Speed.validate(SpeedMin, SpeedMax); err != nil {
+			return nil, fmt.Errorf("speaker %s: invalid speed: %w", sp.Name, err)
+		}
+		if err := sp.Pitch.validate(PitchMin, PitchMax); err != nil {
+			return nil, fmt.Errorf("speaker %s: invalid pitch: %w", sp.Name, err)
+		}
+		if sp.DefaultEmotion != "" && !slices.Contains(engineEmotions, sp.DefaultEmotion) {
+			return nil, fmt.Errorf("speaker %s: unsupported default emotion %s", sp.Name, sp.DefaultEmotion)
+		}
+		if sp.DefaultEmotion != "" && len(sp.Emotions) > 0 && !slices.Contains(sp.Emotions, sp.DefaultEmotion) {
+			return nil, fmt.Errorf("speaker %s: default emotion %s is not in its emotions", sp.Name, sp.DefaultEmotion)
+		}
+	}
+
+	list, err = withSpeakerIDs(list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid speakers file: %w", err)
+	}
+	return list, nil
+}
@@ -0,0 +1,234 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testSpeakers are narrators with different emotion support.
+var testSpeakers = []Speaker{
+	{Name: "all", Label: "Every emotion"},
+	{Name: "cheerful", Label: "Happy only", Emotions: []string{"happy", "sleepy"}},
+}
+
+func TestEmotions(t *testing.T) {
+	s, _ := newTestServer(t, Config{Speakers: testSpeakers})
+
+	for target, want := range map[string][]string{
+		"/emotions":                  engineEmotions,
+		"/emotions?speaker=all":      engineEmotions,
+		"/emotions?speaker=cheerful": {"happy"},
+	} {
+		rec := serve(s, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", target, rec.Code, http.StatusOK)
+		}
+		var got []string
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: response is not a list of emotions: %v", target, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %q, want %q", target, got, want)
+		}
+	}
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/emotions?speaker=nobody", nil))
+	wantError(t, rec, http.StatusBadRequest, errCodeUnknownSpeaker)
+}
+
+func TestUnsupportedEmotionIsDropped(t *testing.T) {
+	s, engine := newTestServer(t, Config{Speakers: testSpeakers})
+
+	for _, tt := range []struct {
+		speaker, emotion, want string
+	}{
+		{"cheerful", "happy", "happy"},
+		{"cheerful", "sad", ""},
+		{"cheerful", "sleepy", ""},
+		{"all", "sad", "sad"},
+		{"all", "bored", ""},
+	} {
+		query := AudioQuery{Text: "こんにちは", Speaker: tt.speaker, Emotion: tt.emotion}
+		if rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)); rec.Code != http.StatusOK {
+			t.Fatalf("%s/%s: status = %d, want %d; body: %s", tt.speaker, tt.emotion, rec.Code, http.StatusOK, rec.Body)
+		}
+		calls := engine.Calls()
+		if got := calls[len(calls)-1].Opts.Emotion; got != tt.want {
+			t.Errorf("%s/%s: engine emotion = %q, want %q", tt.speaker, tt.emotion, got, tt.want)
+		}
+	}
+}
+
+func TestSpeakerRanges(t *testing.T) {
+	speakers := []Speaker{
+		{Name: "slow", Speed: &Range{Min: 50, Max: 100}},
+		{Name: "fast", Speed: &Range{Min: 150, Max: 200}, Pitch: &Range{Min: 0, Max: 100}},
+		{Name: "plain"},
+	}
+	s, _ := newTestServer(t, Config{Speakers: speakers})
+
+	speed := func(v int) *int { return &v }
+	tests := []struct {
+		speaker string
+		speed   int
+		ok      bool
+	}{
+		{"fast", 180, true},
+		{"slow", 180, false},
+		{"slow", 80, true},
+		{"fast", 80, false},
+		{"plain", 80, true},
+		{"plain", 180, true},
+	}
+	for _, tt := range tests {
+		r := jsonRequest(t, http.MethodPost, "/audio_query", AudioQuery{Text: "あ", Speaker: tt.speaker, Speed: speed(tt.speed)})
+		r.Header.Set("Accept-Language", "en")
+		rec := serve(s, r)
+		if tt.ok {
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s at speed %d: status = %d, want %d; body: %s", tt.speaker, tt.speed, rec.Code, http.StatusOK, rec.Body)
+			}
+			continue
+		}
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s at speed %d: status = %d, want %d", tt.speaker, tt.speed, rec.Code, http.StatusBadRequest)
+		}
+		var resp errorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		sp, _ := s.voiceRanges(tt.speaker)
+		want := fmt.Sprintf("between %d and %d for speaker %s", sp.Min, sp.Max, tt.speaker)
+		if resp.Error.Code != errCodeInvalidSpeed || !strings.Contains(resp.Error.Message, want) {
+			t.Errorf("%s at speed %d: error = %+v, want %s naming %q", tt.speaker, tt.speed, resp.Error, errCodeInvalidSpeed, want)
+		}
+	}
+
+	pitch := -50
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/audio_query", AudioQuery{Text: "あ", Speaker: "fast", Pitch: &pitch}))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidPitch)
+	rec = serve(s, jsonRequest(t, http.MethodPost, "/audio_query", AudioQuery{Text: "あ", Speaker: "slow", Pitch: &pitch}))
+	if rec.Code != http.StatusOK {
+		t.Errorf("pitch outside another speaker's range: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestLoadSpeakersRejectsInvalidRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "speakers.json")
+	for _, speed := range []string{`{"min": 100, "max": 50}`, `{"min": 10, "max": 100}`, `{"min": 50, "max": 500}`} {
+		if err := os.WriteFile(path, []byte(`[{"name": "odd", "speed": `+speed+`}]`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadSpeakers(path)
+		if err == nil {
+			t.Errorf("LoadSpeakers accepted the speed range %s", speed)
+		} else if errors.Unwrap(err) == nil {
+			t.Errorf("error %q does not wrap the range error", err)
+		}
+	}
+}
+
+func TestLoadSpeakersRejectsInvalidFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "speakers.json")
+	for name, data := range map[string]string{
+		"empty":          `[]`,
+		"unnamed":        `[{"label": "Nobody"}]`,
+		"duplicate name": `[{"name": "f1"}, {"name": "f1", "speed": {"min": 80, "max": 150}}]`,
+		"duplicate id":   `[{"id": 1, "name": "f1"}, {"id": 1, "name": "f2"}]`,
+	} {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSpeakers(path); err == nil {
+			t.Errorf("%s: LoadSpeakers accepted %s", name, data)
+		}
+	}
+}
+
+func TestSpeakerDefaultEmotion(t *testing.T) {
+	speakers := []Speaker{
+		{Name: "gloomy", DefaultEmotion: "sad"},
+		{Name: "cheerful", Emotions: []string{"happy", "fun"}, DefaultEmotion: "fun"},
+		{Name: "plain"},
+	}
+	tests := []struct {
+		speaker, emotion string
+		enabled          bool
+		want             string
+	}{
+		{"gloomy", "", true, "sad"},
+		{"gloomy", "bored", true, "sad"},
+		{"gloomy", "angry", true, "angry"},
+		{"cheerful", "sad", true, "fun"},
+		{"cheerful", "happy", true, "happy"},
+		{"plain", "", true, ""},
+		{"plain", "bored", true, ""},
+		{"gloomy", "", false, ""},
+		{"cheerful", "sad", false, ""},
+	}
+	for _, tt := range tests {
+		s, engine := newTestServer(t, Config{Speakers: speakers, SpeakerDefaultEmotions: tt.enabled})
+		query := AudioQuery{Text: "こんにちは", Speaker: tt.speaker, Emotion: tt.emotion}
+		if rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)); rec.Code != http.StatusOK {
+			t.Fatalf("%s/%q: status = %d, want %d; body: %s", tt.speaker, tt.emotion, rec.Code, http.StatusOK, rec.Body)
+		}
+		if got := engine.Calls()[0].Opts.Emotion; got != tt.want {
+			t.Errorf("%s/%q with defaults %v: engine emotion = %q, want %q", tt.speaker, tt.emotion, tt.enabled, got, tt.want)
+		}
+	}
+}
+
+func TestSpeakerIDs(t *testing.T) {
+	speakers := []Speaker{
+		{Name: "f1", ID: intPtr(7)},
+		{Name: "m1", ID: intPtr(3)},
+		{Name: "3", ID: intPtr(5)},
+	}
+	s, engine := newTestServer(t, Config{Speakers: speakers})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/speakers", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/speakers: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var listed []Speaker
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]int)
+	for _, sp := range listed {
+		if sp.ID != nil {
+			ids[sp.Name] = *sp.ID
+		}
+	}
+	if want := map[string]int{"f1": 7, "m1": 3, "3": 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("/speakers IDs = %v, want %v", ids, want)
+	}
+
+	// A numeric name takes precedence over the ID it looks like
+	for speaker, want := range map[string]string{"7": "f1", "5": "3", "3": "3", "m1": "m1"} {
+		query := AudioQuery{Text: "こんにちは", Speaker: speaker}
+		if rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)); rec.Code != http.StatusOK {
+			t.Fatalf("speaker %s: status = %d, want %d; body: %s", speaker, rec.Code, http.StatusOK, rec.Body)
+		}
+		calls := engine.Calls()
+		if got := calls[len(calls)-1].Opts.Narrator; got != want {
+			t.Errorf("speaker %s: engine narrator = %q, want %q", speaker, got, want)
+		}
+	}
+
+	n := len(engine.Calls())
+	wantError(t, serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "42"})), http.StatusBadRequest, errCodeUnknownSpeaker)
+	if got := len(engine.Calls()); got != n {
+		t.Errorf("engine called for an unknown speaker ID")
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// staticFS holds the stylesheet and icon shared by the HTML pages.
+//
+//go:embed static/style.css static/favicon.ico
+var staticFS embed.FS
+
+// staticMaxAge is how long browsers may cache static files, in seconds.
+const staticMaxAge = "86400"
+
+// staticHandler serves staticFS below /static/.
+func staticHandler() http.HandlerFunc {
+	sub, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/static/", http.FileServer(http.FS(sub)))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		// FileServer lists directories, which is not wanted here
+		if r.URL.Path == "/static/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age="+staticMaxAge)
+		files.ServeHTTP(w, r)
+	}
+}
+
+func (s *Server) handleFavicon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	data, err := staticFS.ReadFile("static/favicon.ico")
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Header().Set("Cache-Control", "public, max-age="+staticMaxAge)
+	w.Write(data)
+}
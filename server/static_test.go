@@ -0,0 +1,51 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStaticFiles(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		rec := serve(s, httptest.NewRequest(method, "/static/style.css", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", method, rec.Code, http.StatusOK)
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/css") {
+			t.Errorf("%s: Content-Type = %q, want text/css", method, got)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != "public, max-age="+staticMaxAge {
+			t.Errorf("%s: Cache-Control = %q", method, got)
+		}
+		if method == http.MethodGet && rec.Body.Len() == 0 {
+			t.Error("stylesheet is empty")
+		}
+	}
+
+	for target, want := range map[string]int{
+		"/static/":            http.StatusNotFound,
+		"/static/missing.css": http.StatusNotFound,
+	} {
+		if rec := serve(s, httptest.NewRequest(http.MethodGet, target, nil)); rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", target, rec.Code, want)
+		}
+	}
+
+	// Both GET and HEAD are served, so both are named when rejecting others
+	for _, target := range []string{"/static/style.css", "/favicon.ico"} {
+		if rec := serve(s, httptest.NewRequest(http.MethodHead, target, nil)); rec.Code != http.StatusOK {
+			t.Errorf("HEAD %s: status = %d, want %d", target, rec.Code, http.StatusOK)
+		}
+		rec := serve(s, httptest.NewRequest(http.MethodPost, target, nil))
+		if !strings.Contains(rec.Body.String(), http.MethodHead) {
+			t.Errorf("POST %s: body = %s, want it to name HEAD", target, rec.Body)
+		}
+		wantError(t, rec, http.StatusMethodNotAllowed, errCodeMethodNotAllowed)
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage keeps generated audio by key, for the disk cache and the results
+// of /jobs. Implementations must be safe for concurrent use, and Get must
+// report a missing key with an error matching fs.ErrNotExist. Backends
+// shared by several servers, such as object storage, let them reuse each
+// other's audio.
+type Storage interface {
+	Put(key string, data []byte) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// TimedStorage is a Storage that knows when each entry was written. The
+// disk cache needs it to expire entries after CacheTTL; in other storages
+// entries are kept until the storage itself removes them.
+type TimedStorage interface {
+	Storage
+	ModTime(key string) (time.Time, error)
+	Keys() ([]string, error)
+}
+
+// FileStorage stores each key as a file in Dir. It is the default Storage.
+type FileStorage struct {
+	Dir string
+}
+
+// NewFileStorage returns a FileStorage for dir, creating the directory.
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &FileStorage{Dir: dir}, nil
+}
+
+// path returns the file of key. Keys are flat names, so any directory part
+// is dropped.
+func (s *FileStorage) path(key string) string {
+	return filepath.Join(s.Dir, filepath.Base(key))
+}
+
+// Put writes the entry through a temp file and rename so readers never see
+// a partially written file. The directory is created when missing.
+func (s *FileStorage) Put(key string, data []byte) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, ".storage-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s *FileStorage) Get(key string) (io.ReadCloser, error) {
+	return os.Open(s.path(key))
+}
+
+// Delete removes the entry. Deleting a missing key is not an error.
+func (s *FileStorage) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStorage) ModTime(key string) (time.Time, error) {
+	info, err := os.Stat(s.path(key))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Keys lists the entries in Dir, leaving out files being written by Put.
+func (s *FileStorage) Keys() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			keys = append(keys, entry.Name())
+		}
+	}
+	return keys, nil
+}
+
+// readStorage returns the whole entry stored under key.
+func readStorage(storage Storage, key string) ([]byte, error) {
+	rc, err := storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
@@ -0,0 +1,153 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// memoryStorage is a Storage keeping its entries in a map, standing in for
+// a storage shared by several servers.
+type memoryStorage struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{entries: map[string][]byte{}}
+}
+
+func (m *memoryStorage) Put(key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = append([]byte{}, data...)
+	return nil
+}
+
+func (m *memoryStorage) Get(key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.entries[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memoryStorage) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+// Len returns the number of entries.
+func (m *memoryStorage) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+func TestFileStorage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "storage")
+	s, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get("missing.wav"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get of a missing key = %v, want fs.ErrNotExist", err)
+	}
+	if err := s.Put("a.wav", []byte("audio")); err != nil {
+		t.Fatal(err)
+	}
+	// Keys cannot escape the directory
+	if err := s.Put("../b.wav", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.wav")); err != nil {
+		t.Errorf("key with a directory part not stored in the directory: %v", err)
+	}
+	if data, err := readStorage(s, "a.wav"); err != nil || string(data) != "audio" {
+		t.Errorf("readStorage = %q, %v, want the stored data", data, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".storage-1.tmp"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := s.Keys(); err != nil || !slices.Equal(keys, []string{"a.wav", "b.wav"}) {
+		t.Errorf("Keys = %q, %v, want the stored keys only", keys, err)
+	}
+
+	if err := s.Delete("a.wav"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("a.wav"); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+	if _, err := s.Get("a.wav"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get after Delete = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestCacheStorageSharedByServers(t *testing.T) {
+	storage := newMemoryStorage()
+	query := AudioQuery{Text: "こんにちは", Speaker: "f1"}
+
+	first, firstEngine := newTestServer(t, Config{CacheStorage: storage})
+	rec := serve(first, jsonRequest(t, http.MethodPost, "/synthesis", query))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("status = %d, X-Cache = %q, want a cache miss", rec.Code, rec.Header().Get("X-Cache"))
+	}
+	if storage.Len() != 1 {
+		t.Fatalf("storage holds %d entries, want 1", storage.Len())
+	}
+
+	// Another server using the storage reuses the audio
+	second, secondEngine := newTestServer(t, Config{CacheStorage: storage})
+	rec = serve(second, jsonRequest(t, http.MethodPost, "/synthesis", query))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("status = %d, X-Cache = %q, want a cache hit", rec.Code, rec.Header().Get("X-Cache"))
+	}
+	if !bytes.Equal(rec.Body.Bytes(), fakeWAV(query.Text)) {
+		t.Error("cached body is not the WAV written by the engine")
+	}
+	if calls := len(firstEngine.Calls()) + len(secondEngine.Calls()); calls != 1 {
+		t.Errorf("engines called %d times, want 1", calls)
+	}
+}
+
+func TestJobStorage(t *testing.T) {
+	storage := newMemoryStorage()
+	// Not newTestServer, which closes the server itself
+	s, err := New(Config{Engine: &fakeEngine{}, TmpDir: t.TempDir(), JobStorage: storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j := submitJob(t, s, AudioQuery{Text: "こんにちは", Speaker: "f1"})
+	if resp := pollJob(t, s, j.JobID); resp.Status != jobDone {
+		t.Fatalf("job = %+v, want done", resp)
+	}
+	if data, err := readStorage(storage, j.JobID+".wav"); err != nil || !bytes.Equal(data, fakeWAV("こんにちは")) {
+		t.Fatalf("job audio not in the storage: %v", err)
+	}
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/jobs/"+j.JobID+"/audio", nil))
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), fakeWAV("こんにちは")) {
+		t.Errorf("status = %d, want the job audio", rec.Code)
+	}
+
+	s.Close()
+	if storage.Len() != 0 {
+		t.Errorf("storage holds %d entries after Close, want 0", storage.Len())
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// sentenceTerminators end a sentence when splitting text for streaming. A
+// period only ends one when followed by whitespace or the end of the text,
+// see sentenceEnd.
+const sentenceTerminators = "。！？!?.\n"
+
+// splitSentences splits text after each sentence terminator, dropping
+// pieces that are only whitespace.
+func splitSentences(text string) []string {
+	var sentences []string
+	for len(text) > 0 {
+		i := sentenceEnd(text)
+		if sentence := strings.TrimSpace(text[:i]); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		text = text[i:]
+	}
+	return sentences
+}
+
+// sentenceEnd returns the offset just after the first sentence terminator in
+// text, or len(text) when there is none. Periods inside decimals,
+// abbreviations and host names, such as "3.5" or "example.com", do not end a
+// sentence.
+func sentenceEnd(text string) int {
+	for offset := 0; ; {
+		i := strings.IndexAny(text[offset:], sentenceTerminators)
+		if i < 0 {
+			return len(text)
+		}
+		i += offset
+		r, size := utf8.DecodeRuneInString(text[i:])
+		end := i + size
+		if r != '.' || end == len(text) {
+			return end
+		}
+		if next, _ := utf8.DecodeRuneInString(text[end:]); unicode.IsSpace(next) {
+			return end
+		}
+		offset = end
+	}
+}
+
+// disableWriteTimeout lifts the server's WriteTimeout for a long-lived
+// response such as a stream or a WebSocket connection.
+func disableWriteTimeout(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.Warn("Failed to clear write deadline", "error", err)
+	}
+}
+
+// writeEvent writes one Server-Sent Event and flushes it to the client.
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event, data string) error {
+	if event != "" {
+		if _, err := fmt.Fprintf(w, "event: %s\n", event); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
+
+// writeErrorEvent reports err in an "error" event using the JSON error shape.
+func writeErrorEvent(w http.ResponseWriter, flusher http.Flusher, err error) {
+	data, _ := json.Marshal(errorResponse{Error: errorBodyOf(err, responseLang(w))})
+	writeEvent(w, flusher, "error", string(data))
+}
+
+// handleSynthesisStream streams audio over Server-Sent Events. vpeak can only
+// produce whole files, so the text is split into sentences and the PCM of
+// each sentence is sent as soon as it has been synthesized.
+func (s *Server) handleSynthesisStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, "Streaming is not supported")
+		return
+	}
+
+	query, err := queryFromURL(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if query.Text == "" {
+		writeLocalizedError(w, http.StatusBadRequest, errCodeMissingParameters, msgMissingText)
+		return
+	}
+	noteQuery(r.Context(), query)
+	if err := s.validateSynthesisQuery(&query); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	// Sentences are sent as soon as they are ready, before the length of
+	// the whole audio is known, so it cannot be fitted to a duration
+	if query.TargetDurationMs != nil {
+		writeJSONError(w, http.StatusBadRequest, errCodeInvalidDuration, "target_duration_ms is not supported by /synthesis_stream")
+		return
+	}
+
+	disableWriteTimeout(w)
+	// No Content-Length is sent: the size is unknown until the last sentence
+	// is synthesized, so the events go out with chunked encoding
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Keep reverse proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ctx := r.Context()
+	sentFormat := false
+	text := spokenText(query)
+	if query.Kana == "" {
+		// Pieces are streamed as they are ready, so dictionary pauses are
+		// left out here
+		text = s.dictionary.replaceText(text)
+	}
+	sentences := splitSentences(text)
+	for i, sentence := range sentences {
+		// Stop synthesizing once the client has gone away
+		if ctx.Err() != nil {
+			return
+		}
+
+		sentenceQuery := query
+		sentenceQuery.Text = sentence
+		sentenceQuery.Kana = ""
+		wav, _, err := s.synthesizeWAV(ctx, sentenceQuery)
+		if err != nil {
+			writeErrorEvent(w, flusher, err)
+			return
+		}
+		// The padding only goes around the whole stream
+		if i > 0 {
+			sentenceQuery.LeadSilenceMs = nil
+		}
+		if i < len(sentences)-1 {
+			sentenceQuery.TrailSilenceMs = nil
+		}
+		if wav, _, err = s.adjustWAV(wav, sentenceQuery, false); err != nil {
+			writeErrorEvent(w, flusher, err)
+			return
+		}
+
+		format, pcm, err := decodeWAV(wav)
+		if err != nil {
+			writeErrorEvent(w, flusher, fmt.Errorf("Failed to decode audio: %v", err))
+			return
+		}
+
+		if !sentFormat {
+			data, _ := json.Marshal(map[string]int{
+				"sample_rate":     int(format.SampleRate),
+				"channels":        int(format.Channels),
+				"bits_per_sample": int(format.BitsPerSample),
+			})
+			if err := writeEvent(w, flusher, "format", string(data)); err != nil {
+				return
+			}
+			sentFormat = true
+		}
+
+		if err := writeEvent(w, flusher, "", base64.StdEncoding.EncodeToString(pcm)); err != nil {
+			return
+		}
+	}
+
+	writeEvent(w, flusher, "end", "{}")
+}
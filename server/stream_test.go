@@ -0,0 +1,127 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitSentences(t *testing.T) {
+	tests := map[string][]string{
+		"おはよう。こんにちは！":              {"おはよう。", "こんにちは！"},
+		"Hello! How are you? Fine": {"Hello!", "How are you?", "Fine"},
+		"一行目\n\n二行目":               {"一行目", "二行目"},
+		"  。  ":                    {"。"},
+		"":                         nil,
+	}
+	for text, want := range tests {
+		if got := splitSentences(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitSentences(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+// sseEvent is an event read from a Server-Sent Events stream.
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// readEvents parses the events of a Server-Sent Events stream.
+func readEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			events = append(events, current)
+			current = sseEvent{}
+		case strings.HasPrefix(line, "event: "):
+			current.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.Data = strings.TrimPrefix(line, "data: ")
+		default:
+			t.Fatalf("unexpected line in event stream: %q", line)
+		}
+	}
+	return events
+}
+
+func TestSynthesisStream(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+	sentences := []string{"おはよう。", "こんにちは！", "さようなら"}
+
+	target := "/synthesis_stream?" + url.Values{"text": {strings.Join(sentences, "")}, "speaker": {"f1"}}.Encode()
+	rec := serve(s, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	events := readEvents(t, rec.Body.String())
+	if len(events) != len(sentences)+2 {
+		t.Fatalf("got %d events, want a format event, %d chunks and an end event: %+v", len(events), len(sentences), events)
+	}
+	if events[0].Event != "format" || events[0].Data != `{"bits_per_sample":16,"channels":1,"sample_rate":48000}` {
+		t.Errorf("first event = %+v, want the audio format", events[0])
+	}
+	if last := events[len(events)-1]; last.Event != "end" {
+		t.Errorf("last event = %+v, want end", last)
+	}
+	for i, sentence := range sentences {
+		event := events[i+1]
+		pcm, err := base64.StdEncoding.DecodeString(event.Data)
+		if err != nil || event.Event != "" {
+			t.Fatalf("chunk %d = %+v, want base64 data", i, event)
+		}
+		if _, want, _ := decodeWAV(fakeWAV(sentence)); len(pcm) != len(want) {
+			t.Errorf("chunk %d has %d bytes of PCM, want %d", i, len(pcm), len(want))
+		}
+	}
+	if calls := engine.Calls(); len(calls) != len(sentences) {
+		t.Errorf("engine called %d times, want once per sentence", len(calls))
+	}
+}
+
+func TestSynthesisStreamError(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/synthesis_stream?speaker=f1", nil))
+	wantError(t, rec, http.StatusBadRequest, errCodeMissingParameters)
+}
+
+func TestSynthesisStreamRejectsTargetDuration(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/synthesis_stream?text=こんにちは&speaker=f1&target_duration_ms=1000", nil))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidDuration)
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times, want 0", len(calls))
+	}
+}
+
+func TestSplitSentencesKeepsPeriodsInsideWords(t *testing.T) {
+	tests := map[string][]string{
+		"Version 3.5 is out. Visit example.com now.": {"Version 3.5 is out.", "Visit example.com now."},
+		"It costs 1.50.":    {"It costs 1.50."},
+		"...and then. Done": {"...and then.", "Done"},
+	}
+	for text, want := range tests {
+		if got := splitSentences(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitSentences(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,533 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shinshin86/vpeak"
+)
+
+// removeAudioFile deletes a generated audio file unless KeepAudio is set.
+func (s *Server) removeAudioFile(path string) {
+	if s.cfg.KeepAudio {
+		slog.Info("Keeping audio file", "path", path)
+		return
+	}
+	os.Remove(path)
+}
+
+// validateSynthesisQuery sanitizes and checks a query before synthesis. The
+// text is trimmed and must not be empty unless a kana override is given.
+// Unsupported emotions are blanked rather than rejected (see
+// normalizeEmotion), and the server defaults are filled in for a missing
+// speed or pitch.
+func (s *Server) validateSynthesisQuery(query *AudioQuery) error {
+	s.sanitizeQuery(query)
+	if err := trimQueryText(query); err != nil {
+		return err
+	}
+	if query.Text == "" && query.Kana == "" {
+		return newLocalizedError(http.StatusBadRequest, errCodeMissingParameters, msgEmptyText)
+	}
+	if err := convertRomaji(query); err != nil {
+		return err
+	}
+
+	if err := s.applyPreset(query); err != nil {
+		return err
+	}
+
+	speaker, err := s.resolveSpeaker(query.Speaker)
+	if err != nil {
+		return err
+	}
+	query.Speaker = speaker
+
+	if err := s.autoNarrator(query); err != nil {
+		return err
+	}
+
+	if err := s.validateTextLength(query.Text); err != nil {
+		return err
+	}
+
+	// An empty speaker lets the engine use its default narrator, unless
+	// autoNarrator picked one
+	if query.Speaker != "" {
+		if err := s.validateSpeaker(query.Speaker); err != nil {
+			return err
+		}
+	}
+
+	if query.Kana != "" {
+		if err := s.validateTextLength(query.Kana); err != nil {
+			return err
+		}
+		if err := validateKana(query.Kana); err != nil {
+			return err
+		}
+	} else if query.Markup {
+		if err := validateMarkup(query.Text); err != nil {
+			return err
+		}
+	}
+
+	if query.Speed == nil {
+		query.Speed = s.cfg.DefaultSpeed
+	}
+	if query.Pitch == nil {
+		query.Pitch = s.cfg.DefaultPitch
+	}
+
+	if err := s.validateVoiceRanges(*query); err != nil {
+		return err
+	}
+
+	if err := validateOptionalRange(query.EmotionLevel, EmotionLevelMin, EmotionLevelMax); err != nil {
+		return rangeError(errCodeInvalidEmotionLevel, "emotion_level", Range{EmotionLevelMin, EmotionLevelMax}, "")
+	}
+
+	if err := validateSampleRate(query.SampleRate); err != nil {
+		return err
+	}
+
+	if err := validatePCMFormat(query.Channels, query.BitDepth); err != nil {
+		return err
+	}
+
+	if err := validateOptionalRange(query.Volume, VolumeMin, VolumeMax); err != nil {
+		return rangeError(errCodeInvalidVolume, "volume", Range{VolumeMin, VolumeMax}, "")
+	}
+
+	if err := validateOptionalRange(query.TargetDurationMs, TargetDurationMin, TargetDurationMax); err != nil {
+		return rangeError(errCodeInvalidDuration, "target_duration_ms", Range{TargetDurationMin, TargetDurationMax}, "")
+	}
+
+	if err := validateOptionalRange(query.LeadSilenceMs, SilenceMin, SilenceMax); err != nil {
+		return rangeError(errCodeInvalidSilence, "lead_silence_ms", Range{SilenceMin, SilenceMax}, "")
+	}
+
+	if err := validateOptionalRange(query.TrailSilenceMs, SilenceMin, SilenceMax); err != nil {
+		return rangeError(errCodeInvalidSilence, "trail_silence_ms", Range{SilenceMin, SilenceMax}, "")
+	}
+
+	s.normalizeEmotion(query)
+
+	return nil
+}
+
+// validateTextLength rejects text longer than MaxTextLength. Runes are
+// counted rather than bytes so Japanese text gets the same allowance.
+func (s *Server) validateTextLength(text string) error {
+	if s.cfg.MaxTextLength > 0 {
+		if n := utf8.RuneCountInString(text); n > s.cfg.MaxTextLength {
+			return newLocalizedError(http.StatusBadRequest, errCodeTextTooLong, msgTextTooLong, n, s.cfg.MaxTextLength)
+		}
+	}
+	return nil
+}
+
+// synthesizeQuery returns the WAV data for query, with its volume, sample
+// rate, PCM format, silence padding and target duration applied. The second
+// return value reports whether the audio came from the cache.
+func (s *Server) synthesizeQuery(ctx context.Context, query AudioQuery) ([]byte, bool, error) {
+	wav, cached, err := s.synthesizeSegments(ctx, query)
+	if err != nil {
+		return nil, false, err
+	}
+	return s.adjustWAV(wav, query, cached)
+}
+
+// adjustWAV applies the volume, sample rate, PCM format, silence padding and
+// target duration of query to wav.
+func (s *Server) adjustWAV(wav []byte, query AudioQuery, cached bool) ([]byte, bool, error) {
+	var err error
+	if query.Volume != nil {
+		if wav, err = applyVolume(wav, *query.Volume); err != nil {
+			return nil, false, fmt.Errorf("Failed to apply volume: %v", err)
+		}
+	}
+	if query.SampleRate != nil {
+		if wav, err = resampleWAV(wav, *query.SampleRate); err != nil {
+			return nil, false, fmt.Errorf("Failed to resample audio: %v", err)
+		}
+	}
+	if query.Channels != nil || query.BitDepth != nil {
+		if wav, err = convertPCM(wav, query.Channels, query.BitDepth); err != nil {
+			return nil, false, fmt.Errorf("Failed to convert audio format: %v", err)
+		}
+	}
+	if query.LeadSilenceMs != nil || query.TrailSilenceMs != nil {
+		if wav, err = padSilence(wav, millis(query.LeadSilenceMs), millis(query.TrailSilenceMs)); err != nil {
+			return nil, false, fmt.Errorf("Failed to pad audio with silence: %v", err)
+		}
+	}
+	if query.TargetDurationMs != nil {
+		// Fitted last, so the length is exact at the output sample rate
+		if wav, err = fitDuration(wav, time.Duration(*query.TargetDurationMs)*time.Millisecond); err != nil {
+			return nil, false, fmt.Errorf("Failed to fit audio to the target duration: %v", err)
+		}
+	}
+	return wav, cached, nil
+}
+
+// millis converts an optional number of milliseconds to a duration, 0 when
+// it is not set.
+func millis(ms *int) time.Duration {
+	if ms == nil {
+		return 0
+	}
+	return time.Duration(*ms) * time.Millisecond
+}
+
+// synthesizeSegments returns the WAV data for the text of query. Marked-up
+// text, text with dictionary entries that insert pauses, and text containing
+// the SplitOn separator are synthesized segment by segment and joined with
+// the pauses; the result is only reported as cached when every segment was.
+// A kana override is never parsed as markup or changed by the dictionary.
+func (s *Server) synthesizeSegments(ctx context.Context, query AudioQuery) ([]byte, bool, error) {
+	override := query.Kana != ""
+	if override {
+		query.Text, query.Kana, query.Markup = query.Kana, "", false
+	}
+
+	segments := []markupSegment{{Text: query.Text}}
+	if query.Markup {
+		var err error
+		segments, err = parseMarkup(query.Text)
+		if err != nil {
+			return nil, false, newAPIError(http.StatusBadRequest, errCodeInvalidMarkup, err.Error())
+		}
+	}
+	if !override {
+		segments = s.dictionary.apply(segments)
+	}
+	segments = s.splitSegments(segments)
+
+	if len(segments) == 1 && segments[0].Pause == 0 {
+		query.Text = segments[0].Text
+		query.Markup = false
+		return s.synthesizeWAV(ctx, query)
+	}
+
+	parts := make([]audioPart, len(segments))
+	allCached := true
+	for i, seg := range segments {
+		parts[i].Silence = seg.Pause
+		if seg.Text == "" {
+			continue
+		}
+		segQuery := query
+		segQuery.Text = seg.Text
+		segQuery.Markup = false
+		data, cached, err := s.synthesizeWAV(ctx, segQuery)
+		if err != nil {
+			return nil, false, err
+		}
+		parts[i].WAV = data
+		allCached = allCached && cached
+	}
+
+	wav, err := joinWAV(parts)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to join audio segments: %v", err)
+	}
+	return wav, allCached, nil
+}
+
+// splitSegments splits the text of each segment at the SplitOn separator,
+// putting a SegmentGap pause between the pieces. Pieces that are only
+// whitespace are dropped.
+func (s *Server) splitSegments(segments []markupSegment) []markupSegment {
+	if s.cfg.SplitOn == "" {
+		return segments
+	}
+
+	var out []markupSegment
+	for _, seg := range segments {
+		pause := seg.Pause
+		first := true
+		for _, piece := range strings.Split(seg.Text, s.cfg.SplitOn) {
+			piece = strings.TrimSpace(piece)
+			if piece == "" {
+				continue
+			}
+			if !first {
+				pause += s.cfg.SegmentGap
+			}
+			out = append(out, markupSegment{Pause: pause, Text: piece})
+			pause = 0
+			first = false
+		}
+		if first && pause > 0 {
+			// Keep pauses around text that was only whitespace
+			out = append(out, markupSegment{Pause: pause})
+		}
+	}
+	if len(out) == 0 {
+		return segments
+	}
+	return out
+}
+
+// synthesizeWAV returns the WAV data for query, from the cache when possible.
+// The second return value reports whether the data came from the cache.
+// Engine calls are bounded by the synthesis slots, and concurrent requests
+// for the same query share a single engine call. The shared call does not
+// stop when the request that started it goes away; each caller only stops
+// waiting for it when its own ctx is done. The returned slice may be shared
+// with other requests and the cache, so it must not be modified.
+func (s *Server) synthesizeWAV(ctx context.Context, query AudioQuery) ([]byte, bool, error) {
+	key := s.cacheKey(query)
+	if s.memoryCache != nil {
+		if data, ok := s.memoryCache.Get(key); ok {
+			return data, true, nil
+		}
+	}
+
+	if s.diskCache != nil {
+		if data, ok := s.diskCache.Get(key); ok {
+			if s.memoryCache != nil {
+				s.memoryCache.Add(key, data)
+			}
+			return data, true, nil
+		}
+	}
+
+	// An engine error is returned to every waiting caller. generateWAV
+	// applies SynthesisTimeout, so the shared call needs no deadline.
+	ch := s.synthesisGroup.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := s.sharedContext(ctx, 0)
+		defer cancel()
+		data, err := s.generateWAV(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		if s.diskCache != nil {
+			if err := s.diskCache.Add(key, data); err != nil {
+				slog.WarnContext(ctx, "Failed to write cache entry", "key", key, "error", err)
+			}
+		}
+		if s.memoryCache != nil {
+			s.memoryCache.Add(key, data)
+		}
+		return data, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, false, res.Err
+		}
+		return res.Val.([]byte), false, nil
+	case <-ctx.Done():
+		return nil, false, fmt.Errorf("synthesis abandoned: %w", ctx.Err())
+	}
+}
+
+// sharedContext returns a context for work shared by concurrent requests.
+// It keeps the values of ctx but not its cancellation, so that one caller
+// going away does not fail the others. It is cancelled when the server is
+// closed, or after timeout when that is positive.
+func (s *Server) sharedContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	shared := context.WithoutCancel(ctx)
+	cancelTimeout := context.CancelFunc(func() {})
+	if timeout > 0 {
+		shared, cancelTimeout = context.WithTimeout(shared, timeout)
+	}
+	shared, cancel := context.WithCancel(shared)
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-shared.Done():
+		}
+	}()
+	return shared, func() {
+		cancel()
+		cancelTimeout()
+	}
+}
+
+// generateWAV runs the engine for query once a synthesis slot is free and
+// returns the generated WAV data after post-processing. When the engine
+// takes longer than SynthesisTimeout a 504 error is returned and the context
+// of the engine call is cancelled, which stops further retries; the call
+// keeps its slot until it finishes in the background, and its file is then
+// removed.
+func (s *Server) generateWAV(ctx context.Context, query AudioQuery) ([]byte, error) {
+	if !s.acquireSynthesisSlot(ctx) {
+		return nil, newLocalizedError(http.StatusServiceUnavailable, errCodeServerBusy, msgServerBusy)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		path string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// A panic here could not be recovered by the handler, so it is
+		// reported as a failed synthesis instead of crashing the server
+		defer func() {
+			if v := recover(); v != nil {
+				slog.ErrorContext(ctx, "Synthesis panicked", "panic", v, "stack", string(debug.Stack()))
+				done <- result{err: newAPIError(http.StatusInternalServerError, errCodeSynthesisFailed, panicError(v).Error())}
+			}
+		}()
+		path, err := s.generateAudio(ctx, query)
+		done <- result{path, err}
+	}()
+
+	var timeout <-chan time.Time
+	if s.cfg.SynthesisTimeout > 0 {
+		timer := time.NewTimer(s.cfg.SynthesisTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var res result
+	select {
+	case res = <-done:
+		s.releaseSynthesisSlot()
+	case <-timeout:
+		go func() {
+			res := <-done
+			s.releaseSynthesisSlot()
+			if res.err == nil {
+				s.removeAudioFile(res.path)
+			}
+		}()
+		return nil, newAPIError(http.StatusGatewayTimeout, errCodeSynthesisTimeout, fmt.Sprintf("synthesis did not finish within %s", s.cfg.SynthesisTimeout))
+	}
+
+	if res.err != nil {
+		return nil, res.err
+	}
+	defer s.removeAudioFile(res.path)
+
+	data, err := os.ReadFile(res.path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read generated audio: %v", err)
+	}
+	data, err = s.postProcessWAV(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to post-process generated audio: %v", err)
+	}
+	return data, nil
+}
+
+// generateAudio synthesizes query into a new WAV file in TmpDir and returns
+// its path. The caller is responsible for removing the file once it is
+// returned; on errors and panics it is removed here.
+func (s *Server) generateAudio(ctx context.Context, query AudioQuery) (string, error) {
+	// vpeak can only write its output to a file path, so the audio is
+	// generated into a temp file and served from there. CreateTemp picks a
+	// unique name atomically; the engine then overwrites the empty file.
+	file, err := os.CreateTemp(s.cfg.TmpDir, "audio-*.wav")
+	if err != nil {
+		return "", newAPIError(http.StatusInternalServerError, errCodeSynthesisFailed, fmt.Sprintf("Failed to create audio file: %v", err))
+	}
+	outputFileName := file.Name()
+	file.Close()
+
+	defer func() {
+		if v := recover(); v != nil {
+			os.Remove(outputFileName)
+			panic(v)
+		}
+	}()
+
+	opts := vpeak.Options{
+		Narrator: query.Speaker,
+		Emotion:  query.Emotion,
+		Output:   outputFileName,
+		Silent:   true,
+		Speed:    query.Speed,
+		Pitch:    query.Pitch,
+	}
+
+	if s.metrics != nil {
+		s.metrics.synthesisInFlight.Inc()
+		defer s.metrics.synthesisInFlight.Dec()
+	}
+	start := time.Now()
+	err = s.synthesizeWithRetry(ctx, query.Text, opts)
+	slog.DebugContext(ctx, "Synthesis finished",
+		"speaker", query.Speaker,
+		"emotion", query.Emotion,
+		"text_length", utf8.RuneCountInString(query.Text),
+		"duration", time.Since(start),
+		"error", err,
+	)
+	if err != nil {
+		if s.metrics != nil {
+			s.metrics.synthesisErrors.Inc()
+		}
+		os.Remove(outputFileName)
+		return "", engineError(err)
+	}
+
+	return outputFileName, nil
+}
+
+type slotWaitKey struct{}
+
+// withSlotWait marks ctx so that synthesis waits for a free slot for as long
+// as ctx lives, ignoring QueueTimeout. Background jobs use it.
+func withSlotWait(ctx context.Context) context.Context {
+	return context.WithValue(ctx, slotWaitKey{}, true)
+}
+
+// acquireSynthesisSlot waits for a free synthesis slot. It gives up when the
+// queue timeout elapses or the request context is cancelled, in which case
+// false is returned. With a zero timeout it fails immediately when all slots are busy.
+func (s *Server) acquireSynthesisSlot(ctx context.Context) bool {
+	if s.slots == nil {
+		return true
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if wait, _ := ctx.Value(slotWaitKey{}).(bool); wait {
+		select {
+		case s.slots <- struct{}{}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if s.cfg.QueueTimeout <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(s.cfg.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Server) releaseSynthesisSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
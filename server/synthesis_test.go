@@ -0,0 +1,452 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shinshin86/vpeak"
+)
+
+func TestMaxConcurrentRejectsOverflow(t *testing.T) {
+	const limit = 3
+
+	started := make(chan struct{}, limit+1)
+	release := make(chan struct{})
+	engine := &fakeEngine{synthesize: func(ctx context.Context, text string, opts vpeak.Options) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}}
+	s, _ := newTestServer(t, Config{Engine: engine, MaxConcurrent: limit})
+	ts := httptest.NewServer(s.Routes())
+	defer ts.Close()
+
+	post := func(text string) (*http.Response, error) {
+		body := fmt.Sprintf(`{"text": %q, "speaker": "f1"}`, text)
+		return http.Post(ts.URL+"/synthesis", "application/json", strings.NewReader(body))
+	}
+
+	// Different texts, so the requests are not coalesced into one call
+	var wg sync.WaitGroup
+	statuses := make([]int, limit)
+	for i := 0; i < limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := post(fmt.Sprintf("テスト%d", i))
+			if err != nil {
+				t.Errorf("request %d: %v", i, err)
+				return
+			}
+			resp.Body.Close()
+			statuses[i] = resp.StatusCode
+		}()
+	}
+	for i := 0; i < limit; i++ {
+		<-started
+	}
+
+	resp, err := post("あふれる")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("overflow request: status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if code := errorCode(t, resp.Body); code != errCodeServerBusy {
+		t.Errorf("overflow request: error code = %q, want %q", code, errCodeServerBusy)
+	}
+
+	close(release)
+	wg.Wait()
+	for i, status := range statuses {
+		if status != http.StatusOK {
+			t.Errorf("request %d: status = %d, want %d", i, status, http.StatusOK)
+		}
+	}
+}
+
+func TestKeepAudio(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		dir := filepath.Join(t.TempDir(), "audio")
+		s, _ := newTestServer(t, Config{TmpDir: dir, KeepAudio: keep})
+
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("keep %v: status = %d, want %d", keep, rec.Code, http.StatusOK)
+		}
+
+		files, err := filepath.Glob(filepath.Join(dir, "audio-*.wav"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		if keep {
+			want = 1
+		}
+		if len(files) != want {
+			t.Errorf("keep %v: %d audio files left in the output directory, want %d", keep, len(files), want)
+		}
+	}
+}
+
+func TestOutputDirectoryWriteFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "audio")
+	s, _ := newTestServer(t, Config{TmpDir: dir})
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+	wantError(t, rec, http.StatusInternalServerError, errCodeSynthesisFailed)
+}
+
+func TestDefaultSpeedAndPitch(t *testing.T) {
+	s, engine := newTestServer(t, Config{DefaultSpeed: intPtr(130), DefaultPitch: intPtr(-40)})
+
+	for _, query := range []AudioQuery{
+		{Text: "デフォルト", Speaker: "f1"},
+		{Text: "指定あり", Speaker: "f1", Speed: intPtr(90), Pitch: intPtr(10)},
+	} {
+		if rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+
+	calls := engine.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("engine called %d times, want 2", len(calls))
+	}
+	for i, want := range [][2]int{{130, -40}, {90, 10}} {
+		opts := calls[i].Opts
+		if opts.Speed == nil || opts.Pitch == nil || *opts.Speed != want[0] || *opts.Pitch != want[1] {
+			t.Errorf("call %d: speed %v, pitch %v, want %d and %d", i, opts.Speed, opts.Pitch, want[0], want[1])
+		}
+	}
+}
+
+func TestNewRejectsOutOfRangeDefaults(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"speed": {DefaultSpeed: intPtr(SpeedMax + 1)},
+		"pitch": {DefaultPitch: intPtr(PitchMin - 1)},
+	} {
+		cfg.Engine = &fakeEngine{}
+		cfg.TmpDir = t.TempDir()
+		if s, err := New(cfg); err == nil {
+			s.Close()
+			t.Errorf("%s: New succeeded, want an error", name)
+		}
+	}
+}
+
+// blockingEngine returns a fakeEngine whose calls each signal started and
+// then wait for release to be closed.
+func blockingEngine() (engine *fakeEngine, started chan struct{}, release chan struct{}) {
+	started, release = make(chan struct{}, 100), make(chan struct{})
+	engine = &fakeEngine{synthesize: func(ctx context.Context, text string, opts vpeak.Options) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}}
+	return engine, started, release
+}
+
+// joinDelay is how long a test waits for goroutines to start waiting for an
+// engine call that is already running.
+const joinDelay = 100 * time.Millisecond
+
+func TestConcurrentIdenticalQueriesShareOneCall(t *testing.T) {
+	const n = 5
+	engine, started, release := blockingEngine()
+	s, _ := newTestServer(t, Config{Engine: engine})
+	query := AudioQuery{Text: "おなじ", Speaker: "f1"}
+
+	var wg sync.WaitGroup
+	results := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query))
+			if rec.Code != http.StatusOK {
+				t.Errorf("request %d: status = %d, want %d", i, rec.Code, http.StatusOK)
+			}
+			results[i] = rec.Body.Bytes()
+		}()
+	}
+	<-started
+	time.Sleep(joinDelay)
+	close(release)
+	wg.Wait()
+
+	if calls := engine.Calls(); len(calls) != 1 {
+		t.Errorf("engine called %d times, want 1", len(calls))
+	}
+	for i, body := range results {
+		if !bytes.Equal(body, fakeWAV(query.Text)) {
+			t.Errorf("request %d did not get the audio", i)
+		}
+	}
+}
+
+func TestSharedCallErrorReachesEveryWaiter(t *testing.T) {
+	const n = 3
+	started, release := make(chan struct{}, n), make(chan struct{})
+	engine := &fakeEngine{synthesize: func(ctx context.Context, text string, opts vpeak.Options) error {
+		started <- struct{}{}
+		<-release
+		return errors.New("engine crashed")
+	}}
+	s, _ := newTestServer(t, Config{Engine: engine})
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "おなじ", Speaker: "f1"}))
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("request %d: status = %d, want %d", i, rec.Code, http.StatusInternalServerError)
+			}
+		}()
+	}
+	<-started
+	time.Sleep(joinDelay)
+	close(release)
+	wg.Wait()
+
+	if calls := engine.Calls(); len(calls) != 1 {
+		t.Errorf("engine called %d times, want 1", len(calls))
+	}
+}
+
+func TestSharedCallOutlivesFirstCaller(t *testing.T) {
+	engine, started, release := blockingEngine()
+	s, _ := newTestServer(t, Config{Engine: engine})
+	query := AudioQuery{Text: "おなじ", Speaker: "f1"}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, _, err := s.synthesizeWAV(firstCtx, query)
+		first <- err
+	}()
+	<-started
+
+	second := make(chan []byte, 1)
+	go func() {
+		data, _, err := s.synthesizeWAV(context.Background(), query)
+		if err != nil {
+			t.Errorf("second caller: %v", err)
+		}
+		second <- data
+	}()
+	time.Sleep(joinDelay)
+
+	// The first caller stops waiting right away, before the engine is done
+	cancelFirst()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller: error = %v, want context.Canceled", err)
+	}
+
+	close(release)
+	if data := <-second; !bytes.Equal(data, fakeWAV(query.Text)) {
+		t.Error("second caller did not get the audio")
+	}
+	if calls := engine.Calls(); len(calls) != 1 {
+		t.Errorf("engine called %d times, want 1", len(calls))
+	}
+}
+
+func TestSplitOnJoinsSegmentsWithGap(t *testing.T) {
+	const gap = 300 * time.Millisecond
+	s, engine := newTestServer(t, Config{SplitOn: "|", SegmentGap: gap})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "いち| に |  |さん", Speaker: "f1"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	var texts []string
+	for _, call := range engine.Calls() {
+		texts = append(texts, call.Text)
+	}
+	if strings.Join(texts, ",") != "いち,に,さん" {
+		t.Errorf("engine called with %q, want one call per piece", texts)
+	}
+	want := 5*fakeRuneDuration + 2*gap
+	if got := wavDuration(t, rec.Body.Bytes()); got != want {
+		t.Errorf("duration = %s, want %s", got, want)
+	}
+}
+
+func TestNewRejectsNegativeSegmentGap(t *testing.T) {
+	s, err := New(Config{Engine: &fakeEngine{}, TmpDir: t.TempDir(), SegmentGap: -time.Second})
+	if err == nil {
+		s.Close()
+		t.Error("New succeeded, want an error")
+	}
+}
+
+func TestSynthesisTimeout(t *testing.T) {
+	engine, _, release := blockingEngine()
+	dir := t.TempDir()
+	s, _ := newTestServer(t, Config{Engine: engine, TmpDir: dir, SynthesisTimeout: 50 * time.Millisecond})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "おそい", Speaker: "f1"}))
+	wantError(t, rec, http.StatusGatewayTimeout, errCodeSynthesisTimeout)
+
+	// The engine call finishes in the background, and its file is removed
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		files, err := filepath.Glob(filepath.Join(dir, "audio-*.wav"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("audio files left after the timed out synthesis finished: %q", files)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEmotionLevel(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	for _, tt := range []struct {
+		level *int
+		want  string
+	}{
+		{nil, "happy"},
+		{intPtr(50), "happy"},
+		{intPtr(EmotionLevelMax), "happy"},
+		{intPtr(0), ""},
+	} {
+		query := AudioQuery{Text: "こんにちは", Speaker: "f1", Emotion: "happy", EmotionLevel: tt.level}
+		if rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query)); rec.Code != http.StatusOK {
+			t.Fatalf("level %v: status = %d, want %d; body: %s", tt.level, rec.Code, http.StatusOK, rec.Body)
+		}
+		calls := engine.Calls()
+		if got := calls[len(calls)-1].Opts.Emotion; got != tt.want {
+			t.Errorf("level %v: engine emotion = %q, want %q", tt.level, got, tt.want)
+		}
+	}
+
+	for _, level := range []int{EmotionLevelMin - 1, EmotionLevelMax + 1} {
+		query := AudioQuery{Text: "こんにちは", Speaker: "f1", Emotion: "happy", EmotionLevel: intPtr(level)}
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query))
+		wantError(t, rec, http.StatusBadRequest, errCodeInvalidEmotionLevel)
+	}
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/synthesis_stream?text=a&speaker=f1&emotion_level=high", nil))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidEmotionLevel)
+}
+
+func TestDryRun(t *testing.T) {
+	s, engine := newTestServer(t, Config{DefaultSpeed: intPtr(120)})
+
+	query := AudioQuery{Text: " こんにちは ", Speaker: "f1", Emotion: "happy", EmotionLevel: intPtr(0)}
+	withHeader := jsonRequest(t, http.MethodPost, "/synthesis", query)
+	withHeader.Header.Set("X-Dry-Run", "true")
+
+	for name, r := range map[string]*http.Request{
+		"param":  jsonRequest(t, http.MethodPost, "/synthesis?validate=true", query),
+		"header": withHeader,
+	} {
+		rec := serve(s, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d; body: %s", name, rec.Code, http.StatusOK, rec.Body)
+		}
+		var result dryRunResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: response is not a dry run result: %v", name, err)
+		}
+		// The result reports the query as it would be synthesized
+		q := result.Query
+		if !result.Valid || result.Format != "wav" || q.Text != "こんにちは" || q.Emotion != "" || q.Speed == nil || *q.Speed != 120 {
+			t.Errorf("%s: result = %s, want the normalized query", name, rec.Body)
+		}
+	}
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis?validate=true", AudioQuery{Text: "こんにちは", Speaker: "nobody"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeUnknownSpeaker)
+
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times in dry runs, want 0", len(calls))
+	}
+}
+
+func TestMaxTextLengthCountsRunes(t *testing.T) {
+	s, _ := newTestServer(t, Config{MaxTextLength: 5})
+
+	// Five runes in 15 bytes of UTF-8 fit, six do not
+	for text, ok := range map[string]bool{"こんにちは": true, "こんにちは！": false} {
+		for _, r := range []*http.Request{
+			jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: text, Speaker: "f1"}),
+			jsonRequest(t, http.MethodPost, "/audio_query", AudioQuery{Text: text, Speaker: "f1"}),
+		} {
+			rec := serve(s, r)
+			if ok {
+				if rec.Code != http.StatusOK {
+					t.Errorf("%s %q: status = %d, want %d; body: %s", r.URL.Path, text, rec.Code, http.StatusOK, rec.Body)
+				}
+				continue
+			}
+			wantError(t, rec, http.StatusBadRequest, errCodeTextTooLong)
+		}
+	}
+}
+
+func TestEnginePanic(t *testing.T) {
+	dir := t.TempDir()
+	engine := &fakeEngine{synthesize: func(ctx context.Context, text string, opts vpeak.Options) error {
+		if err := os.WriteFile(opts.Output, []byte("partial"), 0o644); err != nil {
+			return err
+		}
+		panic("engine blew up")
+	}}
+	s, _ := newTestServer(t, Config{Engine: engine, TmpDir: dir})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+	wantError(t, rec, http.StatusInternalServerError, errCodeSynthesisFailed)
+
+	files, err := filepath.Glob(filepath.Join(dir, "audio-*.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("audio files left after the panic: %q", files)
+	}
+
+	// The server keeps working
+	engine.synthesize = nil
+	if rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"})); rec.Code != http.StatusOK {
+		t.Errorf("status after the panic = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	handler := s.recoverPanics(func(w http.ResponseWriter, r *http.Request) { panic("handler bug") })
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	wantError(t, rec, http.StatusInternalServerError, errCodeInternal)
+}
@@ -0,0 +1,16 @@
+package server
+
+import (
+	"embed"
+	"html/template"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// Templates are parsed once at startup so a broken template fails fast
+var (
+	indexTemplate      = template.Must(template.ParseFS(templateFS, "templates/index.html"))
+	settingsTemplate   = template.Must(template.ParseFS(templateFS, "templates/settings.html"))
+	playgroundTemplate = template.Must(template.ParseFS(templateFS, "templates/playground.html"))
+)
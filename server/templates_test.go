@@ -0,0 +1,75 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"testing"
+)
+
+var templateCases = []struct {
+	file string
+	tmpl *template.Template
+	data any
+}{
+	{"index.html", indexTemplate, SettingsData{Lang: "ja"}},
+	{"settings.html", settingsTemplate, SettingsData{CorsPolicyMode: "localapps", AllowOrigin: "http://localhost:3000", Lang: "en", CSRFToken: "token"}},
+	{"playground.html", playgroundTemplate, PlaygroundData{Lang: "en", SpeedMin: SpeedMin, SpeedMax: SpeedMax, PitchMin: PitchMin, PitchMax: PitchMax, Speed: 100, RequireAPIKey: true}},
+}
+
+// renderPerRequest renders a template the way the handlers did before the
+// templates were parsed at startup.
+func renderPerRequest(w io.Writer, file string, data any) error {
+	src, err := templateFS.ReadFile("templates/" + file)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(file).Parse(string(src))
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+func TestTemplatesMatchPerRequestParse(t *testing.T) {
+	for _, tc := range templateCases {
+		var want, got bytes.Buffer
+		if err := renderPerRequest(&want, tc.file, tc.data); err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+		if err := tc.tmpl.Execute(&got, tc.data); err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("%s: output of the startup template differs from a per-request parse", tc.file)
+		}
+	}
+}
+
+func BenchmarkTemplatePerRequestParse(b *testing.B) {
+	for _, tc := range templateCases {
+		b.Run(tc.file, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := renderPerRequest(io.Discard, tc.file, tc.data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkTemplateParsedAtStartup(b *testing.B) {
+	for _, tc := range templateCases {
+		b.Run(tc.file, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := tc.tmpl.Execute(io.Discard, tc.data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
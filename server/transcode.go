@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// audioContentTypes maps the supported output formats to their content type.
+var audioContentTypes = map[string]string{
+	"wav": "audio/wav",
+	"mp3": "audio/mpeg",
+	"ogg": "audio/ogg",
+}
+
+// ffmpegCodecs lists the encoder used for each transcoded format.
+var ffmpegCodecs = map[string][]string{
+	"mp3": {"-f", "mp3", "-c:a", "libmp3lame"},
+	"ogg": {"-f", "ogg", "-c:a", "libvorbis"},
+}
+
+// requestedAudioFormat returns the output format asked for by the client.
+// The format query parameter wins over the Accept header; when neither names
+// a supported format, wav is used.
+func requestedAudioFormat(r *http.Request) (string, error) {
+	if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+		if _, ok := audioContentTypes[format]; !ok {
+			return "", newAPIError(http.StatusUnsupportedMediaType, errCodeUnsupportedFormat, fmt.Sprintf("unsupported format: %s", format))
+		}
+		return format, nil
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "audio/wav", "audio/x-wav", "audio/wave":
+			return "wav", nil
+		case "audio/mpeg", "audio/mp3":
+			return "mp3", nil
+		case "audio/ogg":
+			return "ogg", nil
+		}
+	}
+
+	return "wav", nil
+}
+
+// checkTranscoder reports whether the given format can be produced.
+func (s *Server) checkTranscoder(format string) error {
+	if format == "wav" {
+		return nil
+	}
+	if _, err := exec.LookPath(s.cfg.FFmpegPath); err != nil {
+		return newAPIError(http.StatusUnsupportedMediaType, errCodeUnsupportedFormat, fmt.Sprintf("format %s requires ffmpeg: %v", format, err))
+	}
+	return nil
+}
+
+// transcodeCacheKey identifies the transcoded form of wav in format. It is
+// derived from the audio rather than the query so that resampled and joined
+// audio is cached correctly too. The format suffix keeps it apart from the
+// synthesis keys.
+func transcodeCacheKey(wav []byte, format string) string {
+	sum := sha256.Sum256(wav)
+	return hex.EncodeToString(sum[:]) + "." + format
+}
+
+// transcodeCached returns wav converted into format, from the memory cache
+// when possible. Transcoded audio shares the cache and its limits with the
+// WAV data it was made from, so the least recently used entry of either kind
+// is evicted first, and CacheMaxBytes counts the bytes of both. Concurrent requests for the same conversion share a
+// single ffmpeg run, which like a shared synthesis is not stopped by the
+// request that started it going away, see synthesizeWAV.
+func (s *Server) transcodeCached(ctx context.Context, wav []byte, format string) ([]byte, error) {
+	if s.memoryCache == nil {
+		return s.transcodeAudio(ctx, wav, format)
+	}
+
+	key := transcodeCacheKey(wav, format)
+	if data, ok := s.memoryCache.Get(key); ok {
+		return data, nil
+	}
+
+	ch := s.synthesisGroup.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := s.sharedContext(ctx, s.cfg.SynthesisTimeout)
+		defer cancel()
+		data, err := s.transcodeAudio(ctx, wav, format)
+		if err != nil {
+			return nil, err
+		}
+		s.memoryCache.Add(key, data)
+		return data, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("transcoding abandoned: %w", ctx.Err())
+	}
+}
+
+// transcodeAudio converts WAV data into format by piping it through ffmpeg.
+func (s *Server) transcodeAudio(ctx context.Context, wav []byte, format string) ([]byte, error) {
+	args := []string{"-loglevel", "error", "-i", "pipe:0"}
+	args = append(args, ffmpegCodecs[format]...)
+	args = append(args, "pipe:1")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.cfg.FFmpegPath, args...)
+	cmd.Stdin = bytes.NewReader(wav)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// wantsJSONAudio reports whether the client asked for the audio wrapped in a
+// JSON envelope, either with response=json or an Accept: application/json header.
+func wantsJSONAudio(r *http.Request) bool {
+	if r.URL.Query().Get("response") == "json" {
+		return true
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAudioJSON writes {"format":...,"sample_rate":...,"audio":"<base64>"}.
+// The audio is base64-encoded while it is written so no encoded copy of
+// it has to be held in memory.
+func writeAudioJSON(w http.ResponseWriter, audio []byte, format string, sampleRate uint32) error {
+	// The base64 audio is encoded straight into the response, but its size
+	// is known up front, so Content-Length can still be set
+	prefix := fmt.Sprintf(`{"format":%q,"sample_rate":%d,"audio":"`, format, sampleRate)
+	const suffix = "\"}\n"
+	size := len(prefix) + base64.StdEncoding.EncodedLen(len(audio)) + len(suffix)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	if _, err := io.WriteString(w, prefix); err != nil {
+		return err
+	}
+
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+	if _, err := enc.Write(audio); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, suffix)
+	return err
+}
@@ -0,0 +1,159 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSynthesisFormats(t *testing.T) {
+	s, _ := newTestServer(t, Config{FFmpegPath: useFakeFFmpeg(t)})
+
+	tests := []struct {
+		name        string
+		target      string
+		accept      string
+		contentType string
+	}{
+		{"default", "/synthesis", "", "audio/wav"},
+		{"format wav", "/synthesis?format=wav", "", "audio/wav"},
+		{"format mp3", "/synthesis?format=mp3", "", "audio/mpeg"},
+		{"format ogg", "/synthesis?format=ogg", "", "audio/ogg"},
+		{"accept mp3", "/synthesis", "audio/mpeg", "audio/mpeg"},
+		{"accept ogg", "/synthesis", "text/html, audio/ogg;q=0.9", "audio/ogg"},
+		{"format wins over accept", "/synthesis?format=wav", "audio/mpeg", "audio/wav"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := jsonRequest(t, http.MethodPost, tt.target, AudioQuery{Text: "こんにちは", Speaker: "f1"})
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			rec := serve(s, r)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if tt.contentType != "audio/wav" && !strings.HasPrefix(rec.Body.String(), "fake ") {
+				t.Errorf("body = %q, want the ffmpeg output", rec.Body)
+			}
+		})
+	}
+}
+
+func TestTranscodeCache(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "ffmpeg.log")
+	t.Setenv(fakeFFmpegLogEnv, logPath)
+	s, engine := newTestServer(t, Config{FFmpegPath: useFakeFFmpeg(t), CacheSize: 10})
+	query := AudioQuery{Text: "こんにちは", Speaker: "f1"}
+
+	for _, tt := range []struct{ target, contentType string }{
+		{"/synthesis", "audio/wav"},
+		{"/synthesis?format=mp3", "audio/mpeg"},
+		{"/synthesis?format=mp3", "audio/mpeg"},
+		{"/synthesis", "audio/wav"},
+	} {
+		rec := serve(s, jsonRequest(t, http.MethodPost, tt.target, query))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d; body: %s", tt.target, rec.Code, http.StatusOK, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: Content-Type = %q, want %q", tt.target, got, tt.contentType)
+		}
+	}
+
+	if calls := engine.Calls(); len(calls) != 1 {
+		t.Errorf("engine called %d times, want 1", len(calls))
+	}
+	runs, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(runs)); len(got) != 1 || got[0] != "mp3" {
+		t.Errorf("ffmpeg runs = %q, want one mp3 run", got)
+	}
+}
+
+func TestSynthesisRejectsUnknownFormat(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis?format=flac", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+	wantError(t, rec, http.StatusUnsupportedMediaType, errCodeUnsupportedFormat)
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times, want 0", len(calls))
+	}
+}
+
+func TestSynthesisWithoutFFmpeg(t *testing.T) {
+	s, _ := newTestServer(t, Config{FFmpegPath: filepath.Join(t.TempDir(), "missing-ffmpeg")})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis?format=mp3", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+	wantError(t, rec, http.StatusUnsupportedMediaType, errCodeUnsupportedFormat)
+}
+
+func TestSynthesisResponseModes(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	want := fakeWAV("こんにちは")
+
+	t.Run("raw", func(t *testing.T) {
+		rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "audio/wav" {
+			t.Errorf("Content-Type = %q, want audio/wav", got)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), want) {
+			t.Error("body is not the synthesized WAV")
+		}
+	})
+
+	for name, setup := range map[string]func(r *http.Request){
+		"response=json": func(r *http.Request) { r.URL.RawQuery = "response=json" },
+		"accept json":   func(r *http.Request) { r.Header.Set("Accept", "application/json") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"})
+			setup(r)
+			rec := serve(s, r)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			if got, want := rec.Header().Get("Content-Length"), strconv.Itoa(rec.Body.Len()); got != want {
+				t.Errorf("Content-Length = %s, want %s", got, want)
+			}
+
+			var body struct {
+				Audio      string `json:"audio"`
+				Format     string `json:"format"`
+				SampleRate int    `json:"sample_rate"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			audio, err := base64.StdEncoding.DecodeString(body.Audio)
+			if err != nil {
+				t.Fatalf("audio is not base64: %v", err)
+			}
+			if !bytes.Equal(audio, want) {
+				t.Error("audio is not the synthesized WAV")
+			}
+			if body.Format != "wav" || body.SampleRate != 48000 {
+				t.Errorf("format = %q, sample_rate = %d, want wav and 48000", body.Format, body.SampleRate)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"unicode/utf8"
+)
+
+// multipartOverhead is allowed on top of MaxTextBytes for the multipart
+// boundaries and the other form fields.
+const multipartOverhead = 64 << 10
+
+// handleSynthesisFile synthesizes the text of an uploaded file. The other
+// query fields are sent as form fields next to the file part.
+func (s *Server) handleSynthesisFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxTextBytes+multipartOverhead)
+	if err := r.ParseMultipartForm(s.cfg.MaxTextBytes + multipartOverhead); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeTextTooLarge(w, s.cfg.MaxTextBytes)
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("Failed to parse multipart form: %v", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errCodeMissingParameters, "Missing required file part: file")
+		return
+	}
+	defer file.Close()
+
+	text, err := io.ReadAll(io.LimitReader(file, s.cfg.MaxTextBytes+1))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("Failed to read file: %v", err))
+		return
+	}
+	if int64(len(text)) > s.cfg.MaxTextBytes {
+		writeTextTooLarge(w, s.cfg.MaxTextBytes)
+		return
+	}
+	if !utf8.Valid(text) {
+		writeJSONError(w, http.StatusBadRequest, errCodeInvalidBody, "File must be UTF-8 text")
+		return
+	}
+
+	query, err := queryFromValues(r.MultipartForm.Value)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	query.Text = string(text)
+	if query.Text == "" {
+		writeJSONError(w, http.StatusBadRequest, errCodeMissingParameters, "Uploaded file is empty")
+		return
+	}
+
+	s.serveSynthesis(w, r, query)
+}
+
+func writeTextTooLarge(w http.ResponseWriter, limit int64) {
+	writeJSONError(w, http.StatusRequestEntityTooLarge, errCodeTextTooLarge, fmt.Sprintf("Text must be at most %d bytes", limit))
+}
@@ -0,0 +1,80 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// uploadRequest returns a POST to /synthesis_file uploading text with the
+// given form fields.
+func uploadRequest(t *testing.T, text string, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	part, err := mw.CreateFormFile("file", "script.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(text))
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/synthesis_file", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestSynthesisFile(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	rec := serve(s, uploadRequest(t, "ファイルの文章", map[string]string{"speaker": "f2", "emotion": "sad", "speed": "110"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), fakeWAV("ファイルの文章")) {
+		t.Error("body is not the WAV written by the engine")
+	}
+	calls := engine.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("engine called %d times, want 1", len(calls))
+	}
+	if opts := calls[0].Opts; calls[0].Text != "ファイルの文章" || opts.Narrator != "f2" || opts.Emotion != "sad" || opts.Speed == nil || *opts.Speed != 110 {
+		t.Errorf("engine call = %+v, want the file text with the form fields", calls[0])
+	}
+}
+
+func TestSynthesisFileTooLarge(t *testing.T) {
+	const limit = 100
+	s, engine := newTestServer(t, Config{MaxTextBytes: limit})
+
+	// An oversized file is rejected whether or not it fits in the multipart
+	// overhead allowance
+	for _, size := range []int{limit + 1, limit + multipartOverhead + 1} {
+		rec := serve(s, uploadRequest(t, strings.Repeat("a", size), map[string]string{"speaker": "f1"}))
+		wantError(t, rec, http.StatusRequestEntityTooLarge, errCodeTextTooLarge)
+	}
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times, want 0", len(calls))
+	}
+}
+
+func TestSynthesisFileInvalid(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	rec := serve(s, uploadRequest(t, "", map[string]string{"speaker": "f1"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeMissingParameters)
+
+	rec = serve(s, uploadRequest(t, "\xff\xfe", map[string]string{"speaker": "f1"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidBody)
+
+	rec = serve(s, jsonRequest(t, http.MethodPost, "/synthesis_file", AudioQuery{Text: "こんにちは"}))
+	wantError(t, rec, http.StatusBadRequest, errCodeInvalidBody)
+}
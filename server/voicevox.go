@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/shinshin86/vpeakserver/buildinfo"
+)
+
+// voicevoxStyleStride is the number of style IDs reserved for each speaker
+// ID: one for speaking without an emotion and one per engine emotion.
+var voicevoxStyleStride = len(engineEmotions) + 1
+
+const (
+	// voicevoxSampleRate is the outputSamplingRate returned by /audio_query
+	// in VOICEVOX mode, the rate VOICEPEAK writes.
+	voicevoxSampleRate = 48000
+	// voicevoxPitchCents is the vpeak pitch a pitchScale of 1 maps to, so
+	// that the ±0.15 offered by VOICEVOX clients covers the ±300 of vpeak.
+	voicevoxPitchCents = 2000
+)
+
+// voicevoxQuery is the query object of the VOICEVOX engine API. vpeak
+// exposes no accent information, so AccentPhrases is always empty and the
+// text travels in Kana instead of the AquesTalk-style reading VOICEVOX puts
+// there.
+type voicevoxQuery struct {
+	AccentPhrases      []json.RawMessage `json:"accent_phrases"`
+	SpeedScale         float64           `json:"speedScale"`
+	PitchScale         float64           `json:"pitchScale"`
+	IntonationScale    float64           `json:"intonationScale"`
+	VolumeScale        float64           `json:"volumeScale"`
+	PrePhonemeLength   float64           `json:"prePhonemeLength"`
+	PostPhonemeLength  float64           `json:"postPhonemeLength"`
+	OutputSamplingRate int               `json:"outputSamplingRate"`
+	OutputStereo       bool              `json:"outputStereo"`
+	Kana               string            `json:"kana"`
+}
+
+// voicevoxStyle is a style of a speaker in the VOICEVOX /speakers response.
+type voicevoxStyle struct {
+	Name string `json:"name"`
+	ID   int    `json:"id"`
+}
+
+// voicevoxSpeaker is a Speaker with the fields VOICEVOX clients read from
+// /speakers added.
+type voicevoxSpeaker struct {
+	Speaker
+	SpeakerUUID string          `json:"speaker_uuid"`
+	Styles      []voicevoxStyle `json:"styles"`
+	Version     string          `json:"version"`
+}
+
+// voicevoxStyleID parses the speaker parameter of a request in VOICEVOX
+// mode, where it is a style ID. ok is false when the mode is off or the
+// parameter is not an integer, so the request is handled natively.
+func (s *Server) voicevoxStyleID(r *http.Request) (id int, ok bool) {
+	if !s.cfg.VoicevoxCompat {
+		return 0, false
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("speaker"))
+	return id, err == nil
+}
+
+// voicevoxStyle returns the speaker and emotion of a style ID, or a 400
+// error when no speaker has that style.
+func (s *Server) voicevoxStyle(id int) (string, string, error) {
+	if id >= 0 {
+		speakerID, style := id/voicevoxStyleStride, id%voicevoxStyleStride
+		for _, sp := range s.speakers {
+			if *sp.ID != speakerID {
+				continue
+			}
+			if style == 0 {
+				return sp.Name, "", nil
+			}
+			emotion := engineEmotions[style-1]
+			if s.isValidEmotion(sp.Name, emotion) {
+				return sp.Name, emotion, nil
+			}
+		}
+	}
+	return "", "", newLocalizedError(http.StatusBadRequest, errCodeUnknownSpeaker, msgUnknownSpeaker, strconv.Itoa(id))
+}
+
+// voicevoxSpeakers lists the speakers with one style without an emotion and
+// one per supported emotion.
+func (s *Server) voicevoxSpeakers() []voicevoxSpeaker {
+	list := make([]voicevoxSpeaker, len(s.speakers))
+	for i, sp := range s.speakers {
+		base := *sp.ID * voicevoxStyleStride
+		styles := []voicevoxStyle{{Name: "ノーマル", ID: base}}
+		for k, emotion := range engineEmotions {
+			if s.isValidEmotion(sp.Name, emotion) {
+				styles = append(styles, voicevoxStyle{Name: emotion, ID: base + k + 1})
+			}
+		}
+		list[i] = voicevoxSpeaker{
+			Speaker:     sp,
+			SpeakerUUID: uuid.NewSHA1(uuid.NameSpaceURL, []byte("vpeakserver:"+sp.Name)).String(),
+			Styles:      styles,
+			Version:     buildinfo.Version,
+		}
+	}
+	return list
+}
+
+// handleVoicevoxAudioQuery serves /audio_query in VOICEVOX mode, returning
+// a query for the text parameter with the server defaults.
+func (s *Server) handleVoicevoxAudioQuery(w http.ResponseWriter, r *http.Request, id int) {
+	name, emotion, err := s.voicevoxStyle(id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	query := AudioQuery{Text: r.URL.Query().Get("text"), Speaker: name, Emotion: emotion}
+	s.sanitizeQuery(&query)
+	if query.Text == "" {
+		writeLocalizedError(w, http.StatusBadRequest, errCodeMissingParameters, msgMissingText)
+		return
+	}
+	if err := s.validateTextLength(query.Text); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	vq := voicevoxQuery{
+		AccentPhrases:      []json.RawMessage{},
+		SpeedScale:         1,
+		IntonationScale:    1,
+		VolumeScale:        1,
+		PrePhonemeLength:   0.1,
+		PostPhonemeLength:  0.1,
+		OutputSamplingRate: voicevoxSampleRate,
+		Kana:               query.Text,
+	}
+	if s.cfg.DefaultSpeed != nil {
+		vq.SpeedScale = float64(*s.cfg.DefaultSpeed) / 100
+	}
+	if s.cfg.DefaultPitch != nil {
+		vq.PitchScale = float64(*s.cfg.DefaultPitch) / voicevoxPitchCents
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(vq); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed to encode audio query: %v", err))
+		return
+	}
+}
+
+// handleVoicevoxSynthesis serves /synthesis in VOICEVOX mode. The scales of
+// the query are mapped onto speed, pitch and volume; intonationScale, the
+// phoneme lengths and outputStereo have no vpeak equivalent and are ignored.
+func (s *Server) handleVoicevoxSynthesis(w http.ResponseWriter, r *http.Request, id int) {
+	name, emotion, err := s.voicevoxStyle(id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	var vq voicevoxQuery
+	if err := s.decodeJSONBody(w, r, &vq); err != nil {
+		writeAPIError(w, bodyError(err))
+		return
+	}
+	if vq.Kana == "" {
+		writeLocalizedError(w, http.StatusBadRequest, errCodeMissingParameters, msgMissingText)
+		return
+	}
+
+	speed := int(math.Round(vq.SpeedScale * 100))
+	pitch := int(math.Round(vq.PitchScale * voicevoxPitchCents))
+	volume := int(math.Round(vq.VolumeScale * 100))
+	query := AudioQuery{
+		Text:    vq.Kana,
+		Speaker: name,
+		Emotion: emotion,
+		Speed:   &speed,
+		Pitch:   &pitch,
+		Volume:  &volume,
+	}
+	if vq.OutputSamplingRate != 0 {
+		query.SampleRate = &vq.OutputSamplingRate
+	}
+	s.serveSynthesis(w, r, query)
+}
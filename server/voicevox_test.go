@@ -0,0 +1,87 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVoicevoxRoundTrip(t *testing.T) {
+	s, engine := newTestServer(t, Config{VoicevoxCompat: true})
+
+	// Style 18 is m1, the speaker with ID 3, reading angrily
+	rec := serve(s, httptest.NewRequest(http.MethodPost, "/audio_query?text=%E3%81%93%E3%82%93%E3%81%AB%E3%81%A1%E3%81%AF&speaker=18", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/audio_query: status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var vq voicevoxQuery
+	if err := json.NewDecoder(rec.Body).Decode(&vq); err != nil {
+		t.Fatal(err)
+	}
+	if vq.Kana != "こんにちは" || vq.SpeedScale != 1 || vq.PitchScale != 0 || vq.VolumeScale != 1 ||
+		vq.OutputSamplingRate != voicevoxSampleRate || vq.AccentPhrases == nil {
+		t.Errorf("query = %+v, want the text with the default scales", vq)
+	}
+
+	// Clients adjust the scales before sending the query back
+	vq.SpeedScale, vq.PitchScale = 1.5, 0.1
+	rec = serve(s, jsonRequest(t, http.MethodPost, "/synthesis?speaker=18", vq))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/synthesis: status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), fakeWAV("こんにちは")) {
+		t.Error("body is not the WAV written by the engine")
+	}
+
+	calls := engine.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("engine called %d times, want 1", len(calls))
+	}
+	opts := calls[0].Opts
+	if calls[0].Text != "こんにちは" || opts.Narrator != "m1" || opts.Emotion != "angry" ||
+		opts.Speed == nil || *opts.Speed != 150 || opts.Pitch == nil || *opts.Pitch != 200 {
+		t.Errorf("engine call = %+v, want m1 reading angrily at speed 150 and pitch 200", calls[0])
+	}
+}
+
+func TestVoicevoxSpeakers(t *testing.T) {
+	s, _ := newTestServer(t, Config{VoicevoxCompat: true, Speakers: testSpeakers})
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/speakers", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var speakers []voicevoxSpeaker
+	if err := json.NewDecoder(rec.Body).Decode(&speakers); err != nil {
+		t.Fatal(err)
+	}
+	if len(speakers) != len(testSpeakers) {
+		t.Fatalf("got %d speakers, want %d", len(speakers), len(testSpeakers))
+	}
+	// cheerful has ID 1 and only supports happy among the engine emotions
+	want := []voicevoxStyle{{Name: "ノーマル", ID: voicevoxStyleStride}, {Name: "happy", ID: voicevoxStyleStride + 1}}
+	if got := speakers[1].Styles; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("styles of cheerful = %+v, want %+v", got, want)
+	}
+	if speakers[1].SpeakerUUID == "" || speakers[1].SpeakerUUID == speakers[0].SpeakerUUID {
+		t.Errorf("speaker UUIDs %q and %q are not distinct", speakers[0].SpeakerUUID, speakers[1].SpeakerUUID)
+	}
+}
+
+func TestVoicevoxUnknownStyle(t *testing.T) {
+	s, engine := newTestServer(t, Config{VoicevoxCompat: true, Speakers: testSpeakers})
+	vq := voicevoxQuery{SpeedScale: 1, VolumeScale: 1, Kana: "こんにちは"}
+
+	// cheerful cannot be sad, and no speaker has ID 2
+	for _, target := range []string{"/synthesis?speaker=9", "/synthesis?speaker=10", "/synthesis?speaker=-1"} {
+		wantError(t, serve(s, jsonRequest(t, http.MethodPost, target, vq)), http.StatusBadRequest, errCodeUnknownSpeaker)
+	}
+	wantError(t, serve(s, httptest.NewRequest(http.MethodPost, "/audio_query?text=a&speaker=10", nil)), http.StatusBadRequest, errCodeUnknownSpeaker)
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times for unknown styles", len(calls))
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// warmupText is the phrase synthesized, and thrown away, by the warmup.
+const warmupText = "こんにちは"
+
+// warmup runs one synthesis so the engine has loaded its voices before the
+// first request arrives, then marks the server as ready. A failed warmup is
+// only logged; the server becomes ready either way, and /ready still reports
+// an unusable engine. Closing the server cancels the synthesis. Without
+// speakers there is nothing to warm up.
+func (s *Server) warmup() {
+	defer close(s.warmedUp)
+	if len(s.speakers) == 0 {
+		slog.Warn("Engine warmup skipped, no speakers are configured")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	start := time.Now()
+	query := AudioQuery{Text: warmupText, Speaker: s.speakers[0].Name}
+	if _, err := s.generateWAV(ctx, query); err != nil {
+		slog.Warn("Engine warmup failed", "speaker", query.Speaker, "error", err, "duration_ms", float64(time.Since(start).Microseconds())/1000)
+		return
+	}
+	slog.Info("Engine warmed up", "speaker", query.Speaker, "duration_ms", float64(time.Since(start).Microseconds())/1000)
+}
+
+// warmingUp reports whether the warmup is still running.
+func (s *Server) warmingUp() bool {
+	if s.warmedUp == nil {
+		return false
+	}
+	select {
+	case <-s.warmedUp:
+		return false
+	default:
+		return true
+	}
+}
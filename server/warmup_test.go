@@ -0,0 +1,52 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shinshin86/vpeak"
+)
+
+func TestWarmup(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	engine := &fakeEngine{synthesize: func(ctx context.Context, text string, opts vpeak.Options) error {
+		close(started)
+		<-release
+		return nil
+	}}
+	s, _ := newTestServer(t, Config{Engine: engine, Warmup: true})
+
+	<-started
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status during warmup = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	close(release)
+	<-s.warmedUp
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status after warmup = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	calls := engine.Calls()
+	if len(calls) != 1 || calls[0].Text != warmupText || calls[0].Opts.Narrator != s.speakers[0].Name {
+		t.Errorf("engine calls = %+v, want one warmup of %q by %s", calls, warmupText, s.speakers[0].Name)
+	}
+}
+
+func TestWarmupDisabled(t *testing.T) {
+	s, engine := newTestServer(t, Config{})
+
+	if rec := serve(s, httptest.NewRequest(http.MethodGet, "/ready", nil)); rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if calls := engine.Calls(); len(calls) != 0 {
+		t.Errorf("engine called %d times without warmup", len(calls))
+	}
+}
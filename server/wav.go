@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// wavFormat holds the fields of the WAV "fmt " chunk that clients care about.
+type wavFormat struct {
+	AudioFormat   uint16
+	Channels      uint16
+	SampleRate    uint32
+	BitsPerSample uint16
+}
+
+// readWAVFormat parses the RIFF header and returns the contents of the
+// "fmt " chunk. Chunks before it are skipped.
+func readWAVFormat(r io.Reader) (wavFormat, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return wavFormat{}, fmt.Errorf("failed to read RIFF header: %w", err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return wavFormat{}, errors.New("not a RIFF/WAVE file")
+	}
+
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return wavFormat{}, fmt.Errorf("fmt chunk not found: %w", err)
+		}
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		if string(chunk[0:4]) != "fmt " {
+			// Chunks are padded to an even number of bytes
+			if _, err := io.CopyN(io.Discard, r, int64(size)+int64(size%2)); err != nil {
+				return wavFormat{}, fmt.Errorf("failed to skip chunk: %w", err)
+			}
+			continue
+		}
+
+		if size < 16 {
+			return wavFormat{}, fmt.Errorf("fmt chunk too small: %d bytes", size)
+		}
+		var body [16]byte
+		if _, err := io.ReadFull(r, body[:]); err != nil {
+			return wavFormat{}, fmt.Errorf("failed to read fmt chunk: %w", err)
+		}
+
+		return wavFormat{
+			AudioFormat:   binary.LittleEndian.Uint16(body[0:2]),
+			Channels:      binary.LittleEndian.Uint16(body[2:4]),
+			SampleRate:    binary.LittleEndian.Uint32(body[4:8]),
+			BitsPerSample: binary.LittleEndian.Uint16(body[14:16]),
+		}, nil
+	}
+}
+
+// blockAlign returns the size in bytes of one sample frame.
+func (f wavFormat) blockAlign() int {
+	return int(f.Channels) * int(f.BitsPerSample) / 8
+}
+
+// duration returns how long pcm bytes of audio in f play for.
+func (f wavFormat) duration(pcmLen int) time.Duration {
+	if f.SampleRate == 0 || f.blockAlign() == 0 {
+		return 0
+	}
+	frames := int64(pcmLen / f.blockAlign())
+	return time.Duration(frames * int64(time.Second) / int64(f.SampleRate))
+}
+
+// decodeWAV returns the format and the PCM samples of the "data" chunk.
+// The returned samples share memory with data.
+func decodeWAV(data []byte) (wavFormat, []byte, error) {
+	format, err := readWAVFormat(bytes.NewReader(data))
+	if err != nil {
+		return wavFormat{}, nil, err
+	}
+	if format.AudioFormat != 1 {
+		return wavFormat{}, nil, fmt.Errorf("unsupported WAV encoding %d: only PCM is supported", format.AudioFormat)
+	}
+	if format.Channels == 0 || format.BitsPerSample == 0 || format.BitsPerSample%8 != 0 {
+		return wavFormat{}, nil, fmt.Errorf("unsupported WAV layout: %d channels, %d bits", format.Channels, format.BitsPerSample)
+	}
+
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		start := offset + 8
+		if id == "data" {
+			// Some encoders write a placeholder size when streaming, so the
+			// chunk is clamped to the end of the buffer
+			end := start + size
+			if size < 0 || end > len(data) {
+				end = len(data)
+			}
+			pcm := data[start:end]
+			return format, pcm[:len(pcm)-len(pcm)%format.blockAlign()], nil
+		}
+		offset = start + size + size%2
+	}
+	return wavFormat{}, nil, errors.New("data chunk not found")
+}
+
+// encodeWAV returns a new WAV file holding pcm with a canonical 44-byte header.
+func encodeWAV(format wavFormat, pcm []byte) []byte {
+	out := make([]byte, 44+len(pcm))
+	copy(out[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(out[4:8], uint32(36+len(pcm)))
+	copy(out[8:12], "WAVE")
+	copy(out[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(out[16:20], 16)
+	binary.LittleEndian.PutUint16(out[20:22], format.AudioFormat)
+	binary.LittleEndian.PutUint16(out[22:24], format.Channels)
+	binary.LittleEndian.PutUint32(out[24:28], format.SampleRate)
+	binary.LittleEndian.PutUint32(out[28:32], format.SampleRate*uint32(format.blockAlign()))
+	binary.LittleEndian.PutUint16(out[32:34], uint16(format.blockAlign()))
+	binary.LittleEndian.PutUint16(out[34:36], format.BitsPerSample)
+	copy(out[36:40], "data")
+	binary.LittleEndian.PutUint32(out[40:44], uint32(len(pcm)))
+	copy(out[44:], pcm)
+	return out
+}
+
+// silencePCM returns d worth of silent samples in format.
+func silencePCM(format wavFormat, d time.Duration) []byte {
+	frames := int(int64(format.SampleRate) * int64(d) / int64(time.Second))
+	pcm := make([]byte, frames*format.blockAlign())
+	if format.BitsPerSample == 8 {
+		// 8-bit PCM is unsigned, so silence is the midpoint
+		for i := range pcm {
+			pcm[i] = 0x80
+		}
+	}
+	return pcm
+}
+
+// audioPart is one piece of a joined WAV: Silence is inserted before WAV,
+// which may be nil to contribute silence only.
+type audioPart struct {
+	Silence time.Duration
+	WAV     []byte
+}
+
+// joinWAV concatenates the parts into a single new WAV file. Every WAV must
+// have the same format; the inputs are not modified.
+func joinWAV(parts []audioPart) ([]byte, error) {
+	var format wavFormat
+	var found bool
+	segments := make([][]byte, len(parts))
+	for i, part := range parts {
+		if part.WAV == nil {
+			continue
+		}
+		f, pcm, err := decodeWAV(part.WAV)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i, err)
+		}
+		if !found {
+			format, found = f, true
+		} else if f != format {
+			return nil, fmt.Errorf("segment %d: format %+v does not match %+v", i, f, format)
+		}
+		segments[i] = pcm
+	}
+	if !found {
+		return nil, errors.New("no audio to join")
+	}
+
+	var pcm bytes.Buffer
+	for i, part := range parts {
+		if part.Silence > 0 {
+			pcm.Write(silencePCM(format, part.Silence))
+		}
+		pcm.Write(segments[i])
+	}
+	return encodeWAV(format, pcm.Bytes()), nil
+}
@@ -0,0 +1,109 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"testing"
+)
+
+// withChunkBefore returns wav with a chunk of the given ID and size inserted
+// before its "fmt " chunk, as some encoders write a LIST chunk first.
+func withChunkBefore(wav []byte, id string, size int) []byte {
+	chunk := make([]byte, 8+size+size%2)
+	copy(chunk, id)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(size))
+
+	out := append(append(append([]byte{}, wav[:12]...), chunk...), wav[12:]...)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+func TestReadWAVFormat(t *testing.T) {
+	want := wavFormat{AudioFormat: 1, Channels: 2, SampleRate: 22050, BitsPerSample: 24}
+	fixture := encodeWAV(want, make([]byte, 6*100))
+
+	tests := map[string][]byte{
+		"canonical":        fixture,
+		"chunk before fmt": withChunkBefore(fixture, "LIST", 7),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := readWAVFormat(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("readWAVFormat = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestReadWAVFormatRejectsOtherFiles(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":    nil,
+		"not riff": []byte("ID3\x03\x00\x00\x00\x00\x00\x00\x00\x00"),
+		"no fmt":   []byte("RIFF\x04\x00\x00\x00WAVE"),
+	} {
+		if _, err := readWAVFormat(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: readWAVFormat succeeded, want an error", name)
+		}
+	}
+}
+
+func TestSynthesisAudioHeaders(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis", AudioQuery{Text: "こんにちは", Speaker: "f1"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":            "audio/wav",
+		"X-Audio-Sample-Rate":     "48000",
+		"X-Audio-Channels":        "1",
+		"X-Audio-Bits-Per-Sample": "16",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if !bytes.Equal(rec.Body.Bytes(), fakeWAV("こんにちは")) {
+		t.Error("body is not the WAV written by the engine")
+	}
+}
+
+func TestSynthesisMetadata(t *testing.T) {
+	s, engine := newTestServer(t, Config{CacheSize: 10})
+	query := AudioQuery{Text: "こんにちは", Speaker: "f1"}
+
+	rec := serve(s, jsonRequest(t, http.MethodPost, "/synthesis?meta=true", query))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusNoContent, rec.Body)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("metadata response has a %d byte body", rec.Body.Len())
+	}
+	// fakeWAV speaks for 10ms per rune
+	for header, want := range map[string]string{
+		"X-Audio-Duration-Ms": "50",
+		"X-Audio-Sample-Rate": "48000",
+		"X-Audio-Channels":    "1",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	// The audio fetched afterwards comes from the cache
+	rec = serve(s, jsonRequest(t, http.MethodPost, "/synthesis", query))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("status = %d, X-Cache = %q, want a cache hit", rec.Code, rec.Header().Get("X-Cache"))
+	}
+	if calls := engine.Calls(); len(calls) != 1 {
+		t.Errorf("engine called %d times, want 1", len(calls))
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsQueueSize is how many queries a connection may queue behind the one
+// being synthesized.
+const wsQueueSize = 16
+
+// wsRequest is a message sent by a WebSocket client. Type is "synthesize"
+// (or empty) for a query and "cancel" to stop the current synthesis.
+type wsRequest struct {
+	Type string `json:"type"`
+	AudioQuery
+}
+
+// wsResponse is a text message sent to a WebSocket client. Audio itself is
+// sent as a binary message between "start" and "end".
+type wsResponse struct {
+	Type  string     `json:"type"`
+	ID    string     `json:"id,omitempty"`
+	Error *errorBody `json:"error,omitempty"`
+}
+
+// wsConn serializes writes to a WebSocket connection, which may only have
+// one writer at a time.
+type wsConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+	// lang is the language of error messages.
+	lang string
+}
+
+func (c *wsConn) writeJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+func (c *wsConn) writeBinary(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// writeError reports err without closing the connection.
+func (c *wsConn) writeError(id string, err error) error {
+	body := errorBodyOf(err, c.lang)
+	return c.writeJSON(wsResponse{Type: "error", ID: id, Error: &body})
+}
+
+// checkWebSocketOrigin applies the CORS policy to WebSocket upgrades, which
+// browsers do not subject to CORS themselves.
+func (s *Server) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	settings := s.settings.Get()
+	if settings.CorsPolicyMode == "all" {
+		return true
+	}
+	return settings.CorsPolicyMode == "localapps" && s.localAppOrigin(settings, origin)
+}
+
+// handleWebSocketSynthesis lets a client send queries over a WebSocket and
+// receive the audio of each as a binary message. Queries are synthesized one
+// at a time in the order they arrive, and each counts against the rate limit
+// of the client like a request to /synthesis.
+func (s *Server) handleWebSocketSynthesis(w http.ResponseWriter, r *http.Request) {
+	// The hijacked connection keeps the deadlines of the server timeouts,
+	// which would end long-lived connections
+	disableWriteTimeout(w)
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkWebSocketOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an HTTP error
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Time{})
+	conn.SetReadLimit(s.cfg.MaxRequestBytes)
+
+	c := &wsConn{conn: conn, lang: messageLang(r)}
+	queue := make(chan AudioQuery, wsQueueSize)
+
+	ctx, cancelConn := context.WithCancel(r.Context())
+	defer cancelConn()
+
+	var (
+		mu            sync.Mutex
+		cancelCurrent context.CancelFunc
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for query := range queue {
+			jobCtx, cancel := context.WithCancel(ctx)
+			mu.Lock()
+			cancelCurrent = cancel
+			mu.Unlock()
+
+			s.synthesizeForWebSocket(ctx, jobCtx.Done(), c, query)
+
+			mu.Lock()
+			cancelCurrent = nil
+			mu.Unlock()
+			cancel()
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.writeError("", newAPIError(http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("Failed to decode message: %v", err)))
+			continue
+		}
+
+		switch req.Type {
+		case "cancel":
+			mu.Lock()
+			if cancelCurrent != nil {
+				cancelCurrent()
+			}
+			mu.Unlock()
+		case "", "synthesize":
+			query := req.AudioQuery
+			if s.rateLimited(r) > 0 {
+				c.writeError(query.ID, newLocalizedError(http.StatusTooManyRequests, errCodeRateLimited, msgRateLimited))
+				continue
+			}
+			if err := s.validateSynthesisQuery(&query); err != nil {
+				c.writeError(query.ID, err)
+				continue
+			}
+			select {
+			case queue <- query:
+			default:
+				c.writeError(query.ID, newAPIError(http.StatusServiceUnavailable, errCodeServerBusy, "too many queued queries"))
+			}
+		default:
+			c.writeError(req.ID, newAPIError(http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("unknown message type: %s", req.Type)))
+		}
+	}
+
+	cancelConn()
+	close(queue)
+	<-done
+}
+
+// synthesizeForWebSocket synthesizes query and sends the result, unless
+// cancelled is closed first. vpeak calls cannot be interrupted, so a
+// cancelled synthesis is still waited for, and its audio discarded, so that
+// a connection never runs more than one at a time. ctx is only done when the
+// connection has ended.
+func (s *Server) synthesizeForWebSocket(ctx context.Context, cancelled <-chan struct{}, c *wsConn, query AudioQuery) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	type result struct {
+		wav []byte
+		err error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		wav, _, err := s.synthesizeQuery(ctx, query)
+		resultCh <- result{wav, err}
+	}()
+
+	var res result
+	select {
+	case res = <-resultCh:
+	case <-ctx.Done():
+		return
+	case <-cancelled:
+		c.writeJSON(wsResponse{Type: "cancelled", ID: query.ID})
+		select {
+		case <-resultCh:
+		case <-ctx.Done():
+		}
+		return
+	}
+
+	if res.err != nil {
+		c.writeError(query.ID, res.err)
+		return
+	}
+
+	if err := c.writeJSON(wsResponse{Type: "start", ID: query.ID}); err != nil {
+		return
+	}
+	if err := c.writeBinary(res.wav); err != nil {
+		slog.WarnContext(ctx, "Failed to send WebSocket audio", "error", err)
+		return
+	}
+	c.writeJSON(wsResponse{Type: "end", ID: query.ID})
+}
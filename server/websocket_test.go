@@ -0,0 +1,142 @@
+//go:build darwin || windows
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialWebSocket opens a WebSocket connection to /ws/synthesis on s.
+func dialWebSocket(t *testing.T, s *Server) *websocket.Conn {
+	t.Helper()
+	ts := httptest.NewServer(s.Routes())
+	t.Cleanup(ts.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws/synthesis", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	return conn
+}
+
+// readResponse reads a text message and checks its type and ID.
+func readResponse(t *testing.T, conn *websocket.Conn, typ, id string) wsResponse {
+	t.Helper()
+	var resp wsResponse
+	if err := conn.ReadJSON(&resp); err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	if resp.Type != typ || resp.ID != id {
+		t.Fatalf("message = %+v, want type %q for %q", resp, typ, id)
+	}
+	return resp
+}
+
+// readAudio reads the start, audio and end messages sent for a query.
+func readAudio(t *testing.T, conn *websocket.Conn, id string) []byte {
+	t.Helper()
+	readResponse(t, conn, "start", id)
+	kind, data, err := conn.ReadMessage()
+	if err != nil || kind != websocket.BinaryMessage {
+		t.Fatalf("audio message: type %d, error %v, want binary data", kind, err)
+	}
+	readResponse(t, conn, "end", id)
+	return data
+}
+
+func TestWebSocketSynthesis(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	conn := dialWebSocket(t, s)
+
+	for _, text := range []string{"いち", "に"} {
+		if err := conn.WriteJSON(wsRequest{Type: "synthesize", AudioQuery: AudioQuery{ID: text, Text: text, Speaker: "f1"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, text := range []string{"いち", "に"} {
+		if data := readAudio(t, conn, text); !bytes.Equal(data, fakeWAV(text)) {
+			t.Errorf("%s: audio is not the WAV written by the engine", text)
+		}
+	}
+}
+
+func TestWebSocketErrorsKeepConnectionOpen(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	conn := dialWebSocket(t, s)
+
+	conn.WriteMessage(websocket.TextMessage, []byte("not json"))
+	if resp := readResponse(t, conn, "error", ""); resp.Error.Code != errCodeInvalidBody {
+		t.Errorf("error code = %q, want %q", resp.Error.Code, errCodeInvalidBody)
+	}
+
+	conn.WriteJSON(wsRequest{AudioQuery: AudioQuery{ID: "bad", Text: "こんにちは", Speaker: "f1", Speed: intPtr(SpeedMax + 1)}})
+	if resp := readResponse(t, conn, "error", "bad"); resp.Error.Code != errCodeInvalidSpeed {
+		t.Errorf("error code = %q, want %q", resp.Error.Code, errCodeInvalidSpeed)
+	}
+
+	conn.WriteJSON(wsRequest{AudioQuery: AudioQuery{ID: "good", Text: "こんにちは", Speaker: "f1"}})
+	readAudio(t, conn, "good")
+}
+
+func TestWebSocketCancelWaitsForAbandonedSynthesis(t *testing.T) {
+	engine, started, release := blockingEngine()
+	s, _ := newTestServer(t, Config{Engine: engine})
+	conn := dialWebSocket(t, s)
+
+	conn.WriteJSON(wsRequest{AudioQuery: AudioQuery{ID: "first", Text: "いち", Speaker: "f1"}})
+	<-started
+	conn.WriteJSON(wsRequest{Type: "cancel"})
+	readResponse(t, conn, "cancelled", "first")
+
+	// The next query waits for the abandoned engine call to finish
+	conn.WriteJSON(wsRequest{AudioQuery: AudioQuery{ID: "second", Text: "に", Speaker: "f1"}})
+	time.Sleep(joinDelay)
+	if calls := engine.Calls(); len(calls) != 1 {
+		t.Fatalf("engine called %d times while the cancelled synthesis was running, want 1", len(calls))
+	}
+
+	close(release)
+	if data := readAudio(t, conn, "second"); !bytes.Equal(data, fakeWAV("に")) {
+		t.Error("audio is not the WAV of the second query")
+	}
+}
+
+func TestWebSocketRateLimitsEachQuery(t *testing.T) {
+	// The upgrade request takes one token and the first query the other
+	s, _ := newTestServer(t, Config{RateLimit: 0.01, RateBurst: 2})
+	conn := dialWebSocket(t, s)
+
+	conn.WriteJSON(wsRequest{AudioQuery: AudioQuery{ID: "first", Text: "いち", Speaker: "f1"}})
+	readAudio(t, conn, "first")
+
+	conn.WriteJSON(wsRequest{AudioQuery: AudioQuery{ID: "second", Text: "に", Speaker: "f1"}})
+	if resp := readResponse(t, conn, "error", "second"); resp.Error.Code != errCodeRateLimited {
+		t.Errorf("error code = %q, want %q", resp.Error.Code, errCodeRateLimited)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	s, _ := newTestServer(t, Config{CorsPolicyMode: "localapps"})
+	ts := httptest.NewServer(s.Routes())
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/synthesis"
+
+	for origin, want := range map[string]bool{"http://localhost:3000": true, "https://example.com": false} {
+		conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+		if err == nil {
+			conn.Close()
+		}
+		if got := err == nil; got != want {
+			t.Errorf("%s: connected %v, want %v (response %v)", origin, got, want, resp)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// buildTLSConfig validates the TLS flags. It returns nil when TLS is not
+// configured, in which case the server uses plain HTTP.
+func buildTLSConfig(certFile, keyFile, minVersion string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both -tls-cert and -tls-key must be set to enable TLS")
+	}
+
+	for _, path := range []string{certFile, keyFile} {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read TLS file: %w", err)
+		}
+		f.Close()
+	}
+
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid TLS minimum version %q: must be 1.0, 1.1, 1.2 or 1.3", minVersion)
+	}
+
+	return &tls.Config{MinVersion: version}, nil
+}